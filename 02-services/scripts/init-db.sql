@@ -18,6 +18,7 @@
 -- Enable required extensions
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 CREATE EXTENSION IF NOT EXISTS "pgcrypto";
+CREATE EXTENSION IF NOT EXISTS "citext";
 
 -- ============================================================================
 -- Users Schema (User Service)
@@ -26,8 +27,8 @@ CREATE EXTENSION IF NOT EXISTS "pgcrypto";
 -- Users table
 CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    email VARCHAR(255) UNIQUE NOT NULL,
-    username VARCHAR(100) UNIQUE NOT NULL,
+    email CITEXT UNIQUE NOT NULL,        -- unique regardless of case
+    username CITEXT UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
     first_name VARCHAR(100),
     last_name VARCHAR(100),
@@ -37,9 +38,13 @@ CREATE TABLE IF NOT EXISTS users (
     is_verified BOOLEAN DEFAULT false,
     role VARCHAR(50) DEFAULT 'user',
     last_login_at TIMESTAMP,
+    version BIGINT NOT NULL DEFAULT 1,   -- incremented by every update, sent as the ETag
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    deleted_at TIMESTAMP
+    deleted_at TIMESTAMP,
+    password_changed_at TIMESTAMP,       -- tokens issued before it are rejected
+    failed_logins INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP
 );
 
 -- Indexes for users table
@@ -59,12 +64,53 @@ CREATE TABLE IF NOT EXISTS user_profiles (
     timezone VARCHAR(50),
     language VARCHAR(10) DEFAULT 'en',
     preferences JSONB DEFAULT '{}',
+    version BIGINT NOT NULL DEFAULT 1,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Index for user_profiles
 CREATE UNIQUE INDEX IF NOT EXISTS idx_user_profiles_user_id ON user_profiles(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_profiles_preferences ON user_profiles USING GIN (preferences);
+
+-- Previous password hashes, so users do not choose them again
+CREATE TABLE IF NOT EXISTS password_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_user_id ON password_history(user_id);
+
+-- Pending email changes, confirmed with a token sent to the new address
+-- (only its SHA-256 hash is stored)
+CREATE TABLE IF NOT EXISTS email_changes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    new_email CITEXT NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_changes_expires_at ON email_changes(expires_at);
+
+-- Responses to requests sent with an Idempotency-Key, the Postgres fallback
+-- when Redis is unavailable. Columns match what AutoMigrate creates for
+-- models.IdempotencyKey, times included, so it finds nothing to alter.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(64) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    completed BOOLEAN NOT NULL DEFAULT false,
+    response_status BIGINT NOT NULL DEFAULT 0,
+    response_headers JSONB NOT NULL DEFAULT '{}',
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
 
 -- ============================================================================
 -- Auth Schema (Auth Service)
@@ -2,51 +2,122 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/health"
+	"github.com/devsecops/user-service/internal/idempotency"
+	"github.com/devsecops/user-service/internal/lifecycle"
+	"github.com/devsecops/user-service/internal/ratelimit"
 	"github.com/devsecops/user-service/internal/routes"
 	"github.com/devsecops/user-service/pkg/database"
 	"github.com/devsecops/user-service/pkg/logger"
 	"github.com/devsecops/user-service/pkg/redis"
+	"github.com/devsecops/user-service/pkg/requestid"
+	"github.com/devsecops/user-service/pkg/secrets"
+	"github.com/devsecops/user-service/pkg/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
 )
 
+// componentTimeout bounds how long each background component may take to stop
+const componentTimeout = 5 * time.Second
+
 func main() {
 	// Load environment variables from .env file (if exists)
 	_ = godotenv.Load()
 
+	// Handle the "config" subcommand instead of starting the service
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Parse()
+
 	// Initialize logger
 	log := logger.NewLogger()
 	log.Info("Starting User Service...")
 
-	// Load configuration
-	cfg := config.LoadConfig()
+	// Load configuration and secrets; invalid or insecure settings abort startup
+	cfg, vault, err := secrets.LoadConfig(context.Background(), *configFile)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := logger.SetLevel(log, cfg.LogLevel); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 	log.Infof("Environment: %s", cfg.Environment)
 
+	// Reloadable settings are re-read on config file changes or SIGHUP
+	configStore := config.NewStore(cfg, *configFile, func() (*config.Config, error) {
+		cfg, _, err := secrets.LoadConfig(context.Background(), *configFile)
+		return cfg, err
+	})
+	configStore.OnReload(func(cfg *config.Config) {
+		if err := logger.SetLevel(log, cfg.LogLevel); err != nil {
+			log.Errorf("Failed to apply log level: %v", err)
+		}
+	})
+
 	// Set Gin mode based on environment
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Initialize tracing before any instrumented clients are created
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	log.Infof("Tracing initialized (exporter: %s)", cfg.TracingExporter)
+
+	// Use short-lived credentials from Vault when a database role is configured
+	dbCredentials := database.Credentials{Username: cfg.DBUser, Password: cfg.DBPassword}
+	var vaultDBCredentials *secrets.DatabaseCredentials
+	if cfg.VaultDBRole != "" {
+		vaultDBCredentials, err = secrets.NewDatabaseCredentials(context.Background(), vault, cfg.VaultDBMount, cfg.VaultDBRole, log)
+		if err != nil {
+			log.Fatalf("Failed to get database credentials from Vault: %v", err)
+		}
+		lease := vaultDBCredentials.Current()
+		dbCredentials = database.Credentials{Username: lease.Username, Password: lease.Password}
+
+		log.Infof("Database credentials issued by Vault role %s", cfg.VaultDBRole)
+	}
+
 	// Initialize database connection
-	db, err := database.NewPostgresDB(cfg)
+	dbConnector, err := database.NewConnector(cfg, dbCredentials)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	log.Info("Database connection established")
+	db, err := database.NewPostgresDB(cfg, dbConnector)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("Failed to get database instance: %v", err)
+	}
 
-	// Auto-migrate database models
-	if err := database.AutoMigrate(db); err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
+	// Retire pooled connections well before their Vault credentials expire.
+	// A lifetime of 0 means connections never expire, so it is never applied
+	// and never kept in place of the cap.
+	if vaultDBCredentials != nil {
+		if maxLifetime := vaultDBCredentials.ConnMaxLifetime(); maxLifetime > 0 && (cfg.DBConnMaxLifetime <= 0 || maxLifetime < cfg.DBConnMaxLifetime) {
+			sqlDB.SetConnMaxLifetime(maxLifetime)
+		}
 	}
-	log.Info("Database migration completed")
+	log.Info("Database connection established")
 
 	// Initialize Redis client
 	redisClient, err := redis.NewRedisClient(cfg)
@@ -57,11 +128,99 @@ func main() {
 		log.Info("Redis connection established")
 	}
 
+	// Remember Idempotency-Key responses in Redis, falling back to Postgres
+	idempotencyDB := idempotency.NewPostgresBackend(db, cfg.DBWriteTimeout)
+	var idempotencyBackends []idempotency.Backend
+	if redisClient != nil {
+		idempotencyBackends = append(idempotencyBackends, idempotency.NewRedisBackend(redisClient, cfg.CacheTimeout))
+	}
+	idempotencyBackends = append(idempotencyBackends, idempotencyDB)
+	idempotencyStore := idempotency.NewStore(log, idempotencyBackends...)
+
+	// Initialize rate limiter from the policy file (or the RATE_LIMIT_* defaults)
+	defaultPolicy := ratelimit.DefaultPolicy(int64(cfg.RateLimitRequests), cfg.RateLimitWindow)
+	rateLimiter, err := ratelimit.NewLimiter(cfg.RateLimitPolicyFile, defaultPolicy, log)
+	if err != nil {
+		log.Fatalf("Failed to load rate limit policy: %v", err)
+	}
+	if cfg.RateLimitPolicyFile != "" {
+		log.Infof("Rate limit policy loaded from %s", cfg.RateLimitPolicyFile)
+	}
+	configStore.OnReload(func(cfg *config.Config) {
+		policy := ratelimit.DefaultPolicy(int64(cfg.RateLimitRequests), cfg.RateLimitWindow)
+		if err := rateLimiter.SetDefault(policy); err != nil {
+			log.Errorf("Failed to apply rate limit settings: %v", err)
+		}
+	})
+
+	// Register health checks; readiness stays failing until startup completes
+	healthChecks, err := newHealthRegistry(cfg, db, redisClient)
+	if err != nil {
+		log.Fatalf("Failed to setup health checks: %v", err)
+	}
+
+	// Components are registered in dependency order and stopped in reverse
+	lifecycleManager := lifecycle.NewManager(log, healthChecks, cfg.ShutdownDrainDelay)
+	lifecycleManager.Register("tracing", componentTimeout, func(ctx context.Context) error {
+		return shutdownTracing(ctx)
+	})
+	lifecycleManager.Register("database", componentTimeout, func(ctx context.Context) error {
+		return sqlDB.Close()
+	})
+	if vaultDBCredentials != nil {
+		// Renew the lease and switch the pool to new credentials before it expires
+		lifecycleManager.Go("vault-database-credentials", componentTimeout, func(ctx context.Context) {
+			vaultDBCredentials.Run(ctx, func(lease secrets.Lease) {
+				dbConnector.Rotate(sqlDB, database.Credentials{Username: lease.Username, Password: lease.Password}, cfg.DBMaxIdleConns)
+			})
+		})
+	}
+	if redisClient != nil {
+		lifecycleManager.Register("redis", componentTimeout, func(ctx context.Context) error {
+			return redisClient.Close()
+		})
+	}
+
+	// Watch the config file and SIGHUP, and the rate limit policy, until shutdown
+	lifecycleManager.Go("config-watcher", componentTimeout, func(ctx context.Context) {
+		configStore.Watch(ctx, cfg.ConfigReloadInterval, log)
+	})
+	lifecycleManager.Go("ratelimit-watcher", componentTimeout, func(ctx context.Context) {
+		rateLimiter.Watch(ctx, cfg.RateLimitReloadInterval)
+	})
+
+	lifecycleManager.Go("idempotency-purge", componentTimeout, func(ctx context.Context) {
+		idempotencyDB.RunPurge(ctx, cfg.IdempotencyPurgePeriod, log)
+	})
+
 	// Create Gin router
 	router := gin.New()
 
 	// Setup routes with dependencies
-	routes.SetupRoutes(router, db, redisClient, cfg, log)
+	if err := routes.SetupRoutes(router, db, redisClient, idempotencyStore, rateLimiter, healthChecks, configStore, log); err != nil {
+		log.Fatalf("Failed to setup routes: %v", err)
+	}
+
+	// Serve metrics on a separate admin port so they aren't exposed publicly
+	if cfg.MetricsPort != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", promhttp.Handler())
+
+		adminSrv := &http.Server{
+			Addr:         fmt.Sprintf(":%s", cfg.MetricsPort),
+			Handler:      adminMux,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+		}
+
+		go func() {
+			log.Infof("Metrics listening on port %s", cfg.MetricsPort)
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start metrics server: %v", err)
+			}
+		}()
+		lifecycleManager.Register("metrics-server", componentTimeout, adminSrv.Shutdown)
+	}
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -80,6 +239,15 @@ func main() {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
+	// In-flight requests may take up to the server's write timeout
+	lifecycleManager.Register("http-server", srv.WriteTimeout, srv.Shutdown)
+
+	// Auto-migrate database models while the startup probe reports "starting"
+	if err := database.AutoMigrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	log.Info("Database migration completed")
+	healthChecks.MarkStarted()
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
@@ -88,27 +256,74 @@ func main() {
 
 	log.Info("Shutting down server...")
 
-	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Graceful shutdown bounded by the overall timeout
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
-	// Shutdown HTTP server
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Errorf("Server forced to shutdown: %v", err)
-	}
+	lifecycleManager.Shutdown(ctx)
+
+	log.Info("Server exited")
+}
 
-	// Close database connection
-	sqlDB, _ := db.DB()
-	if sqlDB != nil {
-		sqlDB.Close()
-		log.Info("Database connection closed")
+// newHealthRegistry registers the readiness checks for the service's dependencies
+func newHealthRegistry(cfg *config.Config, db *gorm.DB, redisClient *redis.RedisClient) (*health.Registry, error) {
+	registry := health.NewRegistry(
+		cfg.HealthCheckTimeout,
+		cfg.HealthCacheTTL,
+	)
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database instance: %w", err)
 	}
 
-	// Close Redis connection
+	registry.Register("database", health.DatabaseCheck(sqlDB), health.Critical())
+
+	// Redis is optional: the service keeps working without its cache
 	if redisClient != nil {
-		redisClient.Close()
-		log.Info("Redis connection closed")
+		registry.Register("redis", health.PingCheck(redisClient))
+	} else {
+		registry.Register("redis", health.PingCheck(nil))
 	}
 
-	log.Info("Server exited")
+	// Downstream dependencies are configured as name=url pairs
+	httpClient := &http.Client{Transport: tracing.NewTransport(requestid.NewTransport(nil))}
+	for _, dependency := range cfg.HealthDependencies {
+		name, url, ok := strings.Cut(dependency, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("invalid health dependency %q, expected name=url", dependency)
+		}
+		registry.Register(name, health.HTTPCheck(httpClient, url))
+	}
+
+	return registry, nil
+}
+
+// runConfigCommand implements "config print [--redacted] [--config file]"
+func runConfigCommand(args []string) int {
+	const usage = "usage: user-service config print [--redacted] [--config file]"
+
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	redacted := flags.Bool("redacted", false, "replace secrets with "+`"REDACTED"`)
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, _, err := secrets.LoadConfig(context.Background(), *configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	if err := config.Print(os.Stdout, cfg, *redacted); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
 }
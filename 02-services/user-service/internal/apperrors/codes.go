@@ -0,0 +1,78 @@
+package apperrors
+
+import "net/http"
+
+// Error codes returned in the code member of error responses. Clients may
+// rely on them; messages are for people and are localized.
+const (
+	CodeValidation         = "VALIDATION_ERROR"
+	CodeInvalidID          = "INVALID_ID"
+	CodeInvalidPreferences = "INVALID_PREFERENCES"
+	CodeInvalidIdempotency = "INVALID_IDEMPOTENCY_KEY"
+	CodeInvalidEmailToken  = "INVALID_EMAIL_CHANGE_TOKEN"
+	CodeUnauthorized       = "UNAUTHORIZED"
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	CodeForbidden          = "FORBIDDEN"
+	CodeAccountDisabled    = "ACCOUNT_DISABLED"
+	CodeNotFound           = "NOT_FOUND"
+	CodeUserNotFound       = "USER_NOT_FOUND"
+	CodeProfileNotFound    = "PROFILE_NOT_FOUND"
+	CodePreferenceNotFound = "PREFERENCE_NOT_FOUND"
+	CodeConflict           = "CONFLICT"
+	CodeEmailExists        = "EMAIL_EXISTS"
+	CodeUsernameExists     = "USERNAME_EXISTS"
+	CodeIdempotencyPending = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	CodePrecondition       = "PRECONDITION_FAILED"
+	CodeVersionMismatch    = "VERSION_MISMATCH"
+	CodeIfMatchRequired    = "PRECONDITION_REQUIRED"
+	CodeRequestTooLarge    = "REQUEST_TOO_LARGE"
+	CodeUnsupportedMedia   = "UNSUPPORTED_MEDIA_TYPE"
+	CodeIdempotencyReused  = "IDEMPOTENCY_KEY_MISMATCH"
+	CodeAccountLocked      = "ACCOUNT_LOCKED"
+	CodeRateLimited        = "RATE_LIMIT_EXCEEDED"
+	CodeRequestCanceled    = "REQUEST_CANCELED"
+	CodeInternal           = "INTERNAL_ERROR"
+	CodeUnavailable        = "SERVICE_UNAVAILABLE"
+	CodeRequestTimeout     = "REQUEST_TIMEOUT"
+)
+
+// CodeInfo describes an error code for the catalog
+type CodeInfo struct {
+	Code        string
+	Status      int
+	Description string
+}
+
+// Catalog lists every error code with the status it is returned with, in
+// the order of the README's table
+var Catalog = []CodeInfo{
+	{CodeValidation, http.StatusBadRequest, "The request body or query is invalid; details lists each field"},
+	{CodeInvalidID, http.StatusBadRequest, "The user ID in the path is not a UUID"},
+	{CodeInvalidPreferences, http.StatusBadRequest, "Preferences or a preference filter do not match the schema"},
+	{CodeInvalidIdempotency, http.StatusBadRequest, "The Idempotency-Key header is malformed"},
+	{CodeInvalidEmailToken, http.StatusBadRequest, "The email change token is unknown, already used or expired"},
+	{CodeUnauthorized, http.StatusUnauthorized, "The bearer token is missing, malformed, expired or revoked"},
+	{CodeInvalidCredentials, http.StatusUnauthorized, "The login or password is wrong"},
+	{CodeForbidden, http.StatusForbidden, "The caller may not act on this user"},
+	{CodeAccountDisabled, http.StatusForbidden, "The password is right but the account is disabled"},
+	{CodeNotFound, http.StatusNotFound, "The resource does not exist"},
+	{CodeUserNotFound, http.StatusNotFound, "The user does not exist or was deleted"},
+	{CodeProfileNotFound, http.StatusNotFound, "The user has no profile"},
+	{CodePreferenceNotFound, http.StatusNotFound, "The preference key is not in the schema"},
+	{CodeConflict, http.StatusConflict, "The change conflicts with existing data"},
+	{CodeEmailExists, http.StatusConflict, "The email is already registered"},
+	{CodeUsernameExists, http.StatusConflict, "The username is already taken"},
+	{CodeIdempotencyPending, http.StatusConflict, "A request with the same Idempotency-Key is still running"},
+	{CodePrecondition, http.StatusPreconditionFailed, "A request precondition does not hold"},
+	{CodeVersionMismatch, http.StatusPreconditionFailed, "If-Match does not name the current version"},
+	{CodeIfMatchRequired, http.StatusPreconditionRequired, "The write needs an If-Match header"},
+	{CodeRequestTooLarge, http.StatusRequestEntityTooLarge, "The request body exceeds the size limit"},
+	{CodeUnsupportedMedia, http.StatusUnsupportedMediaType, "The Content-Type is not accepted"},
+	{CodeIdempotencyReused, http.StatusUnprocessableEntity, "The Idempotency-Key was used for a different request"},
+	{CodeAccountLocked, http.StatusLocked, "Too many failed password checks; retry after Retry-After"},
+	{CodeRateLimited, http.StatusTooManyRequests, "Too many requests; retry later"},
+	{CodeRequestCanceled, 499, "The client closed the connection"},
+	{CodeInternal, http.StatusInternalServerError, "An unexpected error, logged with the request ID"},
+	{CodeUnavailable, http.StatusServiceUnavailable, "A dependency is unavailable; retry after Retry-After"},
+	{CodeRequestTimeout, http.StatusServiceUnavailable, "The request timed out; retry after Retry-After"},
+}
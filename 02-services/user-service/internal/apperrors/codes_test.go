@@ -0,0 +1,123 @@
+package apperrors
+
+import (
+	"bufio"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestCatalogListsEveryCode checks that every Code constant declared in
+// codes.go is in Catalog, once
+func TestCatalogListsEveryCode(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "codes.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse codes.go: %v", err)
+	}
+
+	declared := map[string]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, name := range spec.Names {
+			if !strings.HasPrefix(name.Name, "Code") || i >= len(spec.Values) {
+				continue
+			}
+			if lit, ok := spec.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				value, _ := strconv.Unquote(lit.Value)
+				declared[value] = true
+			}
+		}
+		return true
+	})
+
+	listed := map[string]bool{}
+	for _, info := range Catalog {
+		if listed[info.Code] {
+			t.Errorf("%s is listed twice", info.Code)
+		}
+		listed[info.Code] = true
+		if !declared[info.Code] {
+			t.Errorf("%s is listed but not declared", info.Code)
+		}
+	}
+	for code := range declared {
+		if !listed[code] {
+			t.Errorf("%s is declared but not listed in Catalog", code)
+		}
+	}
+}
+
+// TestCatalogMatchesREADME checks that the README's table of error codes
+// lists the codes of Catalog, in order, with the same statuses and meanings
+func TestCatalogMatchesREADME(t *testing.T) {
+	rows := readCodeTable(t, "../../README.md")
+
+	for i := 0; i < len(rows) || i < len(Catalog); i++ {
+		if i >= len(rows) {
+			t.Errorf("README lacks %s", Catalog[i].Code)
+			continue
+		}
+		if i >= len(Catalog) {
+			t.Errorf("README lists %s, which is not in Catalog", rows[i].Code)
+			continue
+		}
+		if rows[i] != Catalog[i] {
+			t.Errorf("README row %d is %+v, want %+v", i+1, rows[i], Catalog[i])
+		}
+	}
+}
+
+// readCodeTable parses the table under "### Error codes", dropping the
+// Markdown code spans
+func readCodeTable(t *testing.T, path string) []CodeInfo {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open README: %v", err)
+	}
+	defer f.Close()
+
+	var rows []CodeInfo
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			if inSection {
+				break
+			}
+			inSection = line == "### Error codes"
+			continue
+		}
+		if !inSection || !strings.HasPrefix(line, "| `") {
+			continue
+		}
+
+		cells := strings.Split(strings.Trim(line, "|"), "|")
+		if len(cells) != 3 {
+			t.Fatalf("malformed row %q", line)
+		}
+		for i := range cells {
+			cells[i] = strings.ReplaceAll(strings.TrimSpace(cells[i]), "`", "")
+		}
+		status, err := strconv.Atoi(cells[1])
+		if err != nil {
+			t.Fatalf("malformed status in row %q", line)
+		}
+		rows = append(rows, CodeInfo{Code: cells[0], Status: status, Description: cells[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read README: %v", err)
+	}
+	if len(rows) == 0 {
+		t.Fatal("no error code table in README")
+	}
+	return rows
+}
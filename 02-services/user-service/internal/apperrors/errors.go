@@ -0,0 +1,132 @@
+// Package apperrors defines the kinds of failure the service reports to
+// clients. Errors carry an API error code and message and match their kind
+// with errors.Is, so handlers never have to inspect error strings.
+package apperrors
+
+import (
+	"errors"
+	"strings"
+)
+
+// Kinds of error, matched with errors.Is
+var (
+	ErrNotFound             = errors.New("not found")
+	ErrConflict             = errors.New("conflict")
+	ErrValidation           = errors.New("validation failed")
+	ErrUnavailable          = errors.New("service unavailable")
+	ErrPrecondition         = errors.New("precondition failed")
+	ErrPreconditionRequired = errors.New("precondition required")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrLocked               = errors.New("locked")
+)
+
+// Error is a failure of a given kind with the code and message returned to
+// the client
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+// FieldError is a problem with one field of a request: its JSON path (empty
+// for the body as a whole), the rule it breaks and the rule's parameter, if
+// any. Messages are looked up by rule when the error is rendered, in the
+// language the client asked for.
+type FieldError struct {
+	Field string
+	Rule  string
+	Param string
+}
+
+// Rules of field errors besides those of the validator tags and the JSON
+// Schema keywords they are named after
+const (
+	RuleSyntax       = "syntax"
+	RuleType         = "type"
+	RuleUnknownField = "unknown_field"
+	RuleNotNull      = "not_null"
+)
+
+// NotFound creates an ErrNotFound error, e.g. NotFound("USER_NOT_FOUND", "User not found")
+func NotFound(code, message string) *Error {
+	return &Error{Kind: ErrNotFound, Code: code, Message: message}
+}
+
+// Conflict creates an ErrConflict error
+func Conflict(code, message string) *Error {
+	return &Error{Kind: ErrConflict, Code: code, Message: message}
+}
+
+// Validation creates an ErrValidation error listing the fields found invalid
+func Validation(code, message string, fields ...FieldError) *Error {
+	return &Error{Kind: ErrValidation, Code: code, Message: message, Fields: fields}
+}
+
+// PreconditionFailed creates an ErrPrecondition error, for a conditional
+// request whose condition no longer holds
+func PreconditionFailed(code, message string) *Error {
+	return &Error{Kind: ErrPrecondition, Code: code, Message: message}
+}
+
+// PreconditionRequired creates an ErrPreconditionRequired error, for a write that
+// must be conditional but is not
+func PreconditionRequired(code, message string) *Error {
+	return &Error{Kind: ErrPreconditionRequired, Code: code, Message: message}
+}
+
+// Unauthorized creates an ErrUnauthorized error, for missing or wrong
+// credentials
+func Unauthorized(code, message string) *Error {
+	return &Error{Kind: ErrUnauthorized, Code: code, Message: message}
+}
+
+// Forbidden creates an ErrForbidden error, for a caller who may not act on
+// the resource
+func Forbidden(code, message string) *Error {
+	return &Error{Kind: ErrForbidden, Code: code, Message: message}
+}
+
+// Locked creates an ErrLocked error, for a resource that is temporarily
+// locked
+func Locked(code, message string) *Error {
+	return &Error{Kind: ErrLocked, Code: code, Message: message}
+}
+
+// Unavailable creates an ErrUnavailable error caused by err, for failures
+// of a dependency that are worth retrying
+func Unavailable(err error) *Error {
+	return &Error{Kind: ErrUnavailable, Code: CodeUnavailable, Message: "Service temporarily unavailable", Err: err}
+}
+
+// Wrap records err as the cause of e
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.Error())
+	if e.Code != "" {
+		b.WriteString(" (" + e.Code + ")")
+	}
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+	if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap lets errors.Is and errors.As match the kind and the cause
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
@@ -1,100 +1,401 @@
 package config
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// Config holds all configuration for the application
+// Values that must never reach production
+const (
+	defaultJWTSecret  = "your-secret-key-change-in-production"
+	defaultDBPassword = "postgres123"
+
+	// minJWTSecretLength is the minimum HMAC secret length accepted in production
+	minJWTSecretLength = 32
+)
+
+// Config holds all configuration for the application.
+//
+// Each field is described by its struct tags: key is its dotted path in a
+// config file, env the environment variable overriding it, default its value
+// when neither is set, secret marks values redacted by Print and reload marks
+// settings a running service can pick up without a restart.
 type Config struct {
 	// Service configuration
-	Port        string
-	ServiceName string
-	Environment string
-	LogLevel    string
+	Port        string `key:"service.port" env:"PORT" default:"8081"`
+	ServiceName string `key:"service.name" env:"SERVICE_NAME" default:"user-service"`
+	Environment string `key:"service.environment" env:"ENVIRONMENT" default:"development"`
+	LogLevel    string `key:"service.log_level" env:"LOG_LEVEL" default:"info" reload:"true"`
+
+	// How often the config file is checked for changes (0 disables polling)
+	ConfigReloadInterval time.Duration `key:"service.config_reload_interval" env:"CONFIG_RELOAD_INTERVAL" default:"30s"`
+
+	// Serve /metrics on a separate admin port when set
+	MetricsPort string `key:"service.metrics_port" env:"METRICS_PORT"`
+
+	// Graceful shutdown
+	ShutdownDrainDelay time.Duration `key:"shutdown.drain_delay" env:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+	ShutdownTimeout    time.Duration `key:"shutdown.timeout" env:"SHUTDOWN_TIMEOUT" default:"30s"`
+
+	// Health checks
+	HealthCheckTimeout time.Duration `key:"health.check_timeout" env:"HEALTH_CHECK_TIMEOUT" default:"2s"`
+	HealthCacheTTL     time.Duration `key:"health.cache_ttl" env:"HEALTH_CACHE_TTL" default:"2s"`
+	HealthDependencies []string      `key:"health.dependencies" env:"HEALTH_DEPENDENCIES"`
+
+	// Client IP resolution
+	TrustedProxies  []string `key:"client_ip.trusted_proxies" env:"TRUSTED_PROXIES"`
+	ClientIPHeaders []string `key:"client_ip.headers" env:"CLIENT_IP_HEADERS" default:"Forwarded,X-Forwarded-For,X-Real-IP"`
+
+	// Request hardening (HSTS_MAX_AGE=0 disables HSTS; REQUIRE_IF_MATCH answers
+	// writes of versioned resources without If-Match with 428)
+	RequestTimeout        time.Duration `key:"http.request_timeout" env:"REQUEST_TIMEOUT" default:"10s"`
+	MaxBodyBytes          int64         `key:"http.max_body_bytes" env:"MAX_BODY_BYTES" default:"1048576"`
+	UserBodyBytes         int64         `key:"http.user_body_bytes" env:"USER_BODY_BYTES" default:"16384"`
+	HSTSMaxAge            time.Duration `key:"http.hsts_max_age" env:"HSTS_MAX_AGE" default:"8760h"`
+	ReferrerPolicy        string        `key:"http.referrer_policy" env:"REFERRER_POLICY" default:"no-referrer"`
+	ContentSecurityPolicy string        `key:"http.content_security_policy" env:"CONTENT_SECURITY_POLICY" default:"default-src 'none'; frame-ancestors 'none'"`
+	RequireIfMatch        bool          `key:"http.require_if_match" env:"REQUIRE_IF_MATCH" default:"false"`
 
 	// Database configuration
-	DBHost           string
-	DBPort           string
-	DBName           string
-	DBUser           string
-	DBPassword       string
-	DBSSLMode        string
-	DBMaxConnections int
-	DBMaxIdleConns   int
+	DBHost            string        `key:"database.host" env:"DB_HOST" default:"localhost"`
+	DBPort            string        `key:"database.port" env:"DB_PORT" default:"5432"`
+	DBName            string        `key:"database.name" env:"DB_NAME" default:"devsecops"`
+	DBUser            string        `key:"database.user" env:"DB_USER" default:"postgres"`
+	DBPassword        string        `key:"database.password" env:"DB_PASSWORD" default:"postgres123" secret:"true"`
+	DBSSLMode         string        `key:"database.ssl_mode" env:"DB_SSL_MODE" default:"disable"`
+	DBMaxConnections  int           `key:"database.max_connections" env:"DB_MAX_CONNECTIONS" default:"25"`
+	DBMaxIdleConns    int           `key:"database.max_idle_connections" env:"DB_MAX_IDLE_CONNECTIONS" default:"5"`
+	DBConnMaxLifetime time.Duration `key:"database.conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" default:"1h"`
+	DBQueryTimeout    time.Duration `key:"database.query_timeout" env:"DB_QUERY_TIMEOUT" default:"3s"`
+	DBWriteTimeout    time.Duration `key:"database.write_timeout" env:"DB_WRITE_TIMEOUT" default:"5s"`
 
 	// Redis configuration
-	RedisHost     string
-	RedisPort     string
-	RedisPassword string
-	RedisDB       int
-	CacheTTL      int
+	RedisHost     string        `key:"redis.host" env:"REDIS_HOST" default:"localhost"`
+	RedisPort     string        `key:"redis.port" env:"REDIS_PORT" default:"6379"`
+	RedisPassword string        `key:"redis.password" env:"REDIS_PASSWORD" secret:"true"`
+	RedisDB       int           `key:"redis.db" env:"REDIS_DB" default:"0"`
+	CacheTTL      time.Duration `key:"redis.cache_ttl" env:"CACHE_TTL" default:"5m" reload:"true"`
+	CacheTimeout  time.Duration `key:"redis.timeout" env:"CACHE_TIMEOUT" default:"250ms"`
+
+	// Idempotency-Key handling (pending keys expire after twice the request timeout)
+	IdempotencyTTL         time.Duration `key:"idempotency.ttl" env:"IDEMPOTENCY_TTL" default:"24h"`
+	IdempotencyWait        time.Duration `key:"idempotency.wait_timeout" env:"IDEMPOTENCY_WAIT_TIMEOUT" default:"5s"`
+	IdempotencyPurgePeriod time.Duration `key:"idempotency.purge_interval" env:"IDEMPOTENCY_PURGE_INTERVAL" default:"10m"`
+
+	// JSON Schema user preferences are validated against (empty for the built-in schema)
+	PreferencesSchemaFile string `key:"preferences.schema_file" env:"PREFERENCES_SCHEMA_FILE"`
+
+	// Field validation (avatar hosts may use a leading wildcard label, e.g. *.gravatar.com;
+	// an empty list allows any HTTPS host)
+	AvatarAllowedHosts []string `key:"validation.avatar_allowed_hosts" env:"AVATAR_ALLOWED_HOSTS" default:"gravatar.com,*.gravatar.com"`
+	MinUserAge         int      `key:"validation.min_user_age" env:"MIN_USER_AGE" default:"13"`
+
+	// Password policy (classes are lower, upper, digit and symbol; the breached
+	// file is a Pwned Passwords SHA-1 list, sorted by hash) and hashing
+	PasswordMinLength         int      `key:"password.min_length" env:"PASSWORD_MIN_LENGTH" default:"10"`
+	PasswordMaxLength         int      `key:"password.max_length" env:"PASSWORD_MAX_LENGTH" default:"128"`
+	PasswordRequiredClasses   []string `key:"password.required_classes" env:"PASSWORD_REQUIRED_CLASSES" default:"lower,upper,digit"`
+	PasswordForbidPersonal    bool     `key:"password.forbid_personal" env:"PASSWORD_FORBID_PERSONAL" default:"true"`
+	PasswordBreachedFile      string   `key:"password.breached_file" env:"PASSWORD_BREACHED_FILE"`
+	PasswordBreachedThreshold int      `key:"password.breached_threshold" env:"PASSWORD_BREACHED_THRESHOLD" default:"1"`
+	PasswordHashAlgorithm     string   `key:"password.hash_algorithm" env:"PASSWORD_HASH_ALGORITHM" default:"bcrypt"`
+	PasswordBcryptCost        int      `key:"password.bcrypt_cost" env:"PASSWORD_BCRYPT_COST" default:"12"`
+	PasswordArgon2Memory      int      `key:"password.argon2_memory_kib" env:"PASSWORD_ARGON2_MEMORY_KIB" default:"19456"`
+	PasswordArgon2Iterations  int      `key:"password.argon2_iterations" env:"PASSWORD_ARGON2_ITERATIONS" default:"2"`
+	PasswordArgon2Parallelism int      `key:"password.argon2_parallelism" env:"PASSWORD_ARGON2_PARALLELISM" default:"1"`
+
+	// Account lockout after repeated wrong passwords (a threshold of 0 disables
+	// it) and the number of recent passwords, the current one included, that
+	// cannot be reused (0 allows reuse)
+	PasswordLockoutThreshold int           `key:"password.lockout_threshold" env:"PASSWORD_LOCKOUT_THRESHOLD" default:"5"`
+	PasswordLockoutDuration  time.Duration `key:"password.lockout_duration" env:"PASSWORD_LOCKOUT_DURATION" default:"15m"`
+	PasswordHistorySize      int           `key:"password.history_size" env:"PASSWORD_HISTORY_SIZE" default:"5"`
+
+	// Email changes: how long a confirmation token is valid and the link sent
+	// to the new address, in which {token} is replaced by the token
+	EmailChangeTokenTTL   time.Duration `key:"email_change.token_ttl" env:"EMAIL_CHANGE_TOKEN_TTL" default:"24h"`
+	EmailChangeConfirmURL string        `key:"email_change.confirm_url" env:"EMAIL_CHANGE_CONFIRM_URL" default:"http://localhost:3000/confirm-email?token={token}"`
+
+	// notification-service base URL (empty logs notifications instead of
+	// sending them)
+	NotificationURL     string        `key:"notification.url" env:"NOTIFICATION_URL"`
+	NotificationTimeout time.Duration `key:"notification.timeout" env:"NOTIFICATION_TIMEOUT" default:"5s"`
+
+	// CORS (origins may use a leading wildcard label, e.g. https://*.example.com)
+	CORSAllowedOrigins   []string      `key:"cors.allowed_origins" env:"CORS_ALLOWED_ORIGINS" default:"*" reload:"true"`
+	CORSAllowMethods     []string      `key:"cors.allow_methods" env:"CORS_ALLOW_METHODS" default:"GET,POST,PUT,PATCH,DELETE,OPTIONS" reload:"true"`
+	CORSAllowHeaders     []string      `key:"cors.allow_headers" env:"CORS_ALLOW_HEADERS" default:"Origin,Content-Type,Accept,Authorization,X-Request-ID,Idempotency-Key,If-Match,If-None-Match" reload:"true"`
+	CORSExposeHeaders    []string      `key:"cors.expose_headers" env:"CORS_EXPOSE_HEADERS" default:"Content-Length,Idempotent-Replayed,ETag" reload:"true"`
+	CORSAllowCredentials bool          `key:"cors.allow_credentials" env:"CORS_ALLOW_CREDENTIALS" default:"false" reload:"true"`
+	CORSMaxAge           time.Duration `key:"cors.max_age" env:"CORS_MAX_AGE" default:"12h" reload:"true"`
 
 	// JWT configuration
-	JWTSecret     string
-	JWTExpiration int
+	JWTSecret     string        `key:"jwt.secret" env:"JWT_SECRET" default:"your-secret-key-change-in-production" secret:"true"`
+	JWTExpiration time.Duration `key:"jwt.expiration" env:"JWT_EXPIRATION" default:"1h"`
+	JWTAdminRole  string        `key:"jwt.admin_role" env:"JWT_ADMIN_ROLE" default:"admin"`
+
+	// Bearer token other services such as auth-service present on /internal
+	// routes (empty disables them)
+	InternalAPIToken string `key:"internal.api_token" env:"INTERNAL_API_TOKEN" secret:"true"`
+
+	// Vault secrets (KV v2 entries as ENV_NAME=path#field pairs)
+	VaultAddr            string   `key:"vault.address" env:"VAULT_ADDR"`
+	VaultAuthMethod      string   `key:"vault.auth_method" env:"VAULT_AUTH_METHOD" default:"token"`
+	VaultToken           string   `key:"vault.token" env:"VAULT_TOKEN" secret:"true"`
+	VaultRole            string   `key:"vault.role" env:"VAULT_ROLE" default:"user-service"`
+	VaultKubernetesMount string   `key:"vault.kubernetes_mount" env:"VAULT_KUBERNETES_MOUNT" default:"kubernetes"`
+	VaultKVMount         string   `key:"vault.kv_mount" env:"VAULT_KV_MOUNT" default:"secret"`
+	VaultKVSecrets       []string `key:"vault.kv_secrets" env:"VAULT_KV_SECRETS"`
+	VaultDBMount         string   `key:"vault.database_mount" env:"VAULT_DATABASE_MOUNT" default:"database"`
+	VaultDBRole          string   `key:"vault.database_role" env:"VAULT_DATABASE_ROLE"`
+
+	// Tracing configuration
+	TracingExporter    string  `key:"tracing.exporter" env:"TRACING_EXPORTER" default:"none"`
+	TracingSampleRatio float64 `key:"tracing.sample_ratio" env:"TRACING_SAMPLE_RATIO" default:"1.0"`
+	TracingFile        string  `key:"tracing.file" env:"TRACING_FILE" default:"traces.json"`
+	OTLPEndpoint       string  `key:"tracing.otlp_endpoint" env:"OTLP_ENDPOINT" default:"localhost:4318"`
+	OTLPInsecure       bool    `key:"tracing.otlp_insecure" env:"OTLP_INSECURE" default:"false"`
 
 	// Rate limiting
-	RateLimitRequests int
-	RateLimitWindow   int
+	RateLimitRequests       int           `key:"rate_limit.requests" env:"RATE_LIMIT_REQUESTS" default:"100" reload:"true"`
+	RateLimitWindow         time.Duration `key:"rate_limit.window" env:"RATE_LIMIT_WINDOW" default:"1m" reload:"true"`
+	RateLimitPolicyFile     string        `key:"rate_limit.policy_file" env:"RATE_LIMIT_POLICY_FILE"`
+	RateLimitReloadInterval time.Duration `key:"rate_limit.reload_interval" env:"RATE_LIMIT_RELOAD_INTERVAL" default:"30s"`
 }
 
-// LoadConfig loads configuration from environment variables
-func LoadConfig() *Config {
-	return &Config{
-		// Service configuration
-		Port:        getEnv("PORT", "8081"),
-		ServiceName: getEnv("SERVICE_NAME", "user-service"),
-		Environment: getEnv("ENVIRONMENT", "development"),
-		LogLevel:    getEnv("LOG_LEVEL", "info"),
-
-		// Database configuration
-		DBHost:           getEnv("DB_HOST", "localhost"),
-		DBPort:           getEnv("DB_PORT", "5432"),
-		DBName:           getEnv("DB_NAME", "devsecops"),
-		DBUser:           getEnv("DB_USER", "postgres"),
-		DBPassword:       getEnv("DB_PASSWORD", "postgres123"),
-		DBSSLMode:        getEnv("DB_SSL_MODE", "disable"),
-		DBMaxConnections: getEnvInt("DB_MAX_CONNECTIONS", 25),
-		DBMaxIdleConns:   getEnvInt("DB_MAX_IDLE_CONNECTIONS", 5),
-
-		// Redis configuration
-		RedisHost:     getEnv("REDIS_HOST", "localhost"),
-		RedisPort:     getEnv("REDIS_PORT", "6379"),
-		RedisPassword: getEnv("REDIS_PASSWORD", ""),
-		RedisDB:       getEnvInt("REDIS_DB", 0),
-		CacheTTL:      getEnvInt("CACHE_TTL", 300),
-
-		// JWT configuration
-		JWTSecret:     getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-		JWTExpiration: getEnvInt("JWT_EXPIRATION", 3600),
-
-		// Rate limiting
-		RateLimitRequests: getEnvInt("RATE_LIMIT_REQUESTS", 100),
-		RateLimitWindow:   getEnvInt("RATE_LIMIT_WINDOW", 60),
+// Load reads the configuration with Read and validates it
+func Load(path string) (*Config, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return nil, err
 	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
 }
 
-// getEnv retrieves an environment variable with a fallback default value
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// Read builds the configuration from defaults, the optional YAML or TOML
+// file at path and environment variables, in increasing order of precedence.
+// The result must be validated once secrets have been resolved.
+func Read(path string) (*Config, error) {
+	cfg := &Config{}
+
+	if err := applyDefaults(cfg); err != nil {
+		return nil, err
 	}
-	return value
+
+	if path != "" {
+		if err := applyFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
 }
 
-// getEnvInt retrieves an integer environment variable with a fallback default value
-func getEnvInt(key string, defaultValue int) int {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// Validate checks every setting and reports all problems at once, each
+// naming the offending key
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(field string, ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", describe(field), fmt.Sprintf(format, args...)))
+		}
 	}
 
-	intValue, err := strconv.Atoi(value)
-	if err != nil {
-		return defaultValue
+	// Service configuration
+	check("Port", validPort(c.Port), "invalid port %q", c.Port)
+	check("ServiceName", c.ServiceName != "", "must not be empty")
+	check("Environment", oneOf(c.Environment, "development", "test", "staging", "production"),
+		"unknown environment %q, expected development, test, staging or production", c.Environment)
+	check("LogLevel", oneOf(c.LogLevel, "debug", "info", "warn", "error"),
+		"unknown log level %q, expected debug, info, warn or error", c.LogLevel)
+	check("MetricsPort", c.MetricsPort == "" || validPort(c.MetricsPort), "invalid port %q", c.MetricsPort)
+	check("ConfigReloadInterval", c.ConfigReloadInterval >= 0, "must not be negative")
+	check("MetricsPort", c.MetricsPort != c.Port, "must differ from %s", describe("Port"))
+
+	// Graceful shutdown
+	check("ShutdownDrainDelay", c.ShutdownDrainDelay >= 0, "must not be negative")
+	check("ShutdownTimeout", c.ShutdownTimeout > c.ShutdownDrainDelay,
+		"must be longer than %s (%s)", describe("ShutdownDrainDelay"), c.ShutdownDrainDelay)
+
+	// Health checks
+	check("HealthCheckTimeout", c.HealthCheckTimeout > 0, "must be positive")
+	check("HealthCacheTTL", c.HealthCacheTTL >= 0, "must not be negative")
+
+	// Request hardening
+	check("RequestTimeout", c.RequestTimeout > 0, "must be positive")
+	check("MaxBodyBytes", c.MaxBodyBytes > 0, "must be positive")
+	check("UserBodyBytes", c.UserBodyBytes > 0 && c.UserBodyBytes <= c.MaxBodyBytes,
+		"must be between 1 and %s (%d)", describe("MaxBodyBytes"), c.MaxBodyBytes)
+	check("HSTSMaxAge", c.HSTSMaxAge >= 0, "must not be negative")
+
+	// Database configuration
+	check("DBHost", c.DBHost != "", "must not be empty")
+	check("DBPort", validPort(c.DBPort), "invalid port %q", c.DBPort)
+	check("DBSSLMode", oneOf(c.DBSSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+		"unknown ssl mode %q", c.DBSSLMode)
+	check("DBMaxConnections", c.DBMaxConnections > 0, "must be positive")
+	check("DBConnMaxLifetime", c.DBConnMaxLifetime > 0, "must be positive")
+	check("DBQueryTimeout", c.DBQueryTimeout > 0 && c.DBQueryTimeout <= c.RequestTimeout,
+		"must be positive and at most %s (%s)", describe("RequestTimeout"), c.RequestTimeout)
+	check("DBWriteTimeout", c.DBWriteTimeout > 0 && c.DBWriteTimeout <= c.RequestTimeout,
+		"must be positive and at most %s (%s)", describe("RequestTimeout"), c.RequestTimeout)
+	check("DBMaxIdleConns", c.DBMaxIdleConns >= 0 && c.DBMaxIdleConns <= c.DBMaxConnections,
+		"must be between 0 and %s (%d)", describe("DBMaxConnections"), c.DBMaxConnections)
+
+	// Redis configuration
+	check("RedisPort", validPort(c.RedisPort), "invalid port %q", c.RedisPort)
+	check("RedisDB", c.RedisDB >= 0, "must not be negative")
+	check("CacheTTL", c.CacheTTL > 0, "must be positive")
+	check("CacheTimeout", c.CacheTimeout > 0, "must be positive")
+
+	// Idempotency-Key handling
+	check("IdempotencyTTL", c.IdempotencyTTL > 0, "must be positive")
+	check("IdempotencyWait", c.IdempotencyWait >= 0 && c.IdempotencyWait < c.RequestTimeout,
+		"must not be negative and less than %s (%s)", describe("RequestTimeout"), c.RequestTimeout)
+	check("IdempotencyPurgePeriod", c.IdempotencyPurgePeriod > 0, "must be positive")
+
+	// Field validation
+	for _, host := range c.AvatarAllowedHosts {
+		name := strings.TrimPrefix(host, "*.")
+		check("AvatarAllowedHosts", name != "" && !strings.ContainsAny(name, "/:*@ "),
+			"invalid host %q, expected a host name or *.domain", host)
+	}
+	check("MinUserAge", c.MinUserAge >= 0 && c.MinUserAge <= 150, "must be between 0 and 150")
+
+	// Password policy and hashing
+	check("PasswordMinLength", c.PasswordMinLength >= 1, "must be positive")
+	check("PasswordMaxLength", c.PasswordMaxLength >= c.PasswordMinLength,
+		"must not be less than %s (%d)", describe("PasswordMinLength"), c.PasswordMinLength)
+	for _, class := range c.PasswordRequiredClasses {
+		check("PasswordRequiredClasses", oneOf(class, "lower", "upper", "digit", "symbol"),
+			"unknown class %q, expected lower, upper, digit or symbol", class)
 	}
+	check("PasswordBreachedThreshold", c.PasswordBreachedThreshold >= 1, "must be positive")
+	check("PasswordHashAlgorithm", oneOf(c.PasswordHashAlgorithm, "bcrypt", "argon2id"),
+		"unknown algorithm %q, expected bcrypt or argon2id", c.PasswordHashAlgorithm)
+	check("PasswordBcryptCost", c.PasswordBcryptCost >= 10 && c.PasswordBcryptCost <= 31, "must be between 10 and 31")
+	check("PasswordArgon2Memory", c.PasswordArgon2Memory >= 8*c.PasswordArgon2Parallelism && c.PasswordArgon2Memory <= 4<<20,
+		"must be between 8 KiB per thread and 4 GiB")
+	check("PasswordArgon2Iterations", c.PasswordArgon2Iterations >= 1, "must be positive")
+	check("PasswordArgon2Parallelism", c.PasswordArgon2Parallelism >= 1 && c.PasswordArgon2Parallelism <= 255,
+		"must be between 1 and 255")
+	check("PasswordLockoutThreshold", c.PasswordLockoutThreshold >= 0, "must not be negative")
+	check("PasswordLockoutDuration", c.PasswordLockoutDuration > 0 || c.PasswordLockoutThreshold == 0,
+		"must be positive when %s is set", describe("PasswordLockoutThreshold"))
+	check("PasswordHistorySize", c.PasswordHistorySize >= 0 && c.PasswordHistorySize <= 24, "must be between 0 and 24")
 
-	return intValue
+	// Email changes and notifications
+	check("EmailChangeTokenTTL", c.EmailChangeTokenTTL > 0, "must be positive")
+	check("EmailChangeConfirmURL", validHTTPURL(c.EmailChangeConfirmURL) && strings.Contains(c.EmailChangeConfirmURL, "{token}"),
+		"invalid URL %q, expected an http or https URL containing {token}", c.EmailChangeConfirmURL)
+	check("NotificationURL", c.NotificationURL == "" || validHTTPURL(c.NotificationURL),
+		"invalid URL %q, expected an http or https URL", c.NotificationURL)
+	check("NotificationTimeout", c.NotificationTimeout > 0 && c.NotificationTimeout < c.RequestTimeout,
+		"must be positive and less than %s (%s)", describe("RequestTimeout"), c.RequestTimeout)
+
+	// CORS
+	check("CORSAllowedOrigins", len(c.CORSAllowedOrigins) > 0, "must list at least one origin or \"*\"")
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin == "*" {
+			check("CORSAllowedOrigins", len(c.CORSAllowedOrigins) == 1, "\"*\" cannot be combined with other origins")
+			check("CORSAllowedOrigins", !c.CORSAllowCredentials, "\"*\" cannot be used with %s", describe("CORSAllowCredentials"))
+			continue
+		}
+		check("CORSAllowedOrigins", strings.Contains(origin, "://"), "invalid origin %q, expected scheme://host[:port]", origin)
+	}
+	check("CORSAllowMethods", len(c.CORSAllowMethods) > 0, "must not be empty")
+	check("CORSMaxAge", c.CORSMaxAge >= 0, "must not be negative")
+
+	// JWT configuration
+	check("JWTSecret", c.JWTSecret != "", "must not be empty")
+	check("JWTExpiration", c.JWTExpiration > 0, "must be positive")
+	check("JWTAdminRole", c.JWTAdminRole != "", "must not be empty")
+	check("InternalAPIToken", c.InternalAPIToken == "" || len(c.InternalAPIToken) >= 32, "must be at least 32 characters")
+
+	// Vault secrets
+	check("VaultAuthMethod", oneOf(c.VaultAuthMethod, "token", "kubernetes"),
+		"unknown auth method %q, expected token or kubernetes", c.VaultAuthMethod)
+	check("VaultAddr", c.VaultAddr != "" || (len(c.VaultKVSecrets) == 0 && c.VaultDBRole == ""),
+		"must be set when Vault secrets are configured")
+
+	// Tracing configuration
+	check("TracingExporter", oneOf(c.TracingExporter, "none", "otlp", "stdout", "file"),
+		"unknown exporter %q, expected none, otlp, stdout or file", c.TracingExporter)
+	check("TracingSampleRatio", c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1,
+		"must be between 0 and 1")
+
+	// Rate limiting
+	check("RateLimitRequests", c.RateLimitRequests > 0, "must be positive")
+	check("RateLimitWindow", c.RateLimitWindow > 0, "must be positive")
+	check("RateLimitReloadInterval", c.RateLimitReloadInterval >= 0, "must not be negative")
+
+	// Refuse to run production with the development defaults
+	if c.Environment == "production" {
+		check("JWTSecret", c.JWTSecret != defaultJWTSecret, "default secret is not allowed in production")
+		check("JWTSecret", len(c.JWTSecret) >= minJWTSecretLength,
+			"must be at least %d characters in production", minJWTSecretLength)
+		// Dynamic credentials from Vault replace the static password
+		check("DBPassword", c.VaultDBRole != "" || (c.DBPassword != "" && c.DBPassword != defaultDBPassword),
+			"default or empty password is not allowed in production")
+		check("CORSAllowedOrigins", !oneOf("*", c.CORSAllowedOrigins...), "explicit origins are required in production")
+	}
+
+	return errors.Join(errs...)
+}
+
+// SecretKeys returns the environment variable names of the secret settings,
+// e.g. DB_PASSWORD
+func SecretKeys() []string {
+	var keys []string
+	for _, f := range fields(&Config{}) {
+		if f.secret {
+			keys = append(keys, f.env)
+		}
+	}
+	return keys
+}
+
+// SetSecret assigns the secret setting named by its environment variable
+func (c *Config) SetSecret(env, value string) error {
+	for _, f := range fields(c) {
+		if f.env == env && f.secret {
+			f.value.SetString(value)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a secret setting", env)
+}
+
+// validPort reports whether port is a TCP port number
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// validHTTPURL reports whether value is an absolute http or https URL
+func validHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is a settable Config field together with its tags
+type field struct {
+	name       string
+	key        string
+	env        string
+	def        string
+	hasDef     bool
+	secret     bool
+	value      reflect.Value
+	reloadable bool
+}
+
+// fields returns the fields of cfg in declaration order
+func fields(cfg *Config) []field {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	result := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		def, hasDef := sf.Tag.Lookup("default")
+		result = append(result, field{
+			name:       sf.Name,
+			key:        sf.Tag.Get("key"),
+			env:        sf.Tag.Get("env"),
+			def:        def,
+			hasDef:     hasDef,
+			secret:     sf.Tag.Get("secret") == "true",
+			value:      v.Field(i),
+			reloadable: sf.Tag.Get("reload") == "true",
+		})
+	}
+	return result
+}
+
+// describe names a Config field by its file key and environment variable
+func describe(name string) string {
+	sf, ok := reflect.TypeOf(Config{}).FieldByName(name)
+	if !ok {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", sf.Tag.Get("key"), sf.Tag.Get("env"))
+}
+
+func applyDefaults(cfg *Config) error {
+	for _, f := range fields(cfg) {
+		if !f.hasDef {
+			continue
+		}
+		if err := setString(f.value, f.def); err != nil {
+			return fmt.Errorf("invalid default for %s: %w", f.key, err)
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides fields with their environment variables. Empty
+// variables are treated as unset.
+func applyEnv(cfg *Config) error {
+	for _, f := range fields(cfg) {
+		value := os.Getenv(f.env)
+		if value == "" {
+			continue
+		}
+		if err := setString(f.value, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", f.env, err)
+		}
+	}
+	return nil
+}
+
+// applyFile overrides fields with the values of a YAML or TOML file, chosen
+// by its extension. Unknown keys are rejected so typos are not silently ignored.
+func applyFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("unsupported config file extension %q, expected .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := map[string]interface{}{}
+	flatten("", raw, values)
+
+	byKey := map[string]field{}
+	for _, f := range fields(cfg) {
+		byKey[f.key] = f
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown key %q", path, key)
+		}
+		if err := setValue(f.value, values[key]); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %w", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+// flatten turns nested sections into dotted keys
+func flatten(prefix string, in map[string]interface{}, out map[string]interface{}) {
+	for key, value := range in {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if section, ok := value.(map[string]interface{}); ok {
+			flatten(key, section, out)
+			continue
+		}
+		out[key] = value
+	}
+}
+
+// setValue assigns a value decoded from a config file
+func setValue(v reflect.Value, value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+	}
+	if value == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	return setString(v, fmt.Sprint(value))
+}
+
+// setString parses value into v according to its type. Durations accept Go
+// duration strings such as "30s" or a bare number of seconds.
+func setString(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration (e.g. 30s, 5m) or number of seconds", value)
+	}
+	return d, nil
+}
+
+// splitList splits a comma-separated value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
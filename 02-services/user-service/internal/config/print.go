@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secrets in redacted output
+const redactedValue = "REDACTED"
+
+// Print writes the effective configuration to w as YAML in the same layout
+// accepted by Load. Secrets are replaced when redacted is set.
+func Print(w io.Writer, cfg *Config, redacted bool) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{}
+
+	for _, f := range fields(cfg) {
+		section, name, _ := strings.Cut(f.key, ".")
+
+		parent, ok := sections[section]
+		if !ok {
+			parent = &yaml.Node{Kind: yaml.MappingNode}
+			sections[section] = parent
+			root.Content = append(root.Content, scalar(section), parent)
+		}
+
+		value := f.value.Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		if f.secret && redacted && f.value.String() != "" {
+			value = redactedValue
+		}
+
+		node := &yaml.Node{}
+		if err := node.Encode(value); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", f.key, err)
+		}
+		parent.Content = append(parent.Content, scalar(name), node)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return fmt.Errorf("failed to print config: %w", err)
+	}
+	return encoder.Close()
+}
+
+func scalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Snapshot is an applied configuration and its version
+type Snapshot struct {
+	Config   *Config
+	Version  int
+	Checksum string
+	LoadedAt time.Time
+}
+
+// Change describes a setting that differs between two configurations
+type Change struct {
+	Key        string
+	Old        string
+	New        string
+	Reloadable bool
+}
+
+// Store holds the active configuration and replaces it atomically when the
+// config source changes. Only settings tagged reload:"true" may change
+// without a restart.
+type Store struct {
+	path string
+	load func() (*Config, error)
+
+	mu        sync.Mutex
+	active    atomic.Pointer[Snapshot]
+	listeners []func(*Config)
+}
+
+// NewStore creates a store whose first version is cfg. load re-reads the
+// configuration from path and the environment.
+func NewStore(cfg *Config, path string, load func() (*Config, error)) *Store {
+	s := &Store{path: path, load: load}
+	s.active.Store(&Snapshot{
+		Config:   cfg,
+		Version:  1,
+		Checksum: checksum(cfg),
+		LoadedAt: time.Now(),
+	})
+	return s
+}
+
+// Current returns the active configuration. It must not be modified.
+func (s *Store) Current() *Config {
+	return s.active.Load().Config
+}
+
+// Snapshot returns the active configuration with its version
+func (s *Store) Snapshot() Snapshot {
+	return *s.active.Load()
+}
+
+// OnReload registers fn to apply reloadable settings after each reload
+func (s *Store) OnReload(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Reload re-reads the configuration and applies it when only reloadable
+// settings changed. Otherwise the active configuration is kept and an error
+// naming the settings that require a restart is returned. The changes found
+// are returned in both cases.
+func (s *Store) Reload() ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	current := s.active.Load()
+	changes := Diff(current.Config, cfg)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	var fixed []string
+	for _, change := range changes {
+		if !change.Reloadable {
+			fixed = append(fixed, change.Key)
+		}
+	}
+	if len(fixed) > 0 {
+		return changes, fmt.Errorf("settings require a restart: %s", strings.Join(fixed, ", "))
+	}
+
+	s.active.Store(&Snapshot{
+		Config:   cfg,
+		Version:  current.Version + 1,
+		Checksum: checksum(cfg),
+		LoadedAt: time.Now(),
+	})
+
+	for _, fn := range s.listeners {
+		fn(cfg)
+	}
+
+	return changes, nil
+}
+
+// Watch reloads the configuration when the config file changes, polled every
+// interval, or when the process receives SIGHUP, until ctx is cancelled
+func (s *Store) Watch(ctx context.Context, interval time.Duration, log *logrus.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	var modTime time.Time
+	if s.path != "" && interval > 0 {
+		if info, err := os.Stat(s.path); err == nil {
+			modTime = info.ModTime()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info("Received SIGHUP, reloading configuration")
+		case <-tick:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				log.Warnf("Failed to stat config file: %v", err)
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			log.Infof("Config file %s changed, reloading configuration", s.path)
+		}
+
+		changes, err := s.Reload()
+		for _, change := range changes {
+			log.WithFields(logrus.Fields{
+				"key":        change.Key,
+				"old":        change.Old,
+				"new":        change.New,
+				"reloadable": change.Reloadable,
+			}).Info("Configuration setting changed")
+		}
+		if err != nil {
+			log.Errorf("Configuration reload rejected, keeping version %d: %v", s.Snapshot().Version, err)
+			continue
+		}
+		if len(changes) == 0 {
+			log.Info("Configuration unchanged")
+			continue
+		}
+		log.Infof("Configuration version %d applied", s.Snapshot().Version)
+	}
+}
+
+// Diff lists the settings that differ between old and new, in declaration
+// order. Secret values are never included.
+func Diff(old, new *Config) []Change {
+	oldFields, newFields := fields(old), fields(new)
+
+	var changes []Change
+	for i, f := range oldFields {
+		oldValue, newValue := formatValue(f), formatValue(newFields[i])
+		if oldValue == newValue {
+			continue
+		}
+
+		change := Change{
+			Key:        f.key,
+			Old:        oldValue,
+			New:        newValue,
+			Reloadable: f.reloadable,
+		}
+		if f.secret {
+			change.Old, change.New = redactedValue, redactedValue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func formatValue(f field) string {
+	switch value := f.value.Interface().(type) {
+	case time.Duration:
+		return value.String()
+	case []string:
+		return strings.Join(value, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// checksum identifies a configuration by its redacted rendering, so the
+// version shown in health output never depends on secret values
+func checksum(cfg *Config) string {
+	var buf bytes.Buffer
+	if err := Print(&buf, cfg, true); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:6])
+}
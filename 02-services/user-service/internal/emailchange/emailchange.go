@@ -0,0 +1,104 @@
+// Package emailchange issues the single-use tokens that confirm a change of
+// email address and sends the emails of the workflow: the confirmation link
+// to the new address and a notice to the previous one
+package emailchange
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/notify"
+	"github.com/google/uuid"
+)
+
+// tokenBytes is the length of the random part of a token
+const tokenBytes = 32
+
+// Manager starts email changes and notifies users about them
+type Manager struct {
+	ttl        time.Duration
+	confirmURL string
+	notifier   notify.Notifier
+}
+
+// New creates a Manager from the email change settings of cfg, sending
+// notifications with notifier
+func New(cfg *config.Config, notifier notify.Notifier) *Manager {
+	return &Manager{
+		ttl:        cfg.EmailChangeTokenTTL,
+		confirmURL: cfg.EmailChangeConfirmURL,
+		notifier:   notifier,
+	}
+}
+
+// Start creates a pending change of a user's email to newEmail and the token
+// confirming it. Only the token's hash is kept in the change.
+func (m *Manager) Start(userID uuid.UUID, newEmail string) (*models.EmailChange, string, error) {
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate email change token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	return &models.EmailChange{
+		UserID:    userID,
+		NewEmail:  newEmail,
+		TokenHash: HashToken(token),
+		ExpiresAt: time.Now().Add(m.ttl),
+	}, token, nil
+}
+
+// HashToken returns the hash of a token stored with its change, so the
+// tokens themselves are never stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SendConfirmation sends the link confirming change, carrying token, to the
+// new address
+func (m *Manager) SendConfirmation(ctx context.Context, change *models.EmailChange, token string) error {
+	link := strings.ReplaceAll(m.confirmURL, "{token}", url.QueryEscape(token))
+	expires := change.ExpiresAt.UTC().Format(time.RFC3339)
+
+	return m.notifier.Notify(ctx, notify.Notification{
+		UserID:  change.UserID,
+		Type:    notify.EmailChangeRequested,
+		Email:   change.NewEmail,
+		Subject: "Confirm your new email address",
+		Message: fmt.Sprintf("Open this link to use %s for your account: %s\n\n"+
+			"The link expires at %s. If you did not ask for this change, ignore this email.",
+			change.NewEmail, link, expires),
+		Data: map[string]string{
+			"new_email":   change.NewEmail,
+			"confirm_url": link,
+			"expires_at":  expires,
+		},
+	})
+}
+
+// SendChanged tells the previous address of user that its email was changed
+func (m *Manager) SendChanged(ctx context.Context, user *models.User, previous string) error {
+	return m.notifier.Notify(ctx, notify.Notification{
+		UserID:  user.ID,
+		Type:    notify.EmailChanged,
+		Email:   previous,
+		Subject: "Your email address was changed",
+		Message: fmt.Sprintf("The email address of your account %s was changed to %s. "+
+			"If you did not make this change, contact support right away.",
+			user.Username, user.Email),
+		Data: map[string]string{
+			"previous_email": previous,
+			"new_email":      user.Email,
+		},
+	})
+}
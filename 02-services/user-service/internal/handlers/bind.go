@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/response"
+	"github.com/devsecops/user-service/internal/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Errors of request bodies that are not a single JSON value
+var (
+	errEmptyBody    = errors.New("request body is empty")
+	errTrailingData = errors.New("request body must contain a single JSON object")
+)
+
+// bindStrictJSON decodes a single JSON object into obj, rejecting unknown
+// fields and trailing data, then validates it like ShouldBindJSON
+func bindStrictJSON(c *gin.Context, obj interface{}) error {
+	if c.Request.Body == nil {
+		return errEmptyBody
+	}
+
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(obj); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errTrailingData
+	}
+
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// bindError writes the response for a request body that could not be bound
+func bindError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		response.Error(c, http.StatusRequestEntityTooLarge, models.ErrorDetail{
+			Code:    apperrors.CodeRequestTooLarge,
+			Message: fmt.Sprintf("Request body must not exceed %d bytes", maxBytesErr.Limit),
+		})
+		return
+	}
+
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) {
+		_ = c.Error(err)
+		return
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		_ = c.Error(apperrors.Validation(apperrors.CodeValidation, "Invalid request data", validation.Fields(validationErrs)...))
+		return
+	}
+
+	_ = c.Error(apperrors.Validation(apperrors.CodeValidation, "Invalid request data", bodyFields(err)...))
+}
+
+// bodyFields describes an error decoding a JSON request body: a missing
+// body, a member of the wrong type, an unknown member or malformed JSON
+func bodyFields(err error) []apperrors.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+	switch {
+	case errors.Is(err, errEmptyBody), errors.Is(err, io.EOF):
+		return []apperrors.FieldError{{Rule: "required"}}
+	case errors.As(err, &typeErr):
+		return []apperrors.FieldError{{Field: typeErr.Field, Rule: apperrors.RuleType, Param: jsonType(typeErr.Type)}}
+	case errors.As(err, &timeErr):
+		// time.Time reports its own errors, without the member's name
+		return []apperrors.FieldError{{Rule: apperrors.RuleType, Param: "date_time"}}
+	}
+
+	// encoding/json has no error type for unknown fields
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if name, err := strconv.Unquote(name); err == nil {
+			return []apperrors.FieldError{{Field: name, Rule: apperrors.RuleUnknownField}}
+		}
+	}
+	return []apperrors.FieldError{{Rule: apperrors.RuleSyntax}}
+}
+
+// jsonType names the JSON type values of Go type t decode from, as in JSON
+// Schema, with date_time for time.Time
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return "date_time"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return ""
+}
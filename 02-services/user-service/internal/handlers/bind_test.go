@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+)
+
+// TestPutRejectsUnknownFields checks that every PUT body is bound strictly
+func TestPutRejectsUnknownFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		wantDetails []models.FieldError
+	}{
+		{
+			name:        "user",
+			path:        "/users/%s",
+			body:        `{"first_name": "Alicia", "frist_name": "Alicia"}`,
+			wantDetails: []models.FieldError{{Field: "frist_name", Rule: apperrors.RuleUnknownField, Message: "is not a known field"}},
+		},
+		{
+			name:        "profile",
+			path:        "/users/%s/profile",
+			body:        `{"bio": "Hello", "biography": "Hello"}`,
+			wantDetails: []models.FieldError{{Field: "biography", Rule: apperrors.RuleUnknownField, Message: "is not a known field"}},
+		},
+		{
+			name:        "profile with trailing data",
+			path:        "/users/%s/profile",
+			body:        `{"bio": "Hello"} {"bio": "again"}`,
+			wantDetails: []models.FieldError{{Rule: apperrors.RuleSyntax, Message: "Request body is not valid JSON"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, false)
+			user := s.createUser(t)
+
+			rec := s.serve(http.MethodPut, fmt.Sprintf(tt.path, user.ID), "application/json", tt.body, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+			detail := decodeError(t, rec)
+			if detail.Code != apperrors.CodeValidation || !reflect.DeepEqual(detail.Details, tt.wantDetails) {
+				t.Errorf("got %s %+v, want %s %+v", detail.Code, detail.Details, apperrors.CodeValidation, tt.wantDetails)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/password"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Fields of a password change reported in validation errors
+const (
+	currentPasswordField = "current_password"
+	newPasswordField     = "new_password"
+)
+
+// ChangePassword changes the password of a user. Users changing their own
+// password give the current one; admins reset other users' passwords
+// without it. Tokens issued before the change are revoked.
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		_ = c.Error(errInvalidID())
+		return
+	}
+
+	self := c.GetString("user_id") == id.String()
+	if !self && !c.GetBool("admin") {
+		_ = c.Error(apperrors.Forbidden(apperrors.CodeForbidden, "You can only change your own password"))
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := bindStrictJSON(c, &req); err != nil {
+		bindError(c, err)
+		return
+	}
+
+	user, err := h.repo.FindCredentials(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if self {
+		if err := h.checkCurrentPassword(c, user, req.CurrentPassword); err != nil {
+			_ = c.Error(err).SetMeta("Failed to check password")
+			return
+		}
+	}
+
+	if err := h.checkNewPassword(ctx, user, req.NewPassword); err != nil {
+		_ = c.Error(err).SetMeta("Failed to check password")
+		return
+	}
+	passwordHash, err := h.passwords.Hash(req.NewPassword)
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to change password")
+		return
+	}
+
+	if err := h.repo.ChangePassword(ctx, id, passwordHash, max(h.passwords.HistorySize()-1, 0)); err != nil {
+		_ = c.Error(err).SetMeta("Failed to change password")
+		return
+	}
+
+	if self {
+		h.log.WithContext(ctx).Infof("Password changed: %s", id)
+	} else {
+		h.log.WithContext(ctx).Infof("Password reset: %s by %s", id, c.GetString("user_id"))
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Password changed successfully",
+	})
+}
+
+// VerifyCredentials checks a login and password for auth-service. It
+// returns the user, with the time of the previous login, and records this
+// one. Wrong passwords count towards the lockout.
+func (h *UserHandler) VerifyCredentials(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req models.VerifyCredentialsRequest
+	if err := bindStrictJSON(c, &req); err != nil {
+		bindError(c, err)
+		return
+	}
+
+	var user *models.User
+	var err error
+	if strings.Contains(req.Login, "@") {
+		user, err = h.repo.FindByEmail(ctx, req.Login)
+	} else {
+		user, err = h.repo.FindByUsername(ctx, req.Login)
+	}
+	if errors.Is(err, apperrors.ErrNotFound) {
+		// Take as long as for a wrong password, so logins cannot be probed
+		if _, err := h.passwords.Verify(ctx, "", req.Password, nil); err != nil {
+			_ = c.Error(err).SetMeta("Failed to verify credentials")
+			return
+		}
+		_ = c.Error(errInvalidCredentials())
+		return
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if err := errIfLocked(c, user); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ok, err := h.passwords.Verify(ctx, user.PasswordHash, req.Password, func(hash string) error {
+		return h.repo.RehashPassword(ctx, user.ID, user.PasswordHash, hash)
+	})
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to verify credentials")
+		return
+	}
+	if !ok {
+		_ = c.Error(h.passwordFailure(c, user, errInvalidCredentials())).SetMeta("Failed to verify credentials")
+		return
+	}
+
+	if !user.IsActive {
+		_ = c.Error(apperrors.Forbidden(apperrors.CodeAccountDisabled, "Account is disabled"))
+		return
+	}
+
+	// The password was right, so failing to record the login only costs
+	// the bookkeeping
+	if err := h.repo.RecordLogin(ctx, user.ID); err != nil {
+		h.log.WithContext(ctx).Warnf("Failed to record login of user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    user.ToResponse(),
+	})
+}
+
+// checkCurrentPassword checks the current password a user gave to change it
+func (h *UserHandler) checkCurrentPassword(c *gin.Context, user *models.User, current string) error {
+	if current == "" {
+		return apperrors.Validation(apperrors.CodeValidation, "Invalid request data",
+			apperrors.FieldError{Field: currentPasswordField, Rule: "required"})
+	}
+	if err := errIfLocked(c, user); err != nil {
+		return err
+	}
+
+	ok, err := h.passwords.Verify(c.Request.Context(), user.PasswordHash, current, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return h.passwordFailure(c, user, apperrors.Validation(apperrors.CodeValidation, "Current password is incorrect",
+			apperrors.FieldError{Field: currentPasswordField, Rule: "password_incorrect"}))
+	}
+	return nil
+}
+
+// checkNewPassword checks a new password against the policy and the
+// passwords the user had recently
+func (h *UserHandler) checkNewPassword(ctx context.Context, user *models.User, newPassword string) error {
+	err := h.passwords.Validate(newPassword, user.Username, user.Email)
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) {
+		// Report problems under the request member, not password.Field
+		for i := range appErr.Fields {
+			if appErr.Fields[i].Field == password.Field {
+				appErr.Fields[i].Field = newPasswordField
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	size := h.passwords.HistorySize()
+	if size == 0 {
+		return nil
+	}
+	history, err := h.repo.PasswordHistory(ctx, user.ID, size-1)
+	if err != nil {
+		return err
+	}
+	if h.passwords.Reused(newPassword, append([]string{user.PasswordHash}, history...)) {
+		return apperrors.Validation(apperrors.CodeValidation, "Invalid request data",
+			apperrors.FieldError{Field: newPasswordField, Rule: "password_reused", Param: strconv.Itoa(size)})
+	}
+	return nil
+}
+
+// passwordFailure counts a wrong password of user and returns the error to
+// report: err, or ACCOUNT_LOCKED if it locked the account
+func (h *UserHandler) passwordFailure(c *gin.Context, user *models.User, err error) error {
+	lockout := h.passwords.Lockout()
+	if lockout.Threshold == 0 {
+		return err
+	}
+
+	ctx := c.Request.Context()
+	until, recordErr := h.repo.RecordLoginFailure(ctx, user.ID, lockout.Threshold, lockout.Duration)
+	if recordErr != nil {
+		return recordErr
+	}
+	if until == nil {
+		return err
+	}
+
+	h.log.WithContext(ctx).Warnf("User %s locked until %s after %d wrong passwords",
+		user.ID, until.Format(time.RFC3339), lockout.Threshold)
+	return errLocked(c, *until)
+}
+
+// errIfLocked returns ACCOUNT_LOCKED if user is locked out
+func errIfLocked(c *gin.Context, user *models.User) error {
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		return errLocked(c, *user.LockedUntil)
+	}
+	return nil
+}
+
+// errLocked returns ACCOUNT_LOCKED, telling the client when to retry
+func errLocked(c *gin.Context, until time.Time) error {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	return apperrors.Locked(apperrors.CodeAccountLocked, "Account is temporarily locked")
+}
+
+func errInvalidCredentials() error {
+	return apperrors.Unauthorized(apperrors.CodeInvalidCredentials, "Invalid login or password")
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/emailchange"
+	"github.com/devsecops/user-service/internal/middleware"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/notify"
+	"github.com/devsecops/user-service/internal/password"
+	"github.com/devsecops/user-service/internal/preferences"
+	"github.com/devsecops/user-service/internal/repository"
+	"github.com/devsecops/user-service/internal/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testServer serves the user routes of routes.go, without authentication,
+// from an in-memory store. With requireIfMatch, writes need If-Match.
+type testServer struct {
+	router *gin.Engine
+	store  *repository.MemoryStore
+}
+
+func newTestServer(t *testing.T, requireIfMatch bool) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	cfg, err := config.Read("")
+	if err != nil {
+		t.Fatalf("config.Read: %v", err)
+	}
+	cfg.PasswordBcryptCost = bcrypt.MinCost
+	if err := validation.Register(cfg); err != nil {
+		t.Fatalf("validation.Register: %v", err)
+	}
+	prefs, err := preferences.Load("")
+	if err != nil {
+		t.Fatalf("preferences.Load: %v", err)
+	}
+	passwords, err := password.New(cfg, log)
+	if err != nil {
+		t.Fatalf("password.New: %v", err)
+	}
+	emails := emailchange.New(cfg, notify.New("", http.DefaultClient, log))
+
+	store := repository.NewMemoryStore()
+	h := NewUserHandler(store, prefs, passwords, emails, requireIfMatch, log)
+
+	router := gin.New()
+	router.Use(middleware.ErrorMiddleware(log))
+	users := router.Group("/users")
+	users.GET("/:id", h.GetUser)
+	users.PUT("/:id", h.UpdateUser)
+	users.PATCH("/:id", h.PatchUser)
+	users.DELETE("/:id", h.DeleteUser)
+	users.PUT("/:id/profile", h.UpdateProfile)
+
+	return &testServer{router: router, store: store}
+}
+
+// createUser stores a user and returns it at version 1
+func (s *testServer) createUser(t *testing.T) *models.User {
+	t.Helper()
+	user := &models.User{
+		Email:     "alice@example.com",
+		Username:  "alice",
+		FirstName: "Alice",
+		LastName:  "Smith",
+		IsActive:  true,
+	}
+	if err := s.store.Create(context.Background(), user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return user
+}
+
+func (s *testServer) serve(method, path, contentType, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeError decodes an error response, failing the test if the body is not one
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) models.ErrorDetail {
+	t.Helper()
+	var resp models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Code == "" {
+		t.Fatalf("not an error response: %s", rec.Body.String())
+	}
+	return resp.Error
+}
@@ -2,76 +2,118 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/health"
 	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/version"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 // HealthHandler handles health check requests
 type HealthHandler struct {
-	db *gorm.DB
+	checks  *health.Registry
+	configs *config.Store
 }
 
-// NewHealthHandler creates a new health handler
-func NewHealthHandler(db *gorm.DB) *HealthHandler {
+// NewHealthHandler creates a new health handler reporting the active config version
+func NewHealthHandler(checks *health.Registry, configs *config.Store) *HealthHandler {
 	return &HealthHandler{
-		db: db,
+		checks:  checks,
+		configs: configs,
 	}
 }
 
 // Health returns basic health status
 func (h *HealthHandler) Health(c *gin.Context) {
-	c.JSON(http.StatusOK, models.HealthResponse{
-		Status:  "healthy",
-		Service: "user-service",
-		Version: "1.0.0",
-	})
+	c.JSON(http.StatusOK, h.response("healthy", nil))
 }
 
-// ReadinessCheck checks if service is ready (includes DB check)
+// ReadinessCheck runs the registered checks. The service is ready when every
+// critical check passes; ?verbose=1 adds per-check latency and errors.
 func (h *HealthHandler) ReadinessCheck(c *gin.Context) {
-	checks := make(map[string]string)
-
-	// Check database connection
-	sqlDB, err := h.db.DB()
-	if err != nil {
-		checks["database"] = "unhealthy"
-		c.JSON(http.StatusServiceUnavailable, models.HealthResponse{
-			Status:  "unhealthy",
-			Service: "user-service",
-			Version: "1.0.0",
-			Checks:  checks,
-		})
-		return
+	report := h.checks.Run(c.Request.Context())
+
+	statusCode := http.StatusOK
+	if !report.Ready {
+		statusCode = http.StatusServiceUnavailable
 	}
 
-	if err := sqlDB.Ping(); err != nil {
-		checks["database"] = "unhealthy"
-		c.JSON(http.StatusServiceUnavailable, models.HealthResponse{
-			Status:  "unhealthy",
-			Service: "user-service",
-			Version: "1.0.0",
-			Checks:  checks,
+	if verbose := c.Query("verbose"); verbose == "1" || verbose == "true" {
+		details := make(map[string]models.CheckDetail, len(report.Results))
+		for _, result := range report.Results {
+			details[result.Name] = newCheckDetail(result)
+		}
+
+		snapshot := h.configs.Snapshot()
+		c.JSON(statusCode, models.DetailedHealthResponse{
+			Status:        report.Status,
+			Service:       snapshot.Config.ServiceName,
+			Version:       version.Version,
+			Commit:        version.Commit,
+			BuildTime:     version.BuildTime,
+			ConfigVersion: newConfigVersion(snapshot),
+			Checks:        details,
 		})
 		return
 	}
 
-	checks["database"] = "healthy"
+	checks := make(map[string]string, len(report.Results))
+	for _, result := range report.Results {
+		checks[result.Name] = result.Status
+	}
 
-	c.JSON(http.StatusOK, models.HealthResponse{
-		Status:  "healthy",
-		Service: "user-service",
-		Version: "1.0.0",
-		Checks:  checks,
-	})
+	c.JSON(statusCode, h.response(report.Status, checks))
 }
 
 // LivenessCheck checks if service is alive
 func (h *HealthHandler) LivenessCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, models.HealthResponse{
-		Status:  "alive",
-		Service: "user-service",
-		Version: "1.0.0",
-	})
+	c.JSON(http.StatusOK, h.response("alive", nil))
+}
+
+// StartupCheck fails until startup work such as migrations has finished
+func (h *HealthHandler) StartupCheck(c *gin.Context) {
+	if !h.checks.Started() {
+		c.JSON(http.StatusServiceUnavailable, h.response("starting", nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, h.response("started", nil))
+}
+
+func (h *HealthHandler) response(status string, checks map[string]string) models.HealthResponse {
+	snapshot := h.configs.Snapshot()
+	return models.HealthResponse{
+		Status:        status,
+		Service:       snapshot.Config.ServiceName,
+		Version:       version.Version,
+		Commit:        version.Commit,
+		ConfigVersion: newConfigVersion(snapshot),
+		Checks:        checks,
+	}
+}
+
+func newConfigVersion(snapshot config.Snapshot) *models.ConfigVersion {
+	return &models.ConfigVersion{
+		Version:  snapshot.Version,
+		Checksum: snapshot.Checksum,
+		LoadedAt: snapshot.LoadedAt,
+	}
+}
+
+func newCheckDetail(result health.Result) models.CheckDetail {
+	detail := models.CheckDetail{
+		Status:    result.Status,
+		Critical:  result.Critical,
+		LatencyMS: float64(result.Latency) / float64(time.Millisecond),
+		Error:     result.Error,
+		LastError: result.LastError,
+		CheckedAt: result.CheckedAt,
+	}
+	if !result.LastSuccess.IsZero() {
+		lastSuccess := result.LastSuccess
+		detail.LastSuccess = &lastSuccess
+	}
+	return detail
 }
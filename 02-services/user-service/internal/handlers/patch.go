@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/validation"
+	"github.com/devsecops/user-service/pkg/mergepatch"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm/schema"
+)
+
+// maxPatchAttempts bounds how often a merge into a stored document is retried
+// when another request changes it meanwhile
+const maxPatchAttempts = 3
+
+// bindMergePatch reads a JSON merge patch (RFC 7396) of the fields declared
+// by req, a pointer to a struct like models.PatchUserRequest, and returns
+// the column updates it makes: absent members leave a column alone, null
+// clears it (to the empty string, or NULL for dates) and other values set
+// it. Members of fields tagged patch:"merge" are returned by column
+// unchanged, to be merged into the stored document. Unknown members, values
+// of the wrong type, nulls for fields tagged patch:"notnull" and values
+// breaking a field's binding rules are reported together, one per member.
+func bindMergePatch(c *gin.Context, req interface{}) (map[string]interface{}, map[string]json.RawMessage, error) {
+	if c.Request.Body == nil {
+		return nil, nil, errEmptyBody
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil, errEmptyBody
+	}
+	members, err := mergepatch.Object(body)
+	if err != nil {
+		if json.Valid(body) {
+			return nil, nil, apperrors.Validation(apperrors.CodeValidation, "Invalid request data",
+				apperrors.FieldError{Rule: apperrors.RuleType, Param: "object"})
+		}
+		return nil, nil, err
+	}
+
+	value := reflect.ValueOf(req).Elem()
+	fields := jsonFields(value.Type())
+	naming := schema.NamingStrategy{}
+
+	updates := map[string]interface{}{}
+	merges := map[string]json.RawMessage{}
+	var problems []apperrors.FieldError
+	for name, raw := range members {
+		field, ok := fields[name]
+		if !ok {
+			problems = append(problems, apperrors.FieldError{Field: name, Rule: apperrors.RuleUnknownField})
+			continue
+		}
+		column := naming.ColumnName("", field.Name)
+
+		switch rule := field.Tag.Get("patch"); {
+		case rule == "merge":
+			merges[column] = raw
+		case mergepatch.IsNull(raw) && rule == "notnull":
+			problems = append(problems, apperrors.FieldError{Field: name, Rule: apperrors.RuleNotNull})
+		case mergepatch.IsNull(raw):
+			updates[column] = clearedValue(field.Type)
+		default:
+			target := value.FieldByIndex(field.Index)
+			if err := json.Unmarshal(raw, target.Addr().Interface()); err != nil {
+				problems = append(problems, apperrors.FieldError{Field: name, Rule: apperrors.RuleType, Param: jsonType(field.Type)})
+				continue
+			}
+			updates[column] = target.Elem().Interface()
+		}
+	}
+
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		var validationErrs validator.ValidationErrors
+		if !errors.As(err, &validationErrs) {
+			return nil, nil, err
+		}
+		// A member that failed to decode is left zero, which its rules may
+		// reject too; only the decoding problem is reported for it
+		reported := make(map[string]bool, len(problems))
+		for _, problem := range problems {
+			reported[problem.Field] = true
+		}
+		for _, problem := range validation.Fields(validationErrs) {
+			if !reported[problem.Field] {
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Field < problems[j].Field })
+		return nil, nil, apperrors.Validation(apperrors.CodeValidation, "Invalid request data", problems...)
+	}
+	return updates, merges, nil
+}
+
+// jsonFields indexes the fields of a struct type by JSON name
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if name := jsonName(t, field.Name); name != "-" {
+			fields[name] = field
+		}
+	}
+	return fields
+}
+
+// jsonName returns the JSON name of the named field of a struct type
+func jsonName(t reflect.Type, fieldName string) string {
+	field, ok := t.FieldByName(fieldName)
+	if !ok {
+		return fieldName
+	}
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// clearedValue is the value a null member sets for a field of type t, a
+// pointer: NULL for structs such as time.Time, the zero value otherwise
+func clearedValue(t reflect.Type) interface{} {
+	if t.Kind() != reflect.Pointer || t.Elem().Kind() == reflect.Struct {
+		return nil
+	}
+	return reflect.Zero(t.Elem()).Interface()
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/pkg/mergepatch"
+)
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		patch       string
+		wantStatus  int
+		wantDetails []models.FieldError
+		wantUser    models.User
+	}{
+		{
+			name:       "null on a non-nullable field",
+			patch:      `{"first_name": null}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetails: []models.FieldError{
+				{Field: "first_name", Rule: apperrors.RuleNotNull, Message: "must not be null"},
+			},
+		},
+		{
+			name:       "every problem is reported",
+			patch:      `{"last_name": null, "first_name": null, "nickname": "Al"}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetails: []models.FieldError{
+				{Field: "first_name", Rule: apperrors.RuleNotNull, Message: "must not be null"},
+				{Field: "last_name", Rule: apperrors.RuleNotNull, Message: "must not be null"},
+				{Field: "nickname", Rule: apperrors.RuleUnknownField, Message: "is not a known field"},
+			},
+		},
+		{
+			name:       "null clears a nullable field",
+			patch:      `{"phone": null, "first_name": "Alicia"}`,
+			wantStatus: http.StatusOK,
+			wantUser:   models.User{FirstName: "Alicia", LastName: "Smith"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, false)
+			user := s.createUser(t)
+			if err := s.store.Update(context.Background(), user.ID, user.Version, map[string]interface{}{"phone": "+33123456789"}); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+
+			rec := s.serve(http.MethodPatch, "/users/"+user.ID.String(), mergepatch.MediaType, tt.patch, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			stored, err := s.store.FindByID(context.Background(), user.ID)
+			if err != nil {
+				t.Fatalf("FindByID: %v", err)
+			}
+			if tt.wantDetails != nil {
+				detail := decodeError(t, rec)
+				if detail.Code != apperrors.CodeValidation {
+					t.Errorf("code = %s, want %s", detail.Code, apperrors.CodeValidation)
+				}
+				if !reflect.DeepEqual(detail.Details, tt.wantDetails) {
+					t.Errorf("details = %+v, want %+v", detail.Details, tt.wantDetails)
+				}
+				if stored.Version != 2 || stored.FirstName != "Alice" || stored.LastName != "Smith" {
+					t.Errorf("rejected patch changed the user: %+v", stored)
+				}
+				return
+			}
+
+			if stored.FirstName != tt.wantUser.FirstName || stored.LastName != tt.wantUser.LastName || stored.Phone != "" {
+				t.Errorf("stored user = %+v", stored)
+			}
+		})
+	}
+}
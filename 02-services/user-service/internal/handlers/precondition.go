@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// etag is the entity tag of the representation of a resource at version
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// setETag sends the entity tag of a resource at version
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", etag(version))
+}
+
+// notModified answers a GET with 304 if the client's If-None-Match lists the
+// resource's current entity tag, and reports whether it did. Weak tags
+// match as well, as for any If-None-Match.
+func notModified(c *gin.Context, version int64) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	current := etag(version)
+	for _, tag := range splitETags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			setETag(c, version)
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion returns the version a write must apply to according to the
+// client's If-Match header: repository.AnyVersion for "*" or without one,
+// unless the handler requires it, otherwise the version of the one listed
+// tag, or of the listed tag that matches current. Weak tags never match. The
+// store checks the version again when writing, so a concurrent write in
+// between still fails.
+func (h *UserHandler) ifMatchVersion(c *gin.Context, current int64) (int64, error) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		if h.requireIfMatch {
+			return 0, apperrors.PreconditionRequired(apperrors.CodeIfMatchRequired, "If-Match is required")
+		}
+		return repository.AnyVersion, nil
+	}
+
+	var versions []int64
+	for _, tag := range splitETags(header) {
+		if tag == "*" {
+			return repository.AnyVersion, nil
+		}
+		if version, ok := parseETag(tag); ok {
+			versions = append(versions, version)
+		}
+	}
+
+	if len(versions) == 1 {
+		return versions[0], nil
+	}
+	for _, version := range versions {
+		if version == current {
+			return version, nil
+		}
+	}
+	return 0, errVersionMismatch()
+}
+
+func errVersionMismatch() error {
+	return apperrors.PreconditionFailed(apperrors.CodeVersionMismatch, "If-Match does not match the current version")
+}
+
+// parseETag returns the version of a strong entity tag made by etag
+func parseETag(tag string) (int64, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// splitETags splits a comma-separated list of entity tags
+func splitETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
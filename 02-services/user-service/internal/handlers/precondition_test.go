@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func newConditionalContext(name, value string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if value != "" {
+		c.Request.Header.Set(name, value)
+	}
+	return c, rec
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	const current = 3
+
+	tests := []struct {
+		name     string
+		header   string
+		require  bool
+		want     int64
+		wantKind error
+	}{
+		{name: "no header", want: repository.AnyVersion},
+		{name: "no header when required", require: true, wantKind: apperrors.ErrPreconditionRequired},
+		{name: "any", header: "*", want: repository.AnyVersion},
+		{name: "any when required", header: "*", require: true, want: repository.AnyVersion},
+		{name: "any in a list", header: `"1", *`, want: repository.AnyVersion},
+		{name: "current version", header: `"3"`, require: true, want: 3},
+		{name: "single stale tag is left to the store", header: `"2"`, want: 2},
+		{name: "weak tag never matches", header: `W/"3"`, wantKind: apperrors.ErrPrecondition},
+		{name: "weak tag beside a strong one", header: `W/"3", "2"`, want: 2},
+		{name: "list with the current version", header: `"1", "3" ,"5"`, want: 3},
+		{name: "list without the current version", header: `"1","2"`, wantKind: apperrors.ErrPrecondition},
+		{name: "not a version", header: `"abc"`, wantKind: apperrors.ErrPrecondition},
+		{name: "unquoted", header: `3`, wantKind: apperrors.ErrPrecondition},
+		{name: "version zero", header: `"0"`, wantKind: apperrors.ErrPrecondition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newConditionalContext("If-Match", tt.header)
+			h := &UserHandler{requireIfMatch: tt.require}
+
+			got, err := h.ifMatchVersion(c, current)
+			if tt.wantKind != nil {
+				if !errors.Is(err, tt.wantKind) {
+					t.Fatalf("ifMatchVersion(%s) = %d, %v, want %v", tt.header, got, err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ifMatchVersion(%s) = %d, %v, want %d", tt.header, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: `"3"`, want: true},
+		{header: `W/"3"`, want: true},
+		{header: "*", want: true},
+		{header: `"1", W/"3"`, want: true},
+		{header: `"2"`, want: false},
+		{header: `"1", "2"`, want: false},
+		{header: `"abc"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			c, rec := newConditionalContext("If-None-Match", tt.header)
+			got := notModified(c, 3)
+			c.Writer.WriteHeaderNow()
+
+			if got != tt.want {
+				t.Fatalf("notModified(%s) = %v, want %v", tt.header, got, tt.want)
+			}
+			if got && (rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != `"3"`) {
+				t.Errorf("got status %d and ETag %s, want 304 and \"3\"", rec.Code, rec.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		require     bool
+		method      string
+		contentType string
+		body        string
+		ifMatch     string
+		wantStatus  int
+		wantCode    string
+		wantVersion int64
+	}{
+		{
+			name: "PUT with the current ETag", method: http.MethodPut, contentType: "application/json",
+			body: `{"first_name": "Alicia"}`, ifMatch: `"2"`, wantStatus: http.StatusOK, wantVersion: 3,
+		},
+		{
+			name: "PUT with a stale ETag", method: http.MethodPut, contentType: "application/json",
+			body: `{"first_name": "Alicia"}`, ifMatch: `"1"`,
+			wantStatus: http.StatusPreconditionFailed, wantCode: apperrors.CodeVersionMismatch, wantVersion: 2,
+		},
+		{
+			name: "PATCH with a list of stale ETags", method: http.MethodPatch, contentType: "application/merge-patch+json",
+			body: `{"first_name": "Alicia"}`, ifMatch: `"1", "3"`,
+			wantStatus: http.StatusPreconditionFailed, wantCode: apperrors.CodeVersionMismatch, wantVersion: 2,
+		},
+		{
+			name: "DELETE with a weak ETag", method: http.MethodDelete, ifMatch: `W/"2"`,
+			wantStatus: http.StatusPreconditionFailed, wantCode: apperrors.CodeVersionMismatch, wantVersion: 2,
+		},
+		{
+			name: "PATCH without If-Match", method: http.MethodPatch, contentType: "application/merge-patch+json",
+			body: `{"first_name": "Alicia"}`, wantStatus: http.StatusOK, wantVersion: 3,
+		},
+		{
+			name: "PATCH without If-Match when required", require: true, method: http.MethodPatch,
+			contentType: "application/merge-patch+json", body: `{"first_name": "Alicia"}`,
+			wantStatus: http.StatusPreconditionRequired, wantCode: apperrors.CodeIfMatchRequired, wantVersion: 2,
+		},
+		{
+			name: "DELETE without If-Match when required", require: true, method: http.MethodDelete,
+			wantStatus: http.StatusPreconditionRequired, wantCode: apperrors.CodeIfMatchRequired, wantVersion: 2,
+		},
+		{
+			name: "PUT with any ETag when required", require: true, method: http.MethodPut, contentType: "application/json",
+			body: `{"first_name": "Alicia"}`, ifMatch: "*", wantStatus: http.StatusOK, wantVersion: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.require)
+			user := s.createUser(t)
+			// Bring the user to version 2, so that "1" is stale
+			if err := s.store.Update(context.Background(), user.ID, user.Version, map[string]interface{}{"last_name": "Smyth"}); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+
+			var header http.Header
+			if tt.ifMatch != "" {
+				header = http.Header{"If-Match": {tt.ifMatch}}
+			}
+			rec := s.serve(tt.method, "/users/"+user.ID.String(), tt.contentType, tt.body, header)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				if detail := decodeError(t, rec); detail.Code != tt.wantCode {
+					t.Errorf("code = %s, want %s", detail.Code, tt.wantCode)
+				}
+			}
+
+			if tt.method != http.MethodDelete || tt.wantCode != "" {
+				assertVersion(t, s, user.ID, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestGetUserIfNoneMatch(t *testing.T) {
+	s := newTestServer(t, true)
+	user := s.createUser(t)
+	path := "/users/" + user.ID.String()
+
+	rec := s.serve(http.MethodGet, path, "", "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("GET = %d with ETag %s, want 200 with \"1\"", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	rec = s.serve(http.MethodGet, path, "", "", http.Header{"If-None-Match": {`W/"1"`}})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("GET with a matching weak ETag = %d with %q, want an empty 304", rec.Code, rec.Body.String())
+	}
+
+	rec = s.serve(http.MethodGet, path, "", "", http.Header{"If-None-Match": {`"2"`}})
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET with another ETag = %d, want 200", rec.Code)
+	}
+}
+
+// assertVersion checks the stored version of a user and that it is sent as
+// the ETag of the user
+func assertVersion(t *testing.T, s *testServer, id uuid.UUID, want int64) {
+	t.Helper()
+	user, err := s.store.FindByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if user.Version != want {
+		t.Errorf("version = %d, want %d", user.Version, want)
+	}
+	if tag := s.serve(http.MethodGet, "/users/"+id.String(), "", "", nil).Header().Get("ETag"); tag != etag(want) {
+		t.Errorf("ETag = %s, want %s", tag, etag(want))
+	}
+}
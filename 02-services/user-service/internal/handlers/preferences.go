@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/preferences"
+	"github.com/devsecops/user-service/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// preferenceQueryPrefix marks the ListUsers query parameters filtering by
+// preference, as in pref.notifications.email=false
+const preferenceQueryPrefix = "pref."
+
+// PreferencesSchema returns the JSON Schema preferences are validated against
+func (h *UserHandler) PreferencesSchema(c *gin.Context) {
+	c.Data(http.StatusOK, "application/schema+json", h.prefs.Schema())
+}
+
+// GetPreferences retrieves a user's preferences, with defaults for those
+// not set
+func (h *UserHandler) GetPreferences(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	idParam := c.Param("id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		_ = c.Error(errInvalidID())
+		return
+	}
+
+	profile, err := h.repo.GetProfile(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if notModified(c, profile.Version) {
+		return
+	}
+
+	h.withDefaults(c, profile)
+	setETag(c, profile.Version)
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    profile.Preferences,
+	})
+}
+
+// GetPreference retrieves a single preference, or its default if not set
+func (h *UserHandler) GetPreference(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	idParam := c.Param("id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		_ = c.Error(errInvalidID())
+		return
+	}
+
+	key := c.Param("key")
+	profile, err := h.repo.GetProfile(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	value, err := h.prefs.Get(profile.Preferences, key)
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to retrieve preference")
+		return
+	}
+
+	if notModified(c, profile.Version) {
+		return
+	}
+
+	setETag(c, profile.Version)
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    models.PreferenceResponse{Key: key, Value: value},
+	})
+}
+
+// SetPreference sets a single preference, validated against the schema
+func (h *UserHandler) SetPreference(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	idParam := c.Param("id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		_ = c.Error(errInvalidID())
+		return
+	}
+
+	var req models.SetPreferenceRequest
+	if err := bindStrictJSON(c, &req); err != nil {
+		bindError(c, err)
+		return
+	}
+
+	key := c.Param("key")
+	profile, err := h.writeProfile(c, id, map[string]interface{}{}, func(stored []byte) ([]byte, error) {
+		return h.prefs.Set(stored, key, req.Value)
+	})
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to update preference")
+		return
+	}
+
+	h.log.WithContext(ctx).Infof("Preference %s set: %s", key, id)
+	h.preferenceResponse(c, profile, key, "Preference updated successfully")
+}
+
+// DeletePreference removes a single preference, so its default applies again
+func (h *UserHandler) DeletePreference(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	idParam := c.Param("id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		_ = c.Error(errInvalidID())
+		return
+	}
+
+	key := c.Param("key")
+	profile, err := h.writeProfile(c, id, map[string]interface{}{}, func(stored []byte) ([]byte, error) {
+		return h.prefs.Unset(stored, key)
+	})
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to reset preference")
+		return
+	}
+
+	h.log.WithContext(ctx).Infof("Preference %s reset: %s", key, id)
+	h.preferenceResponse(c, profile, key, "Preference reset to its default")
+}
+
+// preferenceResponse writes the value of the preference key after a write
+func (h *UserHandler) preferenceResponse(c *gin.Context, profile *models.UserProfile, key, message string) {
+	value, err := h.prefs.Get(profile.Preferences, key)
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to retrieve preference")
+		return
+	}
+
+	setETag(c, profile.Version)
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    models.PreferenceResponse{Key: key, Value: value},
+		Message: message,
+	})
+}
+
+// writeProfile applies updates to the profile of user id, honouring
+// If-Match. If change is not nil, the preferences are replaced by what it
+// returns for the stored ones; as that depends on the version read, an
+// unconditional write is retried if another request changes the profile.
+func (h *UserHandler) writeProfile(c *gin.Context, id uuid.UUID, updates map[string]interface{}, change func([]byte) ([]byte, error)) (*models.UserProfile, error) {
+	ctx := c.Request.Context()
+
+	for attempt := 1; ; attempt++ {
+		profile, err := h.repo.GetProfile(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		version, err := h.ifMatchVersion(c, profile.Version)
+		if err != nil {
+			return nil, err
+		}
+		if len(updates) == 0 && change == nil {
+			if version != repository.AnyVersion && version != profile.Version {
+				return nil, errVersionMismatch()
+			}
+			return profile, nil
+		}
+
+		retry := false
+		if change != nil {
+			if version == repository.AnyVersion {
+				version, retry = profile.Version, attempt < maxPatchAttempts
+			}
+
+			changed, err := change(profile.Preferences)
+			if err != nil {
+				return nil, err
+			}
+			updates["preferences"] = models.Preferences(changed)
+		}
+
+		err = h.repo.UpdateProfile(ctx, id, version, updates)
+		if retry && errors.Is(err, apperrors.ErrPrecondition) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Fetch updated profile
+		return h.repo.GetProfile(ctx, id)
+	}
+}
+
+// withDefaults fills in the defaults of the preferences a profile does not
+// set. Stored preferences the schema cannot read are returned as they are.
+func (h *UserHandler) withDefaults(c *gin.Context, profile *models.UserProfile) {
+	merged, err := h.prefs.WithDefaults(profile.Preferences)
+	if err != nil {
+		h.log.WithContext(c.Request.Context()).Warnf("Invalid stored preferences of user %s: %v", profile.UserID, err)
+		return
+	}
+	profile.Preferences = merged
+}
+
+// preferenceFilters parses the pref.<key>=<value> query parameters of a user
+// listing. A value that is the preference's default also matches users who
+// never set it.
+func (h *UserHandler) preferenceFilters(c *gin.Context) ([]repository.PreferenceFilter, error) {
+	var filters []repository.PreferenceFilter
+	for param, values := range c.Request.URL.Query() {
+		key, ok := strings.CutPrefix(param, preferenceQueryPrefix)
+		if !ok {
+			continue
+		}
+		for _, text := range values {
+			value, isDefault, err := h.prefs.ParseValue(key, text)
+			if errors.Is(err, apperrors.ErrNotFound) {
+				return nil, apperrors.Validation(apperrors.CodeInvalidPreferences, "Invalid preference filter",
+					apperrors.FieldError{Field: param, Rule: apperrors.RuleUnknownField})
+			}
+			var appErr *apperrors.Error
+			if errors.As(err, &appErr) {
+				// Report problems under the query parameter, not the key
+				for i := range appErr.Fields {
+					appErr.Fields[i].Field = param
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, repository.PreferenceFilter{
+				Path:         preferences.Path(key),
+				Value:        json.RawMessage(value),
+				IncludeUnset: isDefault,
+			})
+		}
+	}
+	return filters, nil
+}
@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"encoding/json"
 	"math"
 	"net/http"
 	"strconv"
 
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/emailchange"
 	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/password"
+	"github.com/devsecops/user-service/internal/preferences"
 	"github.com/devsecops/user-service/internal/repository"
+	"github.com/devsecops/user-service/pkg/mergepatch"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -14,52 +20,48 @@ import (
 
 // UserHandler handles user-related HTTP requests
 type UserHandler struct {
-	repo *repository.UserRepository
-	log  *logrus.Logger
+	repo      repository.UserStore
+	prefs     *preferences.Registry
+	passwords *password.Manager
+	emails    *emailchange.Manager
+	log       *logrus.Logger
+
+	// requireIfMatch rejects writes without If-Match
+	requireIfMatch bool
 }
 
-// NewUserHandler creates a new user handler
-func NewUserHandler(repo *repository.UserRepository, log *logrus.Logger) *UserHandler {
+// NewUserHandler creates a new user handler validating preferences against
+// prefs and passwords with passwords and changing emails with emails. With
+// requireIfMatch, writes without If-Match fail with 428.
+func NewUserHandler(repo repository.UserStore, prefs *preferences.Registry, passwords *password.Manager, emails *emailchange.Manager, requireIfMatch bool, log *logrus.Logger) *UserHandler {
 	return &UserHandler{
-		repo: repo,
-		log:  log,
+		repo:           repo,
+		prefs:          prefs,
+		passwords:      passwords,
+		emails:         emails,
+		log:            log,
+		requireIfMatch: requireIfMatch,
 	}
 }
 
 // CreateUser creates a new user
 func (h *UserHandler) CreateUser(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	var req models.CreateUserRequest
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "VALIDATION_ERROR",
-				Message: "Invalid request data",
-				Details: []string{err.Error()},
-			},
-		})
+	if err := bindStrictJSON(c, &req); err != nil {
+		bindError(c, err)
 		return
 	}
 
-	// Check if email already exists
-	if h.repo.ExistsByEmail(req.Email) {
-		c.JSON(http.StatusConflict, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "EMAIL_EXISTS",
-				Message: "Email already registered",
-			},
-		})
+	if err := h.passwords.Validate(req.Password, req.Username, req.Email); err != nil {
+		_ = c.Error(err).SetMeta("Failed to check password")
 		return
 	}
-
-	// Check if username already exists
-	if h.repo.ExistsByUsername(req.Username) {
-		c.JSON(http.StatusConflict, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "USERNAME_EXISTS",
-				Message: "Username already taken",
-			},
-		})
+	passwordHash, err := h.passwords.Hash(req.Password)
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to create user")
 		return
 	}
 
@@ -71,20 +73,19 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		LastName:  req.LastName,
 		Phone:     req.Phone,
 		IsActive:  true,
+
+		PasswordHash: passwordHash,
 	}
 
-	if err := h.repo.Create(user, req.Password); err != nil {
-		h.log.Errorf("Failed to create user: %v", err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INTERNAL_ERROR",
-				Message: "Failed to create user",
-			},
-		})
+	// Uniqueness is enforced by the store, so concurrent sign-ups with the
+	// same email or username cannot both succeed
+	if err := h.repo.Create(ctx, user); err != nil {
+		_ = c.Error(err).SetMeta("Failed to create user")
 		return
 	}
 
-	h.log.Infof("User created: %s", user.ID)
+	h.log.WithContext(ctx).Infof("User created: %s", user.ID)
+	setETag(c, user.Version)
 	c.JSON(http.StatusCreated, models.SuccessResponse{
 		Success: true,
 		Data:    user.ToResponse(),
@@ -94,37 +95,37 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 
 // GetUser retrieves a user by ID
 func (h *UserHandler) GetUser(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	idParam := c.Param("id")
 	id, err := uuid.Parse(idParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INVALID_ID",
-				Message: "Invalid user ID format",
-			},
-		})
+		_ = c.Error(errInvalidID())
 		return
 	}
 
-	user, err := h.repo.FindByID(id)
+	user, err := h.repo.FindByID(ctx, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "USER_NOT_FOUND",
-				Message: "User not found",
-			},
-		})
+		_ = c.Error(err)
 		return
 	}
 
+	if notModified(c, user.Version) {
+		return
+	}
+
+	setETag(c, user.Version)
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
 		Data:    user.ToResponse(),
 	})
 }
 
-// ListUsers retrieves all users with pagination
+// ListUsers retrieves all users with pagination, optionally only those with
+// the preference values given as pref.<key>=<value> query parameters
 func (h *UserHandler) ListUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 
@@ -135,15 +136,15 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 		limit = 10
 	}
 
-	users, total, err := h.repo.List(page, limit)
+	filters, err := h.preferenceFilters(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	users, total, err := h.repo.List(ctx, page, limit, filters...)
 	if err != nil {
-		h.log.Errorf("Failed to list users: %v", err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INTERNAL_ERROR",
-				Message: "Failed to retrieve users",
-			},
-		})
+		_ = c.Error(err).SetMeta("Failed to retrieve users")
 		return
 	}
 
@@ -169,39 +170,31 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 
 // UpdateUser updates a user
 func (h *UserHandler) UpdateUser(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	idParam := c.Param("id")
 	id, err := uuid.Parse(idParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INVALID_ID",
-				Message: "Invalid user ID format",
-			},
-		})
+		_ = c.Error(errInvalidID())
 		return
 	}
 
 	var req models.UpdateUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "VALIDATION_ERROR",
-				Message: "Invalid request data",
-				Details: []string{err.Error()},
-			},
-		})
+	if err := bindStrictJSON(c, &req); err != nil {
+		bindError(c, err)
 		return
 	}
 
 	// Check if user exists
-	user, err := h.repo.FindByID(id)
+	user, err := h.repo.FindByID(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	version, err := h.ifMatchVersion(c, user.Version)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "USER_NOT_FOUND",
-				Message: "User not found",
-			},
-		})
+		_ = c.Error(err)
 		return
 	}
 
@@ -220,21 +213,77 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		updates["avatar_url"] = req.AvatarURL
 	}
 
-	if err := h.repo.Update(id, updates); err != nil {
-		h.log.Errorf("Failed to update user: %v", err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INTERNAL_ERROR",
-				Message: "Failed to update user",
-			},
-		})
+	if err := h.repo.Update(ctx, id, version, updates); err != nil {
+		_ = c.Error(err).SetMeta("Failed to update user")
 		return
 	}
 
 	// Fetch updated user
-	user, _ = h.repo.FindByID(id)
+	user, err = h.repo.FindByID(ctx, id)
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to update user")
+		return
+	}
 
-	h.log.Infof("User updated: %s", id)
+	h.log.WithContext(ctx).Infof("User updated: %s", id)
+	setETag(c, user.Version)
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    user.ToResponse(),
+		Message: "User updated successfully",
+	})
+}
+
+// PatchUser applies a JSON merge patch to a user
+func (h *UserHandler) PatchUser(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	idParam := c.Param("id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		_ = c.Error(errInvalidID())
+		return
+	}
+
+	var req models.PatchUserRequest
+	updates, _, err := bindMergePatch(c, &req)
+	if err != nil {
+		bindError(c, err)
+		return
+	}
+
+	// Check if user exists
+	user, err := h.repo.FindByID(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	version, err := h.ifMatchVersion(c, user.Version)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if len(updates) > 0 {
+		if err := h.repo.Update(ctx, id, version, updates); err != nil {
+			_ = c.Error(err).SetMeta("Failed to update user")
+			return
+		}
+
+		// Fetch updated user
+		user, err = h.repo.FindByID(ctx, id)
+		if err != nil {
+			_ = c.Error(err).SetMeta("Failed to update user")
+			return
+		}
+	} else if version != repository.AnyVersion && version != user.Version {
+		_ = c.Error(errVersionMismatch())
+		return
+	}
+
+	h.log.WithContext(ctx).Infof("User patched: %s", id)
+	setETag(c, user.Version)
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
 		Data:    user.ToResponse(),
@@ -244,42 +293,34 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 
 // DeleteUser soft deletes a user
 func (h *UserHandler) DeleteUser(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	idParam := c.Param("id")
 	id, err := uuid.Parse(idParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INVALID_ID",
-				Message: "Invalid user ID format",
-			},
-		})
+		_ = c.Error(errInvalidID())
 		return
 	}
 
 	// Check if user exists
-	_, err = h.repo.FindByID(id)
+	user, err := h.repo.FindByID(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	version, err := h.ifMatchVersion(c, user.Version)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "USER_NOT_FOUND",
-				Message: "User not found",
-			},
-		})
+		_ = c.Error(err)
 		return
 	}
 
-	if err := h.repo.Delete(id); err != nil {
-		h.log.Errorf("Failed to delete user: %v", err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INTERNAL_ERROR",
-				Message: "Failed to delete user",
-			},
-		})
+	if err := h.repo.Delete(ctx, id, version); err != nil {
+		_ = c.Error(err).SetMeta("Failed to delete user")
 		return
 	}
 
-	h.log.Infof("User deleted: %s", id)
+	h.log.WithContext(ctx).Infof("User deleted: %s", id)
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
 		Message: "User deleted successfully",
@@ -288,29 +329,27 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 
 // GetProfile retrieves user profile
 func (h *UserHandler) GetProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	idParam := c.Param("id")
 	id, err := uuid.Parse(idParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INVALID_ID",
-				Message: "Invalid user ID format",
-			},
-		})
+		_ = c.Error(errInvalidID())
 		return
 	}
 
-	profile, err := h.repo.GetProfile(id)
+	profile, err := h.repo.GetProfile(ctx, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "PROFILE_NOT_FOUND",
-				Message: "Profile not found",
-			},
-		})
+		_ = c.Error(err)
+		return
+	}
+
+	if notModified(c, profile.Version) {
 		return
 	}
 
+	h.withDefaults(c, profile)
+	setETag(c, profile.Version)
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
 		Data:    profile,
@@ -319,27 +358,45 @@ func (h *UserHandler) GetProfile(c *gin.Context) {
 
 // UpdateProfile updates user profile
 func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	idParam := c.Param("id")
 	id, err := uuid.Parse(idParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INVALID_ID",
-				Message: "Invalid user ID format",
-			},
-		})
+		_ = c.Error(errInvalidID())
 		return
 	}
 
 	var req models.UpdateProfileRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "VALIDATION_ERROR",
-				Message: "Invalid request data",
-				Details: []string{err.Error()},
-			},
-		})
+	if err := bindStrictJSON(c, &req); err != nil {
+		bindError(c, err)
+		return
+	}
+
+	// Preferences replace the stored ones, so they must be valid on their own
+	var prefs []byte
+	if len(req.Preferences) > 0 && !mergepatch.IsNull(req.Preferences) {
+		prefs = req.Preferences
+		var legacy string
+		if json.Unmarshal(req.Preferences, &legacy) == nil {
+			prefs = []byte(legacy)
+		}
+		if err := h.prefs.Validate(prefs); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+
+	// Check if profile exists
+	profile, err := h.repo.GetProfile(ctx, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	version, err := h.ifMatchVersion(c, profile.Version)
+	if err != nil {
+		_ = c.Error(err)
 		return
 	}
 
@@ -363,28 +420,90 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 	if req.Language != "" {
 		updates["language"] = req.Language
 	}
-	if req.Preferences != "" {
-		updates["preferences"] = req.Preferences
+	if prefs != nil {
+		updates["preferences"] = models.Preferences(prefs)
 	}
 
-	if err := h.repo.UpdateProfile(id, updates); err != nil {
-		h.log.Errorf("Failed to update profile: %v", err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Code:    "INTERNAL_ERROR",
-				Message: "Failed to update profile",
-			},
-		})
+	if err := h.repo.UpdateProfile(ctx, id, version, updates); err != nil {
+		_ = c.Error(err).SetMeta("Failed to update profile")
 		return
 	}
 
 	// Fetch updated profile
-	profile, _ := h.repo.GetProfile(id)
+	profile, err = h.repo.GetProfile(ctx, id)
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to update profile")
+		return
+	}
+
+	h.log.WithContext(ctx).Infof("Profile updated: %s", id)
+	h.withDefaults(c, profile)
+	setETag(c, profile.Version)
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    profile,
+		Message: "Profile updated successfully",
+	})
+}
+
+// PatchProfile applies a JSON merge patch to a user profile. Preferences in
+// the patch are merged into the stored preferences, key by key.
+func (h *UserHandler) PatchProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	idParam := c.Param("id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		_ = c.Error(errInvalidID())
+		return
+	}
+
+	var req models.PatchProfileRequest
+	updates, merges, err := bindMergePatch(c, &req)
+	if err != nil {
+		bindError(c, err)
+		return
+	}
+	var change func([]byte) ([]byte, error)
+	if patch, ok := merges["preferences"]; ok {
+		if !mergepatch.IsNull(patch) {
+			if _, err := mergepatch.Object(patch); err != nil {
+				_ = c.Error(apperrors.Validation(apperrors.CodeValidation, "Invalid request data",
+					apperrors.FieldError{Field: "preferences", Rule: apperrors.RuleType, Param: "object"}))
+				return
+			}
+		}
+		change = func(stored []byte) ([]byte, error) {
+			if mergepatch.IsNull(patch) {
+				return []byte("{}"), nil
+			}
+			merged, err := mergepatch.Apply(stored, patch)
+			if err != nil {
+				return nil, err
+			}
+			if err := h.prefs.Validate(merged); err != nil {
+				return nil, err
+			}
+			return merged, nil
+		}
+	}
+
+	profile, err := h.writeProfile(c, id, updates, change)
+	if err != nil {
+		_ = c.Error(err).SetMeta("Failed to update profile")
+		return
+	}
 
-	h.log.Infof("Profile updated: %s", id)
+	h.log.WithContext(ctx).Infof("Profile patched: %s", id)
+	h.withDefaults(c, profile)
+	setETag(c, profile.Version)
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
 		Data:    profile,
 		Message: "Profile updated successfully",
 	})
 }
+
+func errInvalidID() error {
+	return apperrors.Validation(apperrors.CodeInvalidID, "Invalid user ID format")
+}
@@ -0,0 +1,51 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// Pinger is implemented by clients that can verify their connection
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// DatabaseCheck pings the database connection pool
+func DatabaseCheck(db *sql.DB) CheckFunc {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// PingCheck pings a client such as Redis. A nil client always fails.
+func PingCheck(client Pinger) CheckFunc {
+	return func(ctx context.Context) error {
+		if client == nil {
+			return fmt.Errorf("not connected")
+		}
+		return client.Ping(ctx)
+	}
+}
+
+// HTTPCheck requires url to answer a GET with a 2xx status
+func HTTPCheck(client *http.Client, url string) CheckFunc {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
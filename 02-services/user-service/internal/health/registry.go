@@ -0,0 +1,227 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check statuses
+const (
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+	StatusDraining  = "draining"
+)
+
+// ErrStarting is reported until startup has completed
+var ErrStarting = errors.New("startup in progress")
+
+// StartupCheckName names the result of the startup gate in reports
+const StartupCheckName = "startup"
+
+// CheckFunc reports a dependency as healthy by returning nil
+type CheckFunc func(ctx context.Context) error
+
+// Option configures a registered check
+type Option func(*check)
+
+// Critical marks a check whose failure makes the service not ready.
+// Non-critical failures only degrade the reported status.
+func Critical() Option {
+	return func(c *check) {
+		c.critical = true
+	}
+}
+
+// Timeout overrides the registry's default timeout for a check
+func Timeout(timeout time.Duration) Option {
+	return func(c *check) {
+		c.timeout = timeout
+	}
+}
+
+// Result is the outcome of the most recent run of a check
+type Result struct {
+	Name        string
+	Status      string
+	Critical    bool
+	Latency     time.Duration
+	Error       string
+	LastError   string
+	LastSuccess time.Time
+	CheckedAt   time.Time
+}
+
+// Report aggregates the results of all checks
+type Report struct {
+	Status  string
+	Ready   bool
+	Results []Result
+}
+
+// Registry runs registered checks concurrently and caches their results
+type Registry struct {
+	timeout  time.Duration
+	cacheTTL time.Duration
+
+	mu     sync.RWMutex
+	checks []*check
+
+	startOnce sync.Once
+	started   chan struct{}
+	draining  atomic.Bool
+}
+
+type check struct {
+	name     string
+	fn       CheckFunc
+	critical bool
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	result Result
+}
+
+// NewRegistry creates a registry. Each check is bounded by timeout unless
+// overridden, and its result is reused for cacheTTL so probes can't overload
+// dependencies.
+func NewRegistry(timeout, cacheTTL time.Duration) *Registry {
+	return &Registry{
+		timeout:  timeout,
+		cacheTTL: cacheTTL,
+		started:  make(chan struct{}),
+	}
+}
+
+// Register adds a named check
+func (r *Registry) Register(name string, fn CheckFunc, opts ...Option) {
+	c := &check{name: name, fn: fn, timeout: r.timeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	r.mu.Lock()
+	r.checks = append(r.checks, c)
+	sort.Slice(r.checks, func(i, j int) bool { return r.checks[i].name < r.checks[j].name })
+	r.mu.Unlock()
+}
+
+// MarkStarted records that startup work such as migrations has finished
+func (r *Registry) MarkStarted() {
+	r.startOnce.Do(func() { close(r.started) })
+}
+
+// Started reports whether MarkStarted has been called
+func (r *Registry) Started() bool {
+	select {
+	case <-r.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// MarkDraining makes readiness fail so load balancers stop sending new
+// requests while the service shuts down
+func (r *Registry) MarkDraining() {
+	r.draining.Store(true)
+}
+
+// Run executes every check concurrently (or reuses its cached result) and
+// aggregates the results. The service is ready when it has started, every
+// critical check passes and it is not draining. Like draining, the startup
+// gate is read on every run rather than cached, so readiness follows
+// MarkStarted at once; it is reported as a critical check named
+// StartupCheckName.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := make([]*check, len(r.checks))
+	copy(checks, r.checks)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, r.cacheTTL)
+		}(i, c)
+	}
+	wg.Wait()
+
+	results = append(results, r.startupResult())
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	report := Report{Status: StatusHealthy, Ready: true, Results: results}
+	if r.draining.Load() {
+		report.Status = StatusDraining
+		report.Ready = false
+		return report
+	}
+
+	for _, result := range results {
+		if result.Status == StatusHealthy {
+			continue
+		}
+		if result.Critical {
+			report.Status = StatusUnhealthy
+			report.Ready = false
+		} else if report.Status == StatusHealthy {
+			report.Status = StatusDegraded
+		}
+	}
+
+	return report
+}
+
+// startupResult reports whether MarkStarted has been called
+func (r *Registry) startupResult() Result {
+	result := Result{Name: StartupCheckName, Status: StatusHealthy, Critical: true, CheckedAt: time.Now()}
+	if !r.Started() {
+		result.Status = StatusUnhealthy
+		result.Error = ErrStarting.Error()
+		result.LastError = result.Error
+		return result
+	}
+	result.LastSuccess = result.CheckedAt
+	return result
+}
+
+// run executes the check unless a fresh cached result exists. The mutex also
+// ensures concurrent probes share a single in-flight execution.
+func (c *check) run(ctx context.Context, cacheTTL time.Duration) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.result.CheckedAt.IsZero() && time.Since(c.result.CheckedAt) < cacheTTL {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.fn(ctx)
+
+	c.result.Name = c.name
+	c.result.Critical = c.critical
+	c.result.Latency = time.Since(start)
+	c.result.CheckedAt = time.Now()
+
+	if err != nil {
+		c.result.Status = StatusUnhealthy
+		c.result.Error = err.Error()
+		c.result.LastError = err.Error()
+	} else {
+		c.result.Status = StatusHealthy
+		c.result.Error = ""
+		c.result.LastSuccess = c.result.CheckedAt
+	}
+
+	return c.result
+}
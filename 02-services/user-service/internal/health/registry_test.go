@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistryStartupIsNotCached(t *testing.T) {
+	// A cache far longer than the test: only the startup gate may change
+	r := NewRegistry(time.Second, time.Hour)
+	r.Register("database", func(context.Context) error { return nil }, Critical())
+
+	report := r.Run(context.Background())
+	if report.Ready || report.Status != StatusUnhealthy {
+		t.Fatalf("before MarkStarted: ready %v, status %s, want not ready and unhealthy", report.Ready, report.Status)
+	}
+	startup := findResult(t, report, StartupCheckName)
+	if !startup.Critical || startup.Error != ErrStarting.Error() {
+		t.Errorf("startup result = %+v, want a critical %q failure", startup, ErrStarting)
+	}
+
+	r.MarkStarted()
+	report = r.Run(context.Background())
+	if !report.Ready || report.Status != StatusHealthy {
+		t.Fatalf("after MarkStarted: ready %v, status %s, want ready and healthy", report.Ready, report.Status)
+	}
+	if startup := findResult(t, report, StartupCheckName); startup.Status != StatusHealthy || startup.Error != "" {
+		t.Errorf("startup result = %+v, want healthy", startup)
+	}
+
+	if names := resultNames(report); names != "database,startup" {
+		t.Errorf("results = %s, want them sorted by name", names)
+	}
+}
+
+func TestRegistryRun(t *testing.T) {
+	errDown := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		critical   error
+		optional   error
+		draining   bool
+		wantReady  bool
+		wantStatus string
+	}{
+		{name: "all healthy", wantReady: true, wantStatus: StatusHealthy},
+		{name: "optional failure", optional: errDown, wantReady: true, wantStatus: StatusDegraded},
+		{name: "critical failure", critical: errDown, optional: errDown, wantStatus: StatusUnhealthy},
+		{name: "draining", draining: true, wantStatus: StatusDraining},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(time.Second, time.Hour)
+			r.Register("database", func(context.Context) error { return tt.critical }, Critical())
+			r.Register("redis", func(context.Context) error { return tt.optional })
+			r.MarkStarted()
+			if tt.draining {
+				r.MarkDraining()
+			}
+
+			report := r.Run(context.Background())
+			if report.Ready != tt.wantReady || report.Status != tt.wantStatus {
+				t.Errorf("ready %v, status %s, want %v, %s", report.Ready, report.Status, tt.wantReady, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func findResult(t *testing.T, report Report, name string) Result {
+	t.Helper()
+	for _, result := range report.Results {
+		if result.Name == name {
+			return result
+		}
+	}
+	t.Fatalf("no %s result in %+v", name, report.Results)
+	return Result{}
+}
+
+func resultNames(report Report) string {
+	var names string
+	for i, result := range report.Results {
+		if i > 0 {
+			names += ","
+		}
+		names += result.Name
+	}
+	return names
+}
@@ -0,0 +1,165 @@
+// Package i18n picks the language of error messages from the Accept-Language
+// header and translates error codes and field rules into it. Catalogs live
+// in locales/<language>.json; English is the default and the reference every
+// other catalog falls back to.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+	"golang.org/x/text/language"
+)
+
+// Default is the language used when the client accepts none of ours
+const Default = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog holds the messages of one language: by error code, and by field
+// rule as described in Fields
+type catalog struct {
+	Codes map[string]string `json:"codes"`
+	Rules map[string]string `json:"rules"`
+}
+
+var (
+	catalogs, languages = mustLoad()
+	matcher             = language.NewMatcher(tags(languages))
+)
+
+// Languages returns the supported languages, the default first
+func Languages() []string {
+	return append([]string(nil), languages...)
+}
+
+// Negotiate returns the supported language that best matches an
+// Accept-Language header, or Default
+func Negotiate(acceptLanguage string) string {
+	accepted, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(accepted) == 0 {
+		return Default
+	}
+	_, index, confidence := matcher.Match(accepted...)
+	if confidence == language.No {
+		return Default
+	}
+	return languages[index]
+}
+
+// Message returns the message of an error code in lang. English messages
+// are written where the error is reported and may say more than the
+// catalog, so fallback is kept for English and for untranslated codes.
+func Message(lang, code, fallback string) string {
+	if c, ok := catalogs[lang]; ok && lang != Default {
+		if message, ok := c.Codes[code]; ok {
+			return message
+		}
+	}
+	if fallback == "" {
+		return catalogs[Default].Codes[code]
+	}
+	return fallback
+}
+
+// FieldMessage describes a field error in lang. Rules are looked up as
+// "<rule>.<param>", then "<rule>", so a rule may have a message per
+// parameter such as type.string; errors about the whole body (an empty
+// field) first try the same keys prefixed with "body.". In the message,
+// {param} stands for the parameter.
+func FieldMessage(lang string, fieldErr apperrors.FieldError) string {
+	var keys []string
+	if fieldErr.Param != "" {
+		keys = append(keys, fieldErr.Rule+"."+fieldErr.Param)
+	}
+	keys = append(keys, fieldErr.Rule)
+	if fieldErr.Field == "" {
+		body := make([]string, len(keys))
+		for i, key := range keys {
+			body[i] = "body." + key
+		}
+		keys = append(body, keys...)
+	}
+	keys = append(keys, "invalid")
+
+	for _, key := range keys {
+		if message, ok := rule(lang, key); ok {
+			return strings.ReplaceAll(message, "{param}", fieldErr.Param)
+		}
+	}
+	return fieldErr.Rule
+}
+
+// Fields describes each field error in lang
+func Fields(lang string, fieldErrs []apperrors.FieldError) []models.FieldError {
+	if len(fieldErrs) == 0 {
+		return nil
+	}
+	fields := make([]models.FieldError, len(fieldErrs))
+	for i, fieldErr := range fieldErrs {
+		fields[i] = models.FieldError{
+			Field:   fieldErr.Field,
+			Rule:    fieldErr.Rule,
+			Message: FieldMessage(lang, fieldErr),
+		}
+	}
+	return fields
+}
+
+// rule finds the message of a rule key in the catalog of lang, then in the
+// default one
+func rule(lang, key string) (string, bool) {
+	for _, l := range []string{lang, Default} {
+		if c, ok := catalogs[l]; ok {
+			if message, ok := c.Rules[key]; ok {
+				return message, true
+			}
+		}
+	}
+	return "", false
+}
+
+// mustLoad reads the embedded catalogs, returning the languages with the
+// default first
+func mustLoad() (map[string]*catalog, []string) {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read locales: %v", err))
+	}
+
+	loaded := map[string]*catalog{}
+	langs := []string{Default}
+	for _, entry := range entries {
+		lang := strings.TrimSuffix(entry.Name(), ".json")
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("failed to read locale %s: %v", lang, err))
+		}
+		var c catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			panic(fmt.Sprintf("invalid locale %s: %v", lang, err))
+		}
+		loaded[lang] = &c
+		if lang != Default {
+			langs = append(langs, lang)
+		}
+	}
+	if _, ok := loaded[Default]; !ok {
+		panic("missing default locale " + Default)
+	}
+	return loaded, langs
+}
+
+func tags(langs []string) []language.Tag {
+	result := make([]language.Tag, len(langs))
+	for i, lang := range langs {
+		result[i] = language.MustParse(lang)
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package i18n
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+)
+
+// shipped are the languages the service is documented to speak, sorted
+var shipped = []string{"de", "en", "es", "fr"}
+
+var placeholder = regexp.MustCompile(`\{[a-z_]+\}`)
+
+func TestShippedLanguages(t *testing.T) {
+	got := Languages()
+	if got[0] != Default {
+		t.Errorf("Languages() = %v, want %s first", got, Default)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, shipped) {
+		t.Errorf("Languages() = %v, want %v", got, shipped)
+	}
+}
+
+func TestCatalogsCoverEveryCode(t *testing.T) {
+	codes := map[string]bool{}
+	for _, info := range apperrors.Catalog {
+		codes[info.Code] = true
+	}
+
+	for _, lang := range shipped {
+		c := catalogs[lang]
+		if c == nil {
+			t.Errorf("no catalog for %s", lang)
+			continue
+		}
+		for _, info := range apperrors.Catalog {
+			if c.Codes[info.Code] == "" {
+				t.Errorf("%s: no message for %s", lang, info.Code)
+			}
+		}
+		for code := range c.Codes {
+			if !codes[code] {
+				t.Errorf("%s: message for %s, which is not in apperrors.Catalog", lang, code)
+			}
+		}
+	}
+}
+
+func TestCatalogsCoverEveryRule(t *testing.T) {
+	reference := catalogs[Default].Rules
+	for _, rule := range []string{apperrors.RuleSyntax, apperrors.RuleType, apperrors.RuleUnknownField, apperrors.RuleNotNull, "invalid"} {
+		if reference[rule] == "" {
+			t.Errorf("%s: no message for rule %s", Default, rule)
+		}
+	}
+
+	for _, lang := range shipped {
+		rules := catalogs[lang].Rules
+		for key, message := range reference {
+			translated, ok := rules[key]
+			if !ok {
+				t.Errorf("%s: no message for rule %s", lang, key)
+				continue
+			}
+			want := placeholder.FindAllString(message, -1)
+			if got := placeholder.FindAllString(translated, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: rule %s has placeholders %v, want %v", lang, key, got, want)
+			}
+		}
+		for key := range rules {
+			if _, ok := reference[key]; !ok {
+				t.Errorf("%s: message for rule %s, which %s does not have", lang, key, Default)
+			}
+		}
+	}
+}
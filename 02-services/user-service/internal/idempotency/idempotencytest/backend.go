@@ -0,0 +1,123 @@
+// Package idempotencytest provides an in-memory idempotency.Backend for
+// exercising the idempotency store and middleware without Redis or Postgres
+package idempotencytest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/devsecops/user-service/internal/idempotency"
+)
+
+// Backend keeps records in memory until they expire. While an error is set
+// with SetUnavailable every operation fails with it, as a backend that
+// cannot be reached would.
+type Backend struct {
+	name string
+
+	mu      sync.Mutex
+	records map[string]entry
+	err     error
+}
+
+type entry struct {
+	rec     idempotency.Record
+	expires time.Time
+}
+
+// NewBackend creates an empty backend identified by name
+func NewBackend(name string) *Backend {
+	return &Backend{name: name, records: map[string]entry{}}
+}
+
+// SetUnavailable makes every operation fail with err, or succeed again if
+// err is nil
+func (b *Backend) SetUnavailable(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+}
+
+// Len returns the number of live records
+func (b *Backend) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for _, e := range b.records {
+		if time.Now().Before(e.expires) {
+			n++
+		}
+	}
+	return n
+}
+
+// Name implements idempotency.Backend
+func (b *Backend) Name() string {
+	return b.name
+}
+
+// Reserve implements idempotency.Backend
+func (b *Backend) Reserve(ctx context.Context, key string, rec idempotency.Record, ttl time.Duration) (*idempotency.Record, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	if existing := b.live(key); existing != nil {
+		return existing, nil
+	}
+	b.records[key] = entry{rec: rec, expires: time.Now().Add(ttl)}
+	return nil, nil
+}
+
+// Get implements idempotency.Backend
+func (b *Backend) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	return b.live(key), nil
+}
+
+// Complete implements idempotency.Backend
+func (b *Backend) Complete(ctx context.Context, key string, rec idempotency.Record, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	b.records[key] = entry{rec: rec, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release implements idempotency.Backend
+func (b *Backend) Release(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	delete(b.records, key)
+	return nil
+}
+
+func (b *Backend) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.err
+}
+
+// live returns a copy of the record under key unless it expired
+func (b *Backend) live(key string) *idempotency.Record {
+	e, ok := b.records[key]
+	if !ok || !time.Now().Before(e.expires) {
+		return nil
+	}
+	rec := e.rec
+	return &rec
+}
@@ -0,0 +1,174 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresBackend keeps records in the idempotency_keys table. Expired rows
+// are ignored, replaced by new reservations and deleted by Purge.
+type PostgresBackend struct {
+	db      *gorm.DB
+	timeout time.Duration
+}
+
+// NewPostgresBackend creates a Postgres backend whose operations are bounded
+// by timeout
+func NewPostgresBackend(db *gorm.DB, timeout time.Duration) *PostgresBackend {
+	return &PostgresBackend{db: db, timeout: timeout}
+}
+
+// Name implements Backend
+func (b *PostgresBackend) Name() string {
+	return "postgres"
+}
+
+// Reserve implements Backend
+func (b *PostgresBackend) Reserve(ctx context.Context, key string, rec Record, ttl time.Duration) (*Record, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	row, err := toRow(key, rec, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Insert, or take over an expired row with the same key
+	result := b.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"request_hash", "completed", "response_status", "response_headers", "response_body", "created_at", "expires_at",
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "idempotency_keys.expires_at <= ?", Vars: []interface{}{row.CreatedAt}},
+		}},
+	}).Create(row)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	existing, err := b.Get(ctx, key)
+	if err == nil && existing == nil {
+		err = fmt.Errorf("idempotency key %s changed concurrently", key)
+	}
+	return existing, err
+}
+
+// Get implements Backend
+func (b *PostgresBackend) Get(ctx context.Context, key string) (*Record, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	var row models.IdempotencyKey
+	err := b.db.WithContext(ctx).Where("key = ? AND expires_at > ?", key, time.Now()).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &Record{
+		RequestHash: row.RequestHash,
+		Completed:   row.Completed,
+		Status:      row.ResponseStatus,
+		Body:        row.ResponseBody,
+	}
+	if err := json.Unmarshal([]byte(row.ResponseHeaders), &rec.Header); err != nil {
+		return nil, fmt.Errorf("failed to decode idempotency response headers: %w", err)
+	}
+	return rec, nil
+}
+
+// Complete implements Backend
+func (b *PostgresBackend) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	row, err := toRow(key, rec, ttl)
+	if err != nil {
+		return err
+	}
+
+	return b.db.WithContext(ctx).Model(&models.IdempotencyKey{}).Where("key = ?", key).Updates(map[string]interface{}{
+		"completed":        row.Completed,
+		"response_status":  row.ResponseStatus,
+		"response_headers": row.ResponseHeaders,
+		"response_body":    row.ResponseBody,
+		"expires_at":       row.ExpiresAt,
+	}).Error
+}
+
+// Release implements Backend
+func (b *PostgresBackend) Release(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	return b.db.WithContext(ctx).Where("key = ?", key).Delete(&models.IdempotencyKey{}).Error
+}
+
+// Purge deletes expired records
+func (b *PostgresBackend) Purge(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	result := b.db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&models.IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
+
+// RunPurge calls Purge every interval until ctx is cancelled
+func (b *PostgresBackend) RunPurge(ctx context.Context, interval time.Duration, log *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deleted, err := b.Purge(ctx)
+		if err != nil {
+			log.Warnf("Failed to purge expired idempotency keys: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Debugf("Purged %d expired idempotency keys", deleted)
+		}
+	}
+}
+
+func toRow(key string, rec Record, ttl time.Duration) (*models.IdempotencyKey, error) {
+	header := rec.Header
+	if header == nil {
+		header = map[string][]string{}
+	}
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode idempotency response headers: %w", err)
+	}
+
+	now := time.Now()
+	return &models.IdempotencyKey{
+		Key:             key,
+		RequestHash:     rec.RequestHash,
+		Completed:       rec.Completed,
+		ResponseStatus:  rec.Status,
+		ResponseHeaders: string(headers),
+		ResponseBody:    rec.Body,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(ttl),
+	}, nil
+}
@@ -0,0 +1,101 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const redisKeyPrefix = "idempotency:"
+
+// RedisClient is the subset of the Redis client used by RedisBackend
+type RedisClient interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// RedisBackend keeps records in Redis, expiring them with key TTLs
+type RedisBackend struct {
+	client  RedisClient
+	timeout time.Duration
+}
+
+// NewRedisBackend creates a Redis backend whose operations are bounded by
+// timeout, so a slow Redis falls back to the next backend quickly
+func NewRedisBackend(client RedisClient, timeout time.Duration) *RedisBackend {
+	return &RedisBackend{client: client, timeout: timeout}
+}
+
+// Name implements Backend
+func (b *RedisBackend) Name() string {
+	return "redis"
+}
+
+// Reserve implements Backend
+func (b *RedisBackend) Reserve(ctx context.Context, key string, rec Record, ttl time.Duration) (*Record, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	// The existing key may expire between SETNX and GET, so try twice
+	for attempt := 0; attempt < 2; attempt++ {
+		stored, err := b.client.SetNX(ctx, redisKeyPrefix+key, data, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if stored {
+			return nil, nil
+		}
+
+		existing, err := b.Get(ctx, key)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	return nil, fmt.Errorf("idempotency key %s changed concurrently", key)
+}
+
+// Get implements Backend
+func (b *RedisBackend) Get(ctx context.Context, key string) (*Record, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	data, err := b.client.Get(ctx, redisKeyPrefix+key)
+	if err != nil || data == "" {
+		return nil, err
+	}
+
+	var rec Record
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return nil, fmt.Errorf("failed to decode idempotency record: %w", err)
+	}
+	return &rec, nil
+}
+
+// Complete implements Backend
+func (b *RedisBackend) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+	return b.client.Set(ctx, redisKeyPrefix+key, data, ttl)
+}
+
+// Release implements Backend
+func (b *RedisBackend) Release(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	return b.client.Delete(ctx, redisKeyPrefix+key)
+}
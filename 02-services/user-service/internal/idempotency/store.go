@@ -0,0 +1,138 @@
+// Package idempotency remembers the responses to requests sent with an
+// Idempotency-Key header so that retries get the original response instead
+// of repeating the operation
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Record is the state of a key: pending while the first request is being
+// handled, then completed with its response
+type Record struct {
+	RequestHash string      `json:"request_hash"`
+	Completed   bool        `json:"completed"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Backend stores records by key until they expire
+type Backend interface {
+	// Name identifies the backend in logs
+	Name() string
+	// Reserve stores rec under key for ttl unless the key exists, in which
+	// case the existing record is returned instead
+	Reserve(ctx context.Context, key string, rec Record, ttl time.Duration) (*Record, error)
+	// Get returns the record stored under key, or nil
+	Get(ctx context.Context, key string) (*Record, error)
+	// Complete replaces the record under key, keeping it for ttl
+	Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	// Release deletes the record under key
+	Release(ctx context.Context, key string) error
+}
+
+// Store reserves keys in the first available backend, so Redis can be
+// backed by Postgres while it is unreachable
+type Store struct {
+	backends []Backend
+	log      *logrus.Logger
+}
+
+// NewStore creates a store using backends in order of preference
+func NewStore(log *logrus.Logger, backends ...Backend) *Store {
+	return &Store{backends: backends, log: log}
+}
+
+// Claim is the outcome of Reserve. Existing is nil when the caller owns the
+// key and must Complete or Release it; otherwise it is the record of an
+// earlier request with the same key.
+type Claim struct {
+	Existing *Record
+
+	backend Backend
+	key     string
+}
+
+// Reserve claims key for a request whose fingerprint is requestHash. A
+// pending claim expires after lockTTL so a crashed request does not hold
+// its key until the record would expire.
+func (s *Store) Reserve(ctx context.Context, key, requestHash string, lockTTL time.Duration) (*Claim, error) {
+	pending := Record{RequestHash: requestHash}
+
+	var errs []error
+	for i, backend := range s.backends {
+		existing, err := backend.Reserve(ctx, key, pending, lockTTL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			s.log.WithContext(ctx).Warnf("Idempotency backend %s unavailable, trying next: %v", backend.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+			continue
+		}
+		if existing != nil {
+			return &Claim{Existing: existing, backend: backend, key: key}, nil
+		}
+
+		// Keys claimed while this backend was unavailable live in a later one
+		for _, fallback := range s.backends[i+1:] {
+			if earlier, err := fallback.Get(ctx, key); err == nil && earlier != nil {
+				_ = backend.Release(ctx, key)
+				return &Claim{Existing: earlier, backend: fallback, key: key}, nil
+			}
+		}
+
+		return &Claim{backend: backend, key: key}, nil
+	}
+
+	return nil, fmt.Errorf("no idempotency backend available: %w", errors.Join(errs...))
+}
+
+// Wait polls the existing record until it is completed, released or ctx is
+// done. It returns the completed record, or nil if the key was released.
+func (c *Claim) Wait(ctx context.Context, interval time.Duration) (*Record, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		rec, err := c.backend.Get(ctx, c.key)
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil || rec.Completed {
+			return rec, nil
+		}
+	}
+}
+
+// Complete stores the response of the request owning the key for ttl
+func (c *Claim) Complete(ctx context.Context, rec Record, ttl time.Duration) error {
+	rec.Completed = true
+	return c.backend.Complete(ctx, c.key, rec, ttl)
+}
+
+// Release gives up the key so the request can be retried
+func (c *Claim) Release(ctx context.Context) error {
+	return c.backend.Release(ctx, c.key)
+}
+
+// withTimeout bounds ctx by d, or only makes it cancellable if d is zero
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
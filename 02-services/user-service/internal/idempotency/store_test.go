@@ -0,0 +1,137 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/devsecops/user-service/internal/idempotency"
+	"github.com/devsecops/user-service/internal/idempotency/idempotencytest"
+	"github.com/sirupsen/logrus"
+)
+
+var errDown = errors.New("connection refused")
+
+func newTestStore(backends ...idempotency.Backend) *idempotency.Store {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return idempotency.NewStore(log, backends...)
+}
+
+func TestStoreReserve(t *testing.T) {
+	ctx := context.Background()
+	primary := idempotencytest.NewBackend("redis")
+	store := newTestStore(primary)
+
+	claim, err := store.Reserve(ctx, "key", "hash", time.Minute)
+	if err != nil || claim.Existing != nil {
+		t.Fatalf("first Reserve = %+v, %v, want an owned claim", claim, err)
+	}
+
+	again, err := store.Reserve(ctx, "key", "other", time.Minute)
+	if err != nil || again.Existing == nil || again.Existing.RequestHash != "hash" || again.Existing.Completed {
+		t.Fatalf("second Reserve = %+v, %v, want the pending record", again, err)
+	}
+
+	if err := claim.Complete(ctx, idempotency.Record{RequestHash: "hash", Status: http.StatusCreated}, time.Hour); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	again, err = store.Reserve(ctx, "key", "hash", time.Minute)
+	if err != nil || again.Existing == nil || !again.Existing.Completed || again.Existing.Status != http.StatusCreated {
+		t.Fatalf("Reserve after Complete = %+v, %v, want the completed record", again, err)
+	}
+
+	// A released key can be claimed again
+	other, err := store.Reserve(ctx, "other", "hash", time.Minute)
+	if err != nil || other.Existing != nil {
+		t.Fatalf("Reserve = %+v, %v", other, err)
+	}
+	if err := other.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if other, err = store.Reserve(ctx, "other", "hash", time.Minute); err != nil || other.Existing != nil {
+		t.Fatalf("Reserve after Release = %+v, %v, want an owned claim", other, err)
+	}
+
+	// A pending claim expires after its lock TTL
+	if _, err := store.Reserve(ctx, "crashed", "hash", 10*time.Millisecond); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if claim, err := store.Reserve(ctx, "crashed", "hash", time.Minute); err != nil || claim.Existing != nil {
+		t.Fatalf("Reserve after the lock expired = %+v, %v, want an owned claim", claim, err)
+	}
+}
+
+func TestStoreReserveFallback(t *testing.T) {
+	ctx := context.Background()
+	primary := idempotencytest.NewBackend("redis")
+	fallback := idempotencytest.NewBackend("postgres")
+	store := newTestStore(primary, fallback)
+
+	// While the primary is down, the key is claimed in the fallback
+	primary.SetUnavailable(errDown)
+	claim, err := store.Reserve(ctx, "key", "hash", time.Minute)
+	if err != nil || claim.Existing != nil {
+		t.Fatalf("Reserve with the primary down = %+v, %v, want an owned claim", claim, err)
+	}
+	if fallback.Len() != 1 {
+		t.Fatalf("fallback holds %d records, want 1", fallback.Len())
+	}
+
+	// Once it is back, the primary reserves the key too but the claim in
+	// the fallback wins, and the primary's reservation is released
+	primary.SetUnavailable(nil)
+	retry, err := store.Reserve(ctx, "key", "hash", time.Minute)
+	if err != nil || retry.Existing == nil || retry.Existing.Completed {
+		t.Fatalf("Reserve with the primary back = %+v, %v, want the pending fallback claim", retry, err)
+	}
+	if primary.Len() != 0 {
+		t.Errorf("primary holds %d records, want the reservation released", primary.Len())
+	}
+
+	// Waiting on the retry follows the fallback claim to completion
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		done <- claim.Complete(ctx, idempotency.Record{RequestHash: "hash", Status: http.StatusCreated, Body: []byte("{}")}, time.Hour)
+	}()
+	rec, err := retry.Wait(ctx, 5*time.Millisecond)
+	if err != nil || rec == nil || !rec.Completed || rec.Status != http.StatusCreated {
+		t.Fatalf("Wait = %+v, %v, want the completed record", rec, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	// The fallback is also consulted when the primary has no record at all
+	again, err := store.Reserve(ctx, "key", "hash", time.Minute)
+	if err != nil || again.Existing == nil || !again.Existing.Completed {
+		t.Fatalf("Reserve after completion = %+v, %v, want the completed record", again, err)
+	}
+	if primary.Len() != 0 {
+		t.Errorf("primary holds %d records, want none", primary.Len())
+	}
+}
+
+func TestStoreReserveUnavailable(t *testing.T) {
+	primary := idempotencytest.NewBackend("redis")
+	fallback := idempotencytest.NewBackend("postgres")
+	primary.SetUnavailable(errDown)
+	fallback.SetUnavailable(errDown)
+
+	_, err := newTestStore(primary, fallback).Reserve(context.Background(), "key", "hash", time.Minute)
+	if !errors.Is(err, errDown) {
+		t.Fatalf("Reserve = %v, want an error wrapping %v", err, errDown)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	primary.SetUnavailable(nil)
+	if _, err := newTestStore(primary, fallback).Reserve(ctx, "key", "hash", time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Reserve with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
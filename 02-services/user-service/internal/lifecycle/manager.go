@@ -0,0 +1,169 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Component stop outcomes reported in the shutdown summary
+const (
+	StatusStopped  = "stopped"
+	StatusFailed   = "failed"
+	StatusTimedOut = "timed_out"
+)
+
+// StopFunc stops a component, returning once it has finished or ctx expires
+type StopFunc func(ctx context.Context) error
+
+// Drainer is told to stop accepting new traffic, e.g. by failing readiness
+type Drainer interface {
+	MarkDraining()
+}
+
+// Result records how a component stopped
+type Result struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	Err      error
+}
+
+// Manager coordinates graceful shutdown: it flips readiness, waits for load
+// balancers to stop routing traffic, then stops components in the reverse
+// order they were registered
+type Manager struct {
+	log        *logrus.Logger
+	drainer    Drainer
+	drainDelay time.Duration
+
+	components []component
+}
+
+type component struct {
+	name    string
+	timeout time.Duration
+	stop    StopFunc
+}
+
+// NewManager creates a lifecycle manager. drainDelay is how long to keep
+// serving after readiness starts failing.
+func NewManager(log *logrus.Logger, drainer Drainer, drainDelay time.Duration) *Manager {
+	return &Manager{
+		log:        log,
+		drainer:    drainer,
+		drainDelay: drainDelay,
+	}
+}
+
+// Register adds a component in dependency order: components registered later
+// may depend on earlier ones and are therefore stopped first
+func (m *Manager) Register(name string, timeout time.Duration, stop StopFunc) {
+	m.components = append(m.components, component{name: name, timeout: timeout, stop: stop})
+}
+
+// Go runs a background worker until shutdown. The worker's context is
+// cancelled when it is stopped and run must return promptly afterwards.
+func (m *Manager) Go(name string, timeout time.Duration, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+
+	m.Register(name, timeout, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// Shutdown drains traffic and stops every component, bounded overall by ctx.
+// Each component is given at most its own timeout; a component that fails or
+// times out does not prevent the remaining ones from stopping.
+func (m *Manager) Shutdown(ctx context.Context) []Result {
+	if m.drainer != nil {
+		m.drainer.MarkDraining()
+		m.log.Infof("Readiness set to failing, draining for %s", m.drainDelay)
+
+		select {
+		case <-time.After(m.drainDelay):
+		case <-ctx.Done():
+			m.log.Warn("Shutdown deadline reached while draining")
+		}
+	}
+
+	results := make([]Result, 0, len(m.components))
+	for i := len(m.components) - 1; i >= 0; i-- {
+		results = append(results, m.stop(ctx, m.components[i]))
+	}
+
+	m.logSummary(results)
+	return results
+}
+
+func (m *Manager) stop(ctx context.Context, c component) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.stop(ctx)
+	}()
+
+	result := Result{Name: c.name}
+	select {
+	case err := <-done:
+		result.Err = err
+		switch {
+		case err == nil:
+			result.Status = StatusStopped
+		case errors.Is(err, context.DeadlineExceeded):
+			result.Status = StatusTimedOut
+		default:
+			result.Status = StatusFailed
+		}
+	case <-ctx.Done():
+		result.Status = StatusTimedOut
+		result.Err = ctx.Err()
+	}
+	result.Duration = time.Since(start)
+
+	entry := m.log.WithFields(logrus.Fields{
+		"component": result.Name,
+		"status":    result.Status,
+		"duration":  result.Duration,
+	})
+	if result.Err != nil {
+		entry.Warnf("Component did not stop cleanly: %v", result.Err)
+	} else {
+		entry.Info("Component stopped")
+	}
+
+	return result
+}
+
+func (m *Manager) logSummary(results []Result) {
+	summary := make(logrus.Fields, len(results))
+	clean := true
+	for _, result := range results {
+		summary[result.Name] = result.Status
+		if result.Status != StatusStopped {
+			clean = false
+		}
+	}
+
+	if clean {
+		m.log.WithFields(summary).Info("Shutdown completed cleanly")
+	} else {
+		m.log.WithFields(summary).Warn("Shutdown completed with errors")
+	}
+}
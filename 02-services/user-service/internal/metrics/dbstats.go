@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DBStatsCollector exposes sql.DB connection pool statistics
+type DBStatsCollector struct {
+	db *sql.DB
+
+	maxOpen      *prometheus.Desc
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+	closed       *prometheus.Desc
+}
+
+// NewDBStatsCollector creates a collector reading db.Stats() on every scrape.
+// Its metrics are not namespaced, like the HTTP ones, so that the shared
+// connection pool alert matches them.
+func NewDBStatsCollector(db *sql.DB) *DBStatsCollector {
+	return &DBStatsCollector{
+		db: db,
+		maxOpen: prometheus.NewDesc("db_connections_max",
+			"Maximum number of open connections to the database.", nil, nil),
+		open: prometheus.NewDesc("db_connections_open",
+			"Number of established connections, both in use and idle.", nil, nil),
+		inUse: prometheus.NewDesc("db_connections_active",
+			"Number of connections currently in use.", nil, nil),
+		idle: prometheus.NewDesc("db_connections_idle",
+			"Number of idle connections.", nil, nil),
+		waitCount: prometheus.NewDesc("db_connections_wait_total",
+			"Total number of connections waited for.", nil, nil),
+		waitDuration: prometheus.NewDesc("db_connections_wait_duration_seconds_total",
+			"Total time blocked waiting for a new connection.", nil, nil),
+		closed: prometheus.NewDesc("db_connections_closed_total",
+			"Total number of connections closed due to idle or lifetime limits.", []string{"reason"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *DBStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.closed
+}
+
+// Collect implements prometheus.Collector
+func (c *DBStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxIdleClosed), "max_idle")
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed), "max_idle_time")
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), "max_lifetime")
+}
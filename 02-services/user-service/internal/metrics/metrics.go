@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace prefixes the metrics only this service exposes. The HTTP and
+// connection pool metrics are deliberately left unprefixed: the shared alert
+// rules and dashboards in 06-monitoring query http_requests_total,
+// http_request_duration_seconds and db_connections_* for every service and
+// tell them apart by the service label.
+const namespace = "user_service"
+
+var (
+	// HTTPRequests counts HTTP requests by method, route template and status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes HTTP request latency
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestSize observes HTTP request body sizes
+	HTTPRequestSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_size_bytes",
+		Help:    "HTTP request body size in bytes.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"method", "route", "status"})
+
+	// HTTPResponseSize observes HTTP response body sizes
+	HTTPResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_response_size_bytes",
+		Help:    "HTTP response body size in bytes.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"method", "route", "status"})
+
+	// CacheRequests counts cache lookups by cache name and result (hit, miss, error)
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Total number of cache lookups by result.",
+	}, []string{"cache", "result"})
+
+	// RateLimitRejections counts requests rejected by the rate limiter per rule
+	RateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Total number of requests rejected by the rate limiter.",
+	}, []string{"rule"})
+)
+
+// Cache lookup results
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheError = "error"
+)
+
+// Register registers the service metrics, database pool statistics and user
+// gauges with reg. Every metric is labelled with the service name.
+func Register(reg prometheus.Registerer, serviceName string, db *sql.DB, users UserStatsSource) error {
+	reg = prometheus.WrapRegistererWith(prometheus.Labels{"service": serviceName}, reg)
+
+	collectors := []prometheus.Collector{
+		HTTPRequests,
+		HTTPRequestDuration,
+		HTTPRequestSize,
+		HTTPResponseSize,
+		CacheRequests,
+		RateLimitRejections,
+		NewDBStatsCollector(db),
+		NewUserStatsCollector(users, userStatsTTL),
+	}
+
+	for _, collector := range collectors {
+		if err := reg.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// userStatsTTL bounds how often a scrape may query the database
+	userStatsTTL = 30 * time.Second
+	// userStatsTimeout bounds how long a scrape waits for the database
+	userStatsTimeout = 5 * time.Second
+)
+
+// UserStatsSource provides aggregate user counts
+type UserStatsSource interface {
+	UserStats(ctx context.Context) (*models.UserStats, error)
+}
+
+// UserStatsCollector exposes business gauges for registered, active and
+// verified users. Counts are cached so frequent scrapes don't load the database.
+type UserStatsCollector struct {
+	source UserStatsSource
+	ttl    time.Duration
+
+	total    *prometheus.Desc
+	active   *prometheus.Desc
+	verified *prometheus.Desc
+	up       *prometheus.Desc
+
+	mu        sync.Mutex
+	stats     *models.UserStats
+	fetchedAt time.Time
+}
+
+// NewUserStatsCollector creates a collector refreshing counts at most once per ttl
+func NewUserStatsCollector(source UserStatsSource, ttl time.Duration) *UserStatsCollector {
+	return &UserStatsCollector{
+		source: source,
+		ttl:    ttl,
+		total: prometheus.NewDesc(namespace+"_users",
+			"Number of registered (not deleted) users.", nil, nil),
+		active: prometheus.NewDesc(namespace+"_users_active",
+			"Number of active users.", nil, nil),
+		verified: prometheus.NewDesc(namespace+"_users_verified",
+			"Number of users with a verified email.", nil, nil),
+		up: prometheus.NewDesc(namespace+"_user_stats_up",
+			"Whether the last user statistics query succeeded.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *UserStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.total
+	ch <- c.active
+	ch <- c.verified
+	ch <- c.up
+}
+
+// Collect implements prometheus.Collector
+func (c *UserStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.load()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stats.Total))
+	ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(stats.Active))
+	ch <- prometheus.MustNewConstMetric(c.verified, prometheus.GaugeValue, float64(stats.Verified))
+}
+
+func (c *UserStatsCollector) load() (*models.UserStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats != nil && time.Since(c.fetchedAt) < c.ttl {
+		return c.stats, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), userStatsTimeout)
+	defer cancel()
+
+	stats, err := c.source.UserStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.stats = stats
+	c.fetchedAt = time.Now()
+	return stats, nil
+}
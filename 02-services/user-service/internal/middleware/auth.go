@@ -1,41 +1,47 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/devsecops/user-service/internal/apperrors"
 	"github.com/devsecops/user-service/internal/config"
 	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/response"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-// AuthMiddleware validates JWT tokens
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+// UserFinder looks up the user a token was issued to
+type UserFinder interface {
+	FindByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+}
+
+// AuthMiddleware validates JWT tokens. With users, it also rejects tokens
+// issued before their user's password last changed and tokens of deleted
+// users.
+func AuthMiddleware(cfg *config.Config, users UserFinder) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error: models.ErrorDetail{
-					Code:    "UNAUTHORIZED",
-					Message: "Authorization header required",
-				},
+			response.AbortWithError(c, http.StatusUnauthorized, models.ErrorDetail{
+				Code:    apperrors.CodeUnauthorized,
+				Message: "Authorization header required",
 			})
-			c.Abort()
 			return
 		}
 
 		// Extract token from "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error: models.ErrorDetail{
-					Code:    "UNAUTHORIZED",
-					Message: "Invalid authorization header format",
-				},
+			response.AbortWithError(c, http.StatusUnauthorized, models.ErrorDetail{
+				Code:    apperrors.CodeUnauthorized,
+				Message: "Invalid authorization header format",
 			})
-			c.Abort()
 			return
 		}
 
@@ -50,23 +56,58 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 		})
 
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error: models.ErrorDetail{
-					Code:    "UNAUTHORIZED",
-					Message: "Invalid or expired token",
-				},
+			response.AbortWithError(c, http.StatusUnauthorized, models.ErrorDetail{
+				Code:    apperrors.CodeUnauthorized,
+				Message: "Invalid or expired token",
 			})
-			c.Abort()
 			return
 		}
 
 		// Extract claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Set("user_id", claims["user_id"])
-			c.Set("email", claims["email"])
-			c.Set("role", claims["role"])
+		claims, _ := token.Claims.(jwt.MapClaims)
+		c.Set("user_id", claims["user_id"])
+		c.Set("email", claims["email"])
+		c.Set("role", claims["role"])
+		c.Set("admin", claims["role"] == cfg.JWTAdminRole)
+
+		if users != nil {
+			revoked, err := tokenRevoked(c.Request.Context(), users, claims)
+			if err != nil {
+				_ = c.Error(err).SetMeta("Failed to check token")
+				c.Abort()
+				return
+			}
+			if revoked {
+				response.AbortWithError(c, http.StatusUnauthorized, models.ErrorDetail{
+					Code:    apperrors.CodeUnauthorized,
+					Message: "Token has been revoked",
+				})
+				return
+			}
 		}
 
 		c.Next()
 	}
 }
+
+// tokenRevoked reports whether a token was issued before the password of its
+// user last changed, to the second, or its user was deleted. Tokens without
+// a user_id claim do not belong to a user and are never revoked.
+func tokenRevoked(ctx context.Context, users UserFinder, claims jwt.MapClaims) (bool, error) {
+	subject, _ := claims["user_id"].(string)
+	id, err := uuid.Parse(subject)
+	if err != nil {
+		return false, nil
+	}
+
+	user, err := users.FindByID(ctx, id)
+	if errors.Is(err, apperrors.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil || user.PasswordChangedAt == nil {
+		return false, err
+	}
+
+	issuedAt, ok := claims["iat"].(float64)
+	return !ok || int64(issuedAt) < user.PasswordChangedAt.Unix(), nil
+}
@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys set by ClientIPMiddleware
+const (
+	clientIPKey   = "client_ip"
+	proxyChainKey = "proxy_chain"
+)
+
+// ClientIPResolver determines the originating client IP of a request,
+// honouring forwarding headers only when they were added by a trusted proxy
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+	headers []string
+}
+
+// NewClientIPResolver creates a resolver trusting the given proxy IPs or CIDRs.
+// Headers are consulted in order; the first one present wins. Supported headers
+// are "Forwarded" (RFC 7239) and any header carrying a comma-separated list of
+// IPs such as "X-Forwarded-For" or "X-Real-IP".
+func NewClientIPResolver(trustedProxies, headers []string) (*ClientIPResolver, error) {
+	r := &ClientIPResolver{headers: headers}
+
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+
+	return r, nil
+}
+
+// Resolve returns the client IP and the chain of addresses the request passed
+// through, ordered from the client to the proxy that connected to us. The chain
+// is empty when the peer is not a trusted proxy.
+func (r *ClientIPResolver) Resolve(req *http.Request) (string, []string) {
+	remoteIP := remoteAddrIP(req.RemoteAddr)
+	if remoteIP == nil {
+		return strings.TrimSpace(req.RemoteAddr), nil
+	}
+
+	if !r.isTrusted(remoteIP) {
+		return remoteIP.String(), nil
+	}
+
+	for _, header := range r.headers {
+		values := req.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
+
+		var hops []string
+		if strings.EqualFold(header, "Forwarded") {
+			hops = parseForwarded(values)
+		} else {
+			hops = parseIPList(values)
+		}
+
+		chain := append(hops, remoteIP.String())
+		if clientIP, ok := r.walk(hops); ok {
+			return clientIP, chain
+		}
+	}
+
+	return remoteIP.String(), []string{remoteIP.String()}
+}
+
+// walk returns the right-most untrusted hop, or the left-most hop when every
+// hop is trusted. It fails if any hop it visits is not a valid IP.
+func (r *ClientIPResolver) walk(hops []string) (string, bool) {
+	if len(hops) == 0 {
+		return "", false
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			return "", false
+		}
+		if !r.isTrusted(ip) {
+			return ip.String(), true
+		}
+	}
+
+	return net.ParseIP(hops[0]).String(), true
+}
+
+func (r *ClientIPResolver) isTrusted(ip net.IP) bool {
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIPMiddleware resolves the client IP and proxy chain once per request
+func ClientIPMiddleware(resolver *ClientIPResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP, chain := resolver.Resolve(c.Request)
+		c.Set(clientIPKey, clientIP)
+		c.Set(proxyChainKey, chain)
+		c.Next()
+	}
+}
+
+// ClientIP returns the client IP resolved by ClientIPMiddleware, falling back
+// to gin's own resolution when the middleware is not installed
+func ClientIP(c *gin.Context) string {
+	if clientIP := c.GetString(clientIPKey); clientIP != "" {
+		return clientIP
+	}
+	return c.ClientIP()
+}
+
+// ProxyChain returns the proxy chain resolved by ClientIPMiddleware
+func ProxyChain(c *gin.Context) []string {
+	return c.GetStringSlice(proxyChainKey)
+}
+
+// remoteAddrIP extracts the IP from an "ip:port" remote address
+func remoteAddrIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(remoteAddr))
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// parseIPList splits headers such as X-Forwarded-For into their addresses
+func parseIPList(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				hops = append(hops, part)
+			}
+		}
+	}
+	return hops
+}
+
+// parseForwarded extracts the "for" parameter of each element of RFC 7239
+// Forwarded headers, stripping quotes, IPv6 brackets and ports
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				hops = append(hops, forwardedNode(val))
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedNode normalizes a Forwarded node such as "192.0.2.1:4711" or
+// "[2001:db8::1]:4711" to a bare IP. Obfuscated identifiers are returned as-is.
+func forwardedNode(node string) string {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.0.2.10", "::1"}, []string{"Forwarded", "X-Forwarded-For"})
+	if err != nil {
+		t.Fatalf("NewClientIPResolver: %v", err)
+	}
+
+	const proxy = "10.0.0.1:4711"
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       string
+		wantChain  []string
+	}{
+		{
+			name:       "untrusted peer without headers",
+			remoteAddr: "198.51.100.7:4711",
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "untrusted peer spoofing X-Forwarded-For",
+			remoteAddr: "198.51.100.7:4711",
+			header:     http.Header{"X-Forwarded-For": {"203.0.113.1"}},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "untrusted peer spoofing Forwarded",
+			remoteAddr: "198.51.100.7:4711",
+			header:     http.Header{"Forwarded": {"for=203.0.113.1"}},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "trusted proxy without headers",
+			remoteAddr: proxy,
+			want:       "10.0.0.1",
+			wantChain:  []string{"10.0.0.1"},
+		},
+		{
+			name:       "spoofed left-most X-Forwarded-For entry",
+			remoteAddr: proxy,
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.7"}},
+			want:       "198.51.100.7",
+			wantChain:  []string{"1.2.3.4", "198.51.100.7", "10.0.0.1"},
+		},
+		{
+			name:       "spoofed entry in a separate header line",
+			remoteAddr: proxy,
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4", "198.51.100.7"}},
+			want:       "198.51.100.7",
+			wantChain:  []string{"1.2.3.4", "198.51.100.7", "10.0.0.1"},
+		},
+		{
+			name:       "trusted hops are skipped",
+			remoteAddr: proxy,
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.7, 192.0.2.10, 10.0.0.5"}},
+			want:       "198.51.100.7",
+			wantChain:  []string{"1.2.3.4", "198.51.100.7", "192.0.2.10", "10.0.0.5", "10.0.0.1"},
+		},
+		{
+			name:       "every hop trusted",
+			remoteAddr: proxy,
+			header:     http.Header{"X-Forwarded-For": {"10.1.1.1, 10.0.0.5"}},
+			want:       "10.1.1.1",
+			wantChain:  []string{"10.1.1.1", "10.0.0.5", "10.0.0.1"},
+		},
+		{
+			name:       "garbage left of the client is ignored",
+			remoteAddr: proxy,
+			header:     http.Header{"X-Forwarded-For": {"not-an-ip, 198.51.100.7"}},
+			want:       "198.51.100.7",
+			wantChain:  []string{"not-an-ip", "198.51.100.7", "10.0.0.1"},
+		},
+		{
+			name:       "garbage as right-most hop falls back to the peer",
+			remoteAddr: proxy,
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.7, not-an-ip"}},
+			want:       "10.0.0.1",
+			wantChain:  []string{"10.0.0.1"},
+		},
+		{
+			name:       "quoted IPv6 Forwarded node with port",
+			remoteAddr: proxy,
+			header:     http.Header{"Forwarded": {`for="[2001:db8::1]:443"`}},
+			want:       "2001:db8::1",
+			wantChain:  []string{"2001:db8::1", "10.0.0.1"},
+		},
+		{
+			name:       "quoted IPv6 Forwarded node without port",
+			remoteAddr: proxy,
+			header:     http.Header{"Forwarded": {`for="[2001:db8:cafe::17]"`}},
+			want:       "2001:db8:cafe::17",
+			wantChain:  []string{"2001:db8:cafe::17", "10.0.0.1"},
+		},
+		{
+			name:       "IPv4 Forwarded node with port and other parameters",
+			remoteAddr: proxy,
+			header:     http.Header{"Forwarded": {"proto=https;For=198.51.100.7:4711;by=203.0.113.43"}},
+			want:       "198.51.100.7",
+			wantChain:  []string{"198.51.100.7", "10.0.0.1"},
+		},
+		{
+			name:       "spoofed left-most Forwarded element",
+			remoteAddr: proxy,
+			header:     http.Header{"Forwarded": {"for=1.2.3.4, for=198.51.100.7;proto=https", "for=10.0.0.5"}},
+			want:       "198.51.100.7",
+			wantChain:  []string{"1.2.3.4", "198.51.100.7", "10.0.0.5", "10.0.0.1"},
+		},
+		{
+			name:       "unknown node left of the client is ignored",
+			remoteAddr: proxy,
+			header:     http.Header{"Forwarded": {"for=unknown, for=198.51.100.7"}},
+			want:       "198.51.100.7",
+			wantChain:  []string{"unknown", "198.51.100.7", "10.0.0.1"},
+		},
+		{
+			name:       "obfuscated right-most node falls back to the peer",
+			remoteAddr: proxy,
+			header:     http.Header{"Forwarded": {`for=198.51.100.7, for="_hidden"`}},
+			want:       "10.0.0.1",
+			wantChain:  []string{"10.0.0.1"},
+		},
+		{
+			name:       "unknown right-most node falls back to the next header",
+			remoteAddr: proxy,
+			header: http.Header{
+				"Forwarded":       {"for=unknown"},
+				"X-Forwarded-For": {"198.51.100.7"},
+			},
+			want:      "198.51.100.7",
+			wantChain: []string{"198.51.100.7", "10.0.0.1"},
+		},
+		{
+			name:       "Forwarded takes precedence over X-Forwarded-For",
+			remoteAddr: proxy,
+			header: http.Header{
+				"Forwarded":       {"for=198.51.100.7"},
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			want:      "198.51.100.7",
+			wantChain: []string{"198.51.100.7", "10.0.0.1"},
+		},
+		{
+			name:       "trusted IPv6 peer",
+			remoteAddr: "[::1]:4711",
+			header:     http.Header{"X-Forwarded-For": {"2001:db8::2"}},
+			want:       "2001:db8::2",
+			wantChain:  []string{"2001:db8::2", "::1"},
+		},
+		{
+			name:       "peer without port",
+			remoteAddr: "198.51.100.7",
+			want:       "198.51.100.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header = tt.header
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+
+			got, chain := resolver.Resolve(req)
+			if got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(chain, tt.wantChain) {
+				t.Errorf("chain = %q, want %q", chain, tt.wantChain)
+			}
+		})
+	}
+}
+
+func TestNewClientIPResolverInvalidProxy(t *testing.T) {
+	for _, proxy := range []string{"not-an-ip", "10.0.0.0/33", "2001:db8::/129"} {
+		if _, err := NewClientIPResolver([]string{proxy}, nil); err == nil {
+			t.Errorf("NewClientIPResolver(%q): expected an error", proxy)
+		}
+	}
+}
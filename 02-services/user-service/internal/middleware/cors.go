@@ -1,20 +1,168 @@
 package middleware
 
 import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/pkg/requestid"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-// CORSMiddleware configures CORS settings
-func CORSMiddleware() gin.HandlerFunc {
-	config := cors.Config{
-		AllowOrigins:     []string{"*"}, // In production, specify exact origins
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * 60 * 60, // 12 hours
+// exposedHeaders are always readable by browser clients, whatever the config
+var exposedHeaders = []string{
+	requestid.Header,
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+}
+
+// OriginValidator decides whether an origin that is not in the configured
+// list may make cross-origin requests, e.g. by looking it up in a registry
+type OriginValidator func(origin string) bool
+
+// CORS applies the CORS policy from the configuration. The policy can be
+// replaced at runtime with Update.
+type CORS struct {
+	validators []OriginValidator
+	handler    atomic.Pointer[gin.HandlerFunc]
+}
+
+// originPattern matches an exact origin or, when wildcard is set, any
+// subdomain of host
+type originPattern struct {
+	scheme   string
+	host     string
+	wildcard bool
+}
+
+// NewCORS creates the CORS middleware. Validators are consulted, in order,
+// for origins that match none of the configured patterns.
+func NewCORS(cfg *config.Config, validators ...OriginValidator) (*CORS, error) {
+	c := &CORS{validators: validators}
+	if err := c.Update(cfg); err != nil {
+		return nil, err
 	}
+	return c, nil
+}
 
-	return cors.New(config)
+// Update replaces the CORS policy with the one from cfg
+func (c *CORS) Update(cfg *config.Config) error {
+	corsConfig := cors.Config{
+		AllowMethods:     cfg.CORSAllowMethods,
+		AllowHeaders:     cfg.CORSAllowHeaders,
+		ExposeHeaders:    mergeHeaders(cfg.CORSExposeHeaders, exposedHeaders),
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
+	}
+
+	// "*" is only honoured without credentials; browsers reject the combination
+	if len(cfg.CORSAllowedOrigins) == 1 && cfg.CORSAllowedOrigins[0] == "*" && !cfg.CORSAllowCredentials {
+		corsConfig.AllowAllOrigins = true
+	} else {
+		patterns, err := parseOrigins(cfg.CORSAllowedOrigins)
+		if err != nil {
+			return err
+		}
+		corsConfig.AllowOriginFunc = c.allowOrigin(patterns)
+	}
+
+	if err := corsConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid CORS policy: %w", err)
+	}
+
+	handler := cors.New(corsConfig)
+	c.handler.Store(&handler)
+	return nil
+}
+
+// Middleware returns a handler applying the current policy
+func (c *CORS) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		(*c.handler.Load())(ctx)
+	}
+}
+
+func (c *CORS) allowOrigin(patterns []originPattern) func(string) bool {
+	return func(origin string) bool {
+		parsed, err := url.Parse(origin)
+		if err == nil {
+			for _, pattern := range patterns {
+				if pattern.matches(parsed) {
+					return true
+				}
+			}
+		}
+
+		for _, validator := range c.validators {
+			if validator(origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (p originPattern) matches(origin *url.URL) bool {
+	if !strings.EqualFold(origin.Scheme, p.scheme) {
+		return false
+	}
+
+	host := strings.ToLower(origin.Host)
+	if !p.wildcard {
+		return host == p.host
+	}
+
+	// *.example.com matches a.example.com and a.b.example.com, not example.com
+	prefix, ok := strings.CutSuffix(host, "."+p.host)
+	return ok && prefix != ""
+}
+
+// parseOrigins compiles origins such as https://app.example.com or
+// https://*.example.com:8443
+func parseOrigins(origins []string) ([]originPattern, error) {
+	patterns := make([]originPattern, 0, len(origins))
+	for _, origin := range origins {
+		if origin == "*" {
+			return nil, fmt.Errorf("invalid CORS origin %q: cannot be combined with other origins or credentials", origin)
+		}
+
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+			return nil, fmt.Errorf("invalid CORS origin %q, expected scheme://host[:port]", origin)
+		}
+
+		pattern := originPattern{scheme: strings.ToLower(scheme), host: strings.ToLower(host)}
+		if rest, ok := strings.CutPrefix(pattern.host, "*."); ok {
+			pattern.host = rest
+			pattern.wildcard = true
+		}
+		if strings.Contains(pattern.host, "*") {
+			return nil, fmt.Errorf("invalid CORS origin %q: wildcards are only allowed as the first label", origin)
+		}
+
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// mergeHeaders appends the required headers missing from configured
+func mergeHeaders(configured, required []string) []string {
+	merged := append([]string{}, configured...)
+	for _, header := range required {
+		found := false
+		for _, existing := range configured {
+			if strings.EqualFold(existing, header) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, header)
+		}
+	}
+	return merged
 }
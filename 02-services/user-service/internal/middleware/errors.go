@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/i18n"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/response"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// errorStatus maps each kind of error to its HTTP status and default code
+var errorStatus = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{apperrors.ErrValidation, http.StatusBadRequest, apperrors.CodeValidation},
+	{apperrors.ErrNotFound, http.StatusNotFound, apperrors.CodeNotFound},
+	{apperrors.ErrConflict, http.StatusConflict, apperrors.CodeConflict},
+	{apperrors.ErrPrecondition, http.StatusPreconditionFailed, apperrors.CodePrecondition},
+	{apperrors.ErrPreconditionRequired, http.StatusPreconditionRequired, apperrors.CodeIfMatchRequired},
+	{apperrors.ErrUnauthorized, http.StatusUnauthorized, apperrors.CodeUnauthorized},
+	{apperrors.ErrForbidden, http.StatusForbidden, apperrors.CodeForbidden},
+	{apperrors.ErrLocked, http.StatusLocked, apperrors.CodeAccountLocked},
+	{apperrors.ErrUnavailable, http.StatusServiceUnavailable, apperrors.CodeUnavailable},
+}
+
+// ErrorMiddleware renders the last error a handler recorded with c.Error,
+// unless a response was already written. Errors from package apperrors are
+// mapped to their status and code; a cancelled or timed out request gets
+// 499 or 503. Anything else is logged and reported as a 500 whose message is
+// the error's meta string, if set.
+func ErrorMiddleware(log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		writeError(c, log)
+	}
+}
+
+// writeError renders the last recorded error as described on ErrorMiddleware.
+// Middleware that must see the final response calls it before returning.
+func writeError(c *gin.Context, log *logrus.Logger) {
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+
+	last := c.Errors.Last()
+	err := last.Err
+	entry := log.WithContext(c.Request.Context()).WithField("path", c.FullPath())
+
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) && !errors.Is(err, apperrors.ErrUnavailable) {
+		renderAppError(c, appErr)
+		return
+	}
+
+	if response.ContextError(c, err) {
+		entry.Warnf("Request aborted: %v", err)
+		return
+	}
+
+	if appErr != nil {
+		entry.Errorf("Dependency unavailable: %v", err)
+		c.Header("Retry-After", "1")
+		renderAppError(c, appErr)
+		return
+	}
+
+	message, _ := last.Meta.(string)
+	if message == "" {
+		message = "Internal server error"
+	}
+	entry.Errorf("%s: %v", message, err)
+	response.Error(c, http.StatusInternalServerError, models.ErrorDetail{
+		Code:    apperrors.CodeInternal,
+		Message: message,
+	})
+}
+
+func renderAppError(c *gin.Context, err *apperrors.Error) {
+	status, code := http.StatusInternalServerError, apperrors.CodeInternal
+	for _, s := range errorStatus {
+		if errors.Is(err.Kind, s.kind) {
+			status, code = s.status, s.code
+			break
+		}
+	}
+	if err.Code != "" {
+		code = err.Code
+	}
+
+	response.Error(c, status, models.ErrorDetail{
+		Code:    code,
+		Message: err.Message,
+		Details: i18n.Fields(response.Language(c), err.Fields),
+	})
+}
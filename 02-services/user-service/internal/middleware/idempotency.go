@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/idempotency"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/response"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client's key for a request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response replayed from an earlier request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	idempotencyPollInterval = 100 * time.Millisecond
+)
+
+// replayedHeaders are the response headers stored along with the body
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// IdempotencyMiddleware makes requests with an Idempotency-Key header safe to
+// retry. Keys are scoped to the route and caller, and bound to the request
+// they were first used with: a retry with the same method, URI and body gets
+// the stored response, a different request gets 422. A retry while the first
+// request is still running waits for it, up to the configured wait timeout,
+// then gets 409. Server errors and rejected requests are not stored.
+func IdempotencyMiddleware(store *idempotency.Store, cfg *config.Config, log *logrus.Logger) gin.HandlerFunc {
+	// A pending key outlives any request that could be holding it
+	lockTTL := 2 * cfg.RequestTimeout
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if !validIdempotencyKey(key) {
+			response.AbortWithError(c, http.StatusBadRequest, models.ErrorDetail{
+				Code:    apperrors.CodeInvalidIdempotency,
+				Message: fmt.Sprintf("%s must be 1 to %d printable ASCII characters", IdempotencyKeyHeader, maxIdempotencyKeyLength),
+			})
+			return
+		}
+
+		body, err := readBody(c)
+		if err != nil {
+			abortBodyError(c, err)
+			return
+		}
+
+		ctx := c.Request.Context()
+		storageKey := hashParts(c.Request.Method, c.FullPath(), idempotencyCaller(c), key)
+		requestHash := hashParts(c.Request.Method, c.Request.URL.RequestURI(), string(body))
+
+		claim, err := store.Reserve(ctx, storageKey, requestHash, lockTTL)
+		if err != nil {
+			_ = c.Error(apperrors.Unavailable(err))
+			c.Abort()
+			return
+		}
+
+		if claim.Existing != nil {
+			replayIdempotent(c, claim, requestHash, cfg.IdempotencyWait)
+			return
+		}
+
+		// This request owns the key: record its response for retries
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.DBWriteTimeout)
+			defer cancel()
+			if err := claim.Release(releaseCtx); err != nil {
+				log.WithContext(ctx).Warnf("Failed to release idempotency key: %v", err)
+			}
+		}()
+
+		c.Next()
+		writeError(c, log)
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests || status == response.StatusClientClosedRequest {
+			return
+		}
+
+		rec := idempotency.Record{
+			RequestHash: requestHash,
+			Status:      status,
+			Header:      http.Header{},
+			Body:        recorder.body.Bytes(),
+		}
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				rec.Header.Set(name, value)
+			}
+		}
+
+		completeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.DBWriteTimeout)
+		defer cancel()
+		if err := claim.Complete(completeCtx, rec, cfg.IdempotencyTTL); err != nil {
+			log.WithContext(ctx).Errorf("Failed to store idempotent response: %v", err)
+			return
+		}
+		stored = true
+	}
+}
+
+// replayIdempotent answers a request whose key was already used
+func replayIdempotent(c *gin.Context, claim *idempotency.Claim, requestHash string, wait time.Duration) {
+	rec := claim.Existing
+	if rec.RequestHash != requestHash {
+		response.AbortWithError(c, http.StatusUnprocessableEntity, models.ErrorDetail{
+			Code:    apperrors.CodeIdempotencyReused,
+			Message: IdempotencyKeyHeader + " was already used with a different request",
+		})
+		return
+	}
+
+	if !rec.Completed {
+		waitCtx, cancel := context.WithTimeout(c.Request.Context(), wait)
+		defer cancel()
+
+		var err error
+		rec, err = claim.Wait(waitCtx, idempotencyPollInterval)
+		if err != nil && c.Request.Context().Err() != nil {
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			_ = c.Error(apperrors.Unavailable(err))
+			c.Abort()
+			return
+		}
+		if rec == nil || !rec.Completed {
+			// Still running, or released after failing: the client may retry
+			c.Header("Retry-After", "1")
+			response.AbortWithError(c, http.StatusConflict, models.ErrorDetail{
+				Code:    apperrors.CodeIdempotencyPending,
+				Message: "A request with the same " + IdempotencyKeyHeader + " is still being processed",
+			})
+			return
+		}
+	}
+
+	for name, values := range rec.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Writer.WriteHeader(rec.Status)
+	_, _ = c.Writer.Write(rec.Body)
+	c.Abort()
+}
+
+// validIdempotencyKey reports whether key is 1 to 255 printable ASCII
+// characters
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyCaller identifies who sent a request, so keys from different
+// callers never collide
+func idempotencyCaller(c *gin.Context) string {
+	if userID := contextString(c, "user_id"); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + ClientIP(c)
+}
+
+// readBody reads the request body and replaces it so handlers can read it
+// again
+func readBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// abortBodyError rejects a request whose body could not be read
+func abortBodyError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		response.AbortWithError(c, http.StatusRequestEntityTooLarge, models.ErrorDetail{
+			Code:    apperrors.CodeRequestTooLarge,
+			Message: fmt.Sprintf("Request body must not exceed %d bytes", maxBytesErr.Limit),
+		})
+		return
+	}
+	_ = c.Error(apperrors.Validation(apperrors.CodeValidation, "Request body could not be read").Wrap(err))
+	c.Abort()
+}
+
+// hashParts returns the hex SHA-256 of parts, separated so that no two
+// different lists hash alike
+func hashParts(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		_, _ = io.WriteString(h, strconv.Itoa(len(part)))
+		_, _ = io.WriteString(h, ":")
+		_, _ = io.WriteString(h, part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body as it is written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
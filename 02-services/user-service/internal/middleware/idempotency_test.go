@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/idempotency"
+	"github.com/devsecops/user-service/internal/idempotency/idempotencytest"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// idempotencyServer routes POST /users through IdempotencyMiddleware to a
+// handler that counts its calls and, if block is set, waits for it first
+type idempotencyServer struct {
+	router *gin.Engine
+	calls  atomic.Int32
+	block  chan struct{}
+}
+
+func newIdempotencyServer(t *testing.T, wait time.Duration) *idempotencyServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	cfg := &config.Config{
+		RequestTimeout:  5 * time.Second,
+		DBWriteTimeout:  time.Second,
+		IdempotencyTTL:  time.Hour,
+		IdempotencyWait: wait,
+	}
+	store := idempotency.NewStore(log, idempotencytest.NewBackend("memory"))
+
+	s := &idempotencyServer{}
+	s.router = gin.New()
+	s.router.Use(ErrorMiddleware(log))
+	s.router.POST("/users", IdempotencyMiddleware(store, cfg, log), func(c *gin.Context) {
+		n := s.calls.Add(1)
+		if s.block != nil {
+			<-s.block
+		}
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Header("Location", "/users/1")
+		c.Header("X-Not-Replayed", "true")
+		c.JSON(http.StatusCreated, gin.H{"call": n, "body": string(body)})
+	})
+	return s
+}
+
+func (s *idempotencyServer) post(key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("not an error response: %s", rec.Body.String())
+	}
+	return resp.Error.Code
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	s := newIdempotencyServer(t, time.Second)
+
+	first := s.post("key-1", `{"username":"alice"}`)
+	if first.Code != http.StatusCreated || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("first request = %d, replayed %q", first.Code, first.Header().Get(IdempotentReplayedHeader))
+	}
+
+	replay := s.post("key-1", `{"username":"alice"}`)
+	if replay.Code != http.StatusCreated || replay.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("retry = %d, replayed %q, want 201 replayed", replay.Code, replay.Header().Get(IdempotentReplayedHeader))
+	}
+	if replay.Body.String() != first.Body.String() {
+		t.Errorf("replayed body %s, want %s", replay.Body.String(), first.Body.String())
+	}
+	for _, name := range []string{"Content-Type", "Location"} {
+		if replay.Header().Get(name) != first.Header().Get(name) {
+			t.Errorf("replayed %s = %q, want %q", name, replay.Header().Get(name), first.Header().Get(name))
+		}
+	}
+	if replay.Header().Get("X-Not-Replayed") != "" {
+		t.Error("a header outside replayedHeaders was replayed")
+	}
+	if calls := s.calls.Load(); calls != 1 {
+		t.Errorf("handler ran %d times, want once", calls)
+	}
+
+	// Other keys and requests without a key run the handler
+	s.post("key-2", `{"username":"alice"}`)
+	s.post("", `{"username":"alice"}`)
+	s.post("", `{"username":"alice"}`)
+	if calls := s.calls.Load(); calls != 4 {
+		t.Errorf("handler ran %d times, want 4", calls)
+	}
+}
+
+func TestIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	s := newIdempotencyServer(t, time.Second)
+
+	if rec := s.post("key-1", `{"username":"alice"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("first request = %d", rec.Code)
+	}
+	rec := s.post("key-1", `{"username":"bob"}`)
+	if rec.Code != http.StatusUnprocessableEntity || errorCode(t, rec) != apperrors.CodeIdempotencyReused {
+		t.Fatalf("reused key = %d %s, want 422 %s", rec.Code, rec.Body.String(), apperrors.CodeIdempotencyReused)
+	}
+	if calls := s.calls.Load(); calls != 1 {
+		t.Errorf("handler ran %d times, want once", calls)
+	}
+}
+
+func TestIdempotencyPendingTimeout(t *testing.T) {
+	const wait = 300 * time.Millisecond
+	s := newIdempotencyServer(t, wait)
+	s.block = make(chan struct{})
+
+	firstDone := make(chan *httptest.ResponseRecorder)
+	go func() { firstDone <- s.post("key-1", `{"username":"alice"}`) }()
+	for s.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	rec := s.post("key-1", `{"username":"alice"}`)
+	if rec.Code != http.StatusConflict || errorCode(t, rec) != apperrors.CodeIdempotencyPending {
+		t.Fatalf("retry while pending = %d %s, want 409 %s", rec.Code, rec.Body.String(), apperrors.CodeIdempotencyPending)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want 1", rec.Header().Get("Retry-After"))
+	}
+	if elapsed := time.Since(start); elapsed < wait {
+		t.Errorf("409 after %s, want it after the %s wait timeout", elapsed, wait)
+	}
+
+	// A retry waiting while the first request completes gets its response
+	retryDone := make(chan *httptest.ResponseRecorder)
+	go func() { retryDone <- s.post("key-1", `{"username":"alice"}`) }()
+	time.Sleep(wait / 3)
+	close(s.block)
+
+	first := <-firstDone
+	retry := <-retryDone
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request = %d", first.Code)
+	}
+	if retry.Code != http.StatusCreated || retry.Header().Get(IdempotentReplayedHeader) != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %s, want the replayed first response", retry.Code, retry.Body.String())
+	}
+	if calls := s.calls.Load(); calls != 1 {
+		t.Errorf("handler ran %d times, want once", calls)
+	}
+}
+
+func TestIdempotencyInvalidKey(t *testing.T) {
+	s := newIdempotencyServer(t, time.Second)
+	for _, key := range []string{strings.Repeat("k", maxIdempotencyKeyLength+1), "key\x7f"} {
+		rec := s.post(key, `{}`)
+		if rec.Code != http.StatusBadRequest || errorCode(t, rec) != apperrors.CodeInvalidIdempotency {
+			t.Errorf("key %q = %d %s, want 400 %s", key, rec.Code, rec.Body.String(), apperrors.CodeInvalidIdempotency)
+		}
+	}
+	if calls := s.calls.Load(); calls != 0 {
+		t.Errorf("handler ran %d times, want never", calls)
+	}
+}
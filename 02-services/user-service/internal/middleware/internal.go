@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/response"
+	"github.com/gin-gonic/gin"
+)
+
+// InternalAPIKey identifies requests admitted by InternalAuthMiddleware to
+// rate limit rules keyed on api_key
+const InternalAPIKey = "internal"
+
+// InternalAuthMiddleware admits requests bearing token, shared with the
+// services allowed to call routes outside the public API
+func InternalAuthMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			response.AbortWithError(c, http.StatusUnauthorized, models.ErrorDetail{
+				Code:    apperrors.CodeUnauthorized,
+				Message: "Invalid service token",
+			})
+			return
+		}
+
+		c.Set("api_key", InternalAPIKey)
+		c.Next()
+	}
+}
@@ -22,13 +22,14 @@ func LoggingMiddleware(log *logrus.Logger) gin.HandlerFunc {
 		statusCode := c.Writer.Status()
 
 		// Log request details
-		log.WithFields(logrus.Fields{
-			"method":     c.Request.Method,
-			"path":       c.Request.URL.Path,
-			"status":     statusCode,
-			"latency":    latency,
-			"ip":         c.ClientIP(),
-			"user_agent": c.Request.UserAgent(),
+		log.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"method":      c.Request.Method,
+			"path":        c.Request.URL.Path,
+			"status":      statusCode,
+			"latency":     latency,
+			"ip":          ClientIP(c),
+			"proxy_chain": ProxyChain(c),
+			"user_agent":  c.Request.UserAgent(),
 		}).Info("HTTP request")
 	}
 }
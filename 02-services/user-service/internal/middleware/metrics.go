@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devsecops/user-service/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware records RED metrics for each request, labelled by route
+// template rather than raw path to keep label cardinality bounded
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		labels := []string{normalizeMethod(c.Request.Method), route, strconv.Itoa(c.Writer.Status())}
+
+		requestSize := c.Request.ContentLength
+		if requestSize < 0 {
+			requestSize = 0
+		}
+		responseSize := c.Writer.Size()
+		if responseSize < 0 {
+			responseSize = 0
+		}
+
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(startTime).Seconds())
+		metrics.HTTPRequestSize.WithLabelValues(labels...).Observe(float64(requestSize))
+		metrics.HTTPResponseSize.WithLabelValues(labels...).Observe(float64(responseSize))
+	}
+}
+
+// normalizeMethod maps non-standard methods to a single label value
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
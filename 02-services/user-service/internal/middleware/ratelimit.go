@@ -1,67 +1,94 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"net/http"
-	"time"
+	"strconv"
 
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/metrics"
 	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/ratelimit"
+	"github.com/devsecops/user-service/internal/response"
 	"github.com/gin-gonic/gin"
-	"github.com/ulule/limiter/v3"
-	"github.com/ulule/limiter/v3/drivers/store/memory"
 )
 
-// RateLimitMiddleware limits the rate of requests
-func RateLimitMiddleware(requests int64, window time.Duration) gin.HandlerFunc {
-	rate := limiter.Rate{
-		Period: window,
-		Limit:  requests,
+// IPRateLimitMiddleware counts every request against the policy's per-IP
+// rule before authentication, so requests with missing or invalid
+// credentials are limited too. Only exempt routes and CIDRs skip it.
+func IPRateLimitMiddleware(rl *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		enforceRateLimit(c, rl.AllowIP)
 	}
+}
 
-	store := memory.NewStore()
-	instance := limiter.New(store, rate)
-
+// RateLimitMiddleware limits the rate of authenticated requests according to
+// the limiter's policy. It must run after the auth middleware for user, role
+// and API key keyed rules.
+func RateLimitMiddleware(rl *ratelimit.Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		context := limiter.Context{
-			Limit:      rate.Limit,
-			Remaining:  0,
-			Reset:      0,
-			Reached:    false,
-		}
+		enforceRateLimit(c, rl.Allow)
+	}
+}
+
+// enforceRateLimit counts the request with allow, sets the rate limit
+// headers and rejects it once the limit has been reached
+func enforceRateLimit(c *gin.Context, allow func(context.Context, ratelimit.Request) (ratelimit.Result, error)) {
+	route := c.FullPath()
+	if route == "" {
+		route = c.Request.URL.Path
+	}
+
+	req := ratelimit.Request{
+		Method:   c.Request.Method,
+		Route:    route,
+		ClientIP: ClientIP(c),
+		UserID:   contextString(c, "user_id"),
+		Role:     contextString(c, "role"),
+		APIKey:   contextString(c, "api_key"),
+	}
 
-		// Get client IP
-		clientIP := c.ClientIP()
+	// Check rate limit
+	result, err := allow(c.Request.Context(), req)
+	if err != nil {
+		response.AbortWithError(c, http.StatusInternalServerError, models.ErrorDetail{
+			Code:    apperrors.CodeInternal,
+			Message: "Rate limit check failed",
+		})
+		return
+	}
 
-		// Check rate limit
-		ctx, err := instance.Get(c.Request.Context(), clientIP)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error: models.ErrorDetail{
-					Code:    "INTERNAL_ERROR",
-					Message: "Rate limit check failed",
-				},
-			})
-			c.Abort()
-			return
-		}
+	if result.Exempt {
+		c.Next()
+		return
+	}
 
-		context = ctx
+	// Set rate limit headers
+	c.Header("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	c.Header("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(result.Reset, 10))
 
-		// Set rate limit headers
-		c.Header("X-RateLimit-Limit", string(context.Limit))
-		c.Header("X-RateLimit-Remaining", string(context.Remaining))
-		c.Header("X-RateLimit-Reset", string(context.Reset))
+	if result.Reached {
+		metrics.RateLimitRejections.WithLabelValues(result.Rule).Inc()
+		response.AbortWithError(c, http.StatusTooManyRequests, models.ErrorDetail{
+			Code:    apperrors.CodeRateLimited,
+			Message: "Too many requests. Please try again later.",
+		})
+		return
+	}
 
-		if context.Reached {
-			c.JSON(http.StatusTooManyRequests, models.ErrorResponse{
-				Error: models.ErrorDetail{
-					Code:    "RATE_LIMIT_EXCEEDED",
-					Message: "Too many requests. Please try again later.",
-				},
-			})
-			c.Abort()
-			return
-		}
+	c.Next()
+}
 
-		c.Next()
+// contextString returns a context value set by earlier middleware as a string
+func contextString(c *gin.Context, key string) string {
+	value, ok := c.Get(key)
+	if !ok || value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
 	}
+	return fmt.Sprint(value)
 }
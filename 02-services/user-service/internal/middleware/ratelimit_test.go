@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestLimiter(t *testing.T, policy *ratelimit.Policy) *ratelimit.Limiter {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	rl, err := ratelimit.NewLimiter("", policy, log)
+	if err != nil {
+		t.Fatalf("failed to create limiter: %v", err)
+	}
+	return rl
+}
+
+func serve(router *gin.Engine, method, path string, header http.Header) int {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestIPRateLimitCountsUnauthenticatedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rl := newTestLimiter(t, ratelimit.DefaultPolicy(2, time.Minute))
+
+	router := gin.New()
+	router.Use(IPRateLimitMiddleware(rl))
+	router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	users := router.Group("/api/v1/users")
+	users.Use(AuthMiddleware(&config.Config{JWTSecret: "secret"}, nil))
+	users.Use(RateLimitMiddleware(rl))
+	users.GET("", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	header := http.Header{"Authorization": {"Bearer not-a-jwt"}}
+	want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, status := range want {
+		if got := serve(router, http.MethodGet, "/api/v1/users", header); got != status {
+			t.Fatalf("request %d: got status %d, want %d", i+1, got, status)
+		}
+	}
+
+	// Exempt routes are never counted
+	for i := 0; i < 3; i++ {
+		if got := serve(router, http.MethodGet, "/health", nil); got != http.StatusOK {
+			t.Fatalf("exempt request %d: got status %d, want %d", i+1, got, http.StatusOK)
+		}
+	}
+}
+
+func TestIPRateLimitExemptCIDR(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy := ratelimit.DefaultPolicy(1, time.Minute)
+	policy.Exempt.CIDRs = []string{"203.0.113.0/24"}
+	rl := newTestLimiter(t, policy)
+
+	router := gin.New()
+	router.Use(IPRateLimitMiddleware(rl))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		if got := serve(router, http.MethodGet, "/", nil); got != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i+1, got, http.StatusOK)
+		}
+	}
+}
+
+func TestRateLimitAPIKeyIgnoresUnauthenticatedKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy := ratelimit.DefaultPolicy(100, time.Minute)
+	policy.Rules = []ratelimit.Rule{{
+		Name:     "internal",
+		Routes:   []string{"/internal/*"},
+		Key:      ratelimit.KeyAPIKey,
+		Requests: 2,
+		Window:   time.Minute,
+	}}
+	rl := newTestLimiter(t, policy)
+
+	router := gin.New()
+	router.Use(IPRateLimitMiddleware(rl))
+	internal := router.Group("/internal/v1")
+	internal.Use(InternalAuthMiddleware("token"))
+	internal.Use(RateLimitMiddleware(rl))
+	internal.POST("/verify", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// A new key header on every request must not open a new bucket
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, status := range want {
+		header := http.Header{
+			"Authorization": {"Bearer token"},
+			"X-Api-Key":     {fmt.Sprintf("random-%d", i)},
+		}
+		if got := serve(router, http.MethodPost, "/internal/v1/verify", header); got != status {
+			t.Fatalf("request %d: got status %d, want %d", i+1, got, status)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/response"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RecoveryMiddleware turns a panic into a 500 error response carrying the
+// request ID, and logs it with the stack. A client that hung up is not
+// answered, as with gin.Recovery.
+func RecoveryMiddleware(log *logrus.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered interface{}) {
+		log.WithContext(c.Request.Context()).WithField("path", c.FullPath()).
+			Errorf("Panic recovered: %v\n%s", recovered, debug.Stack())
+		response.AbortWithError(c, http.StatusInternalServerError, models.ErrorDetail{
+			Code:    apperrors.CodeInternal,
+			Message: "Internal server error",
+		})
+	})
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/metrics"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/pkg/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+// TestPanicIsObserved checks that a panicking handler behind the global
+// middleware of routes.go, in the same order, gets an error response with
+// its request ID and is traced and counted as a 500
+func TestPanicIsObserved(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	flush := setupFileTracing(t)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	router := gin.New()
+	router.Use(RequestIDMiddleware())
+	router.Use(TracingMiddleware())
+	router.Use(MetricsMiddleware())
+	router.Use(LoggingMiddleware(log))
+	router.Use(RecoveryMiddleware(log))
+	router.Use(ErrorMiddleware(log))
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	requests := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/panic", "500")
+	before := testutil.ToFloat64(requests)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	var body models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not an error response: %v", rec.Body.String(), err)
+	}
+	if body.Error.Code != apperrors.CodeInternal || body.RequestID == "" || body.RequestID != rec.Header().Get(requestid.Header) {
+		t.Errorf("got %+v with %s %q, want %s with the request ID", body, requestid.Header, rec.Header().Get(requestid.Header), apperrors.CodeInternal)
+	}
+
+	if got := testutil.ToFloat64(requests) - before; got != 1 {
+		t.Errorf("http_requests_total{status=500} grew by %v, want 1", got)
+	}
+
+	span := findSpan(flush(), "GET /panic")
+	if span == nil {
+		t.Fatal("no server span for the panicking request")
+	}
+	if span.Status.Code != "Error" {
+		t.Errorf("span status = %q, want Error", span.Status.Code)
+	}
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/devsecops/user-service/pkg/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDMiddleware accepts a valid X-Request-ID from the client or generates
+// one, stores it in the request context and echoes it on the response
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		c.Set("request_id", id)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(requestid.Header, id)
+
+		c.Next()
+	}
+}
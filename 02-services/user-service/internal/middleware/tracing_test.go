@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/pkg/database"
+	"github.com/devsecops/user-service/pkg/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// exportedSpan is the part of a span written by the file exporter the test
+// looks at
+type exportedSpan struct {
+	Name        string
+	SpanKind    trace.SpanKind
+	SpanContext struct {
+		TraceID string
+		SpanID  string
+	}
+	Parent struct {
+		TraceID string
+		SpanID  string
+	}
+	Status struct {
+		Code string
+	}
+}
+
+// setupFileTracing exports spans to a file until the returned function
+// flushes them and returns what was written
+func setupFileTracing(t *testing.T) func() []exportedSpan {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := tracing.Setup(context.Background(), &config.Config{
+		ServiceName:        "user-service",
+		Environment:        "test",
+		TracingExporter:    tracing.ExporterFile,
+		TracingFile:        path,
+		TracingSampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %v", err)
+	}
+
+	return func() []exportedSpan {
+		if err := shutdown(context.Background()); err != nil {
+			t.Fatalf("failed to flush spans: %v", err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open trace file: %v", err)
+		}
+		defer file.Close()
+
+		var spans []exportedSpan
+		decoder := json.NewDecoder(file)
+		for {
+			var span exportedSpan
+			if err := decoder.Decode(&span); errors.Is(err, io.EOF) {
+				return spans
+			} else if err != nil {
+				t.Fatalf("failed to decode span: %v", err)
+			}
+			spans = append(spans, span)
+		}
+	}
+}
+
+// newDryRunDB returns a GORM database with the tracing plugin that builds
+// statements without a PostgreSQL server
+func newDryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	connector, err := database.NewConnector(&config.Config{DBHost: "localhost", DBPort: "5432"}, database.Credentials{})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(connector)}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.Use(database.TracingPlugin{}); err != nil {
+		t.Fatalf("failed to register tracing plugin: %v", err)
+	}
+	return db
+}
+
+func findSpan(spans []exportedSpan, name string) *exportedSpan {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name        string
+		traceparent string
+	}{
+		{name: "continues incoming trace", traceparent: "00-" + traceID + "-" + parentSpanID + "-01"},
+		{name: "starts new trace"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			flush := setupFileTracing(t)
+			db := newDryRunDB(t)
+
+			router := gin.New()
+			router.Use(TracingMiddleware())
+			router.GET("/api/v1/users/:id", func(c *gin.Context) {
+				var user models.User
+				db.WithContext(c.Request.Context()).First(&user, "id = ?", c.Param("id"))
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := flush()
+			server := findSpan(spans, "GET /api/v1/users/:id")
+			if server == nil {
+				t.Fatalf("no server span in %+v", spans)
+			}
+			if server.SpanKind != trace.SpanKindServer {
+				t.Errorf("server span kind = %v, want %v", server.SpanKind, trace.SpanKindServer)
+			}
+
+			if tt.traceparent != "" {
+				if server.SpanContext.TraceID != traceID {
+					t.Errorf("server span trace ID = %s, want the incoming %s", server.SpanContext.TraceID, traceID)
+				}
+				if server.Parent.SpanID != parentSpanID {
+					t.Errorf("server span parent = %s, want the incoming %s", server.Parent.SpanID, parentSpanID)
+				}
+			} else if server.Parent.SpanID != "0000000000000000" {
+				t.Errorf("server span has parent %s without a traceparent", server.Parent.SpanID)
+			}
+
+			query := findSpan(spans, "gorm.query")
+			if query == nil {
+				t.Fatalf("no gorm.query span in %+v", spans)
+			}
+			if query.SpanContext.TraceID != server.SpanContext.TraceID || query.Parent.SpanID != server.SpanContext.SpanID {
+				t.Errorf("gorm.query span %s/%s is not a child of server span %s/%s",
+					query.SpanContext.TraceID, query.Parent.SpanID, server.SpanContext.TraceID, server.SpanContext.SpanID)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// IdempotencyKey stores the response to a request made with an
+// Idempotency-Key header when Redis is unavailable
+type IdempotencyKey struct {
+	Key             string    `gorm:"type:varchar(64);primary_key"`
+	RequestHash     string    `gorm:"type:varchar(64);not null"`
+	Completed       bool      `gorm:"not null;default:false"`
+	ResponseStatus  int       `gorm:"not null;default:0"`
+	ResponseHeaders string    `gorm:"type:jsonb;not null;default:'{}'"`
+	ResponseBody    []byte    `gorm:"type:bytea"`
+	CreatedAt       time.Time `gorm:"not null"`
+	ExpiresAt       time.Time `gorm:"not null;index"`
+}
+
+// TableName overrides the table name for IdempotencyKey model
+func (IdempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
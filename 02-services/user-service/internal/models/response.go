@@ -1,15 +1,25 @@
 package models
 
+import "time"
+
 // ErrorResponse represents a standard error response
 type ErrorResponse struct {
-	Error ErrorDetail `json:"error"`
+	Error     ErrorDetail `json:"error"`
+	RequestID string      `json:"request_id,omitempty"`
 }
 
 // ErrorDetail contains error details
 type ErrorDetail struct {
-	Code    string   `json:"code"`
-	Message string   `json:"message"`
-	Details []string `json:"details,omitempty"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// FieldError describes an invalid field of a request, by its JSON path
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
 // SuccessResponse represents a standard success response
@@ -36,8 +46,39 @@ type PaginatedResponse struct {
 
 // HealthResponse represents health check response
 type HealthResponse struct {
-	Status  string            `json:"status"`
-	Service string            `json:"service"`
-	Version string            `json:"version"`
-	Checks  map[string]string `json:"checks,omitempty"`
+	Status        string            `json:"status"`
+	Service       string            `json:"service"`
+	Version       string            `json:"version"`
+	Commit        string            `json:"commit,omitempty"`
+	ConfigVersion *ConfigVersion    `json:"config,omitempty"`
+	Checks        map[string]string `json:"checks,omitempty"`
+}
+
+// DetailedHealthResponse represents a verbose readiness response
+type DetailedHealthResponse struct {
+	Status        string                 `json:"status"`
+	Service       string                 `json:"service"`
+	Version       string                 `json:"version"`
+	Commit        string                 `json:"commit"`
+	BuildTime     string                 `json:"build_time"`
+	ConfigVersion *ConfigVersion         `json:"config,omitempty"`
+	Checks        map[string]CheckDetail `json:"checks"`
+}
+
+// ConfigVersion identifies the active configuration
+type ConfigVersion struct {
+	Version  int       `json:"version"`
+	Checksum string    `json:"checksum"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// CheckDetail contains the outcome of a single health check
+type CheckDetail struct {
+	Status      string     `json:"status"`
+	Critical    bool       `json:"critical"`
+	LatencyMS   float64    `json:"latency_ms"`
+	Error       string     `json:"error,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	CheckedAt   time.Time  `json:"checked_at"`
 }
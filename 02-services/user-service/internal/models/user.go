@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,8 +13,8 @@ import (
 // User represents a user in the system
 type User struct {
 	ID           uuid.UUID      `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
-	Email        string         `gorm:"type:varchar(255);uniqueIndex;not null" json:"email" binding:"required,email"`
-	Username     string         `gorm:"type:varchar(100);uniqueIndex;not null" json:"username" binding:"required,min=3,max=100"`
+	Email        string         `gorm:"type:citext;uniqueIndex;not null" json:"email" binding:"required,email,max=255"`
+	Username     string         `gorm:"type:citext;uniqueIndex;not null" json:"username" binding:"required,min=3,max=100"`
 	PasswordHash string         `gorm:"type:varchar(255);not null" json:"-"`
 	FirstName    string         `gorm:"type:varchar(100)" json:"first_name" binding:"required"`
 	LastName     string         `gorm:"type:varchar(100)" json:"last_name" binding:"required"`
@@ -21,54 +24,197 @@ type User struct {
 	IsVerified   bool           `gorm:"default:false" json:"is_verified"`
 	Role         string         `gorm:"type:varchar(50);default:'user'" json:"role"`
 	LastLoginAt  *time.Time     `json:"last_login_at"`
+	Version      int64          `gorm:"not null;default:1" json:"version"`
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Tokens issued before the password last changed are rejected
+	PasswordChangedAt *time.Time `json:"password_changed_at"`
+	// Lockout state, read uncached with the password hash
+	FailedLogins int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil  *time.Time `json:"-"`
+}
+
+// PasswordHistory is a password hash a user had before, kept so it is not
+// chosen again
+type PasswordHistory struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	UserID       uuid.UUID `gorm:"type:uuid;index;not null"`
+	PasswordHash string    `gorm:"type:varchar(255);not null"`
+	CreatedAt    time.Time
+	User         User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// EmailChange is a pending change of a user's email address, confirmed with
+// a token sent to the new address. Only the SHA-256 hash of the token is
+// stored; a user has at most one pending change.
+type EmailChange struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	UserID    uuid.UUID `gorm:"type:uuid;uniqueIndex;not null"`
+	NewEmail  string    `gorm:"type:citext;not null"`
+	TokenHash string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time
+	User      User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
 }
 
 // UserProfile represents additional user profile information
 type UserProfile struct {
-	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
-	UserID      uuid.UUID  `gorm:"type:uuid;uniqueIndex;not null" json:"user_id"`
-	Bio         string     `gorm:"type:text" json:"bio"`
-	DateOfBirth *time.Time `json:"date_of_birth"`
-	Country     string     `gorm:"type:varchar(100)" json:"country"`
-	City        string     `gorm:"type:varchar(100)" json:"city"`
-	Timezone    string     `gorm:"type:varchar(50)" json:"timezone"`
-	Language    string     `gorm:"type:varchar(10);default:'en'" json:"language"`
-	Preferences string     `gorm:"type:jsonb;default:'{}'" json:"preferences"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
-	User        User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	ID          uuid.UUID   `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	UserID      uuid.UUID   `gorm:"type:uuid;uniqueIndex;not null" json:"user_id"`
+	Bio         string      `gorm:"type:text" json:"bio"`
+	DateOfBirth *time.Time  `json:"date_of_birth"`
+	Country     string      `gorm:"type:varchar(100)" json:"country"`
+	City        string      `gorm:"type:varchar(100)" json:"city"`
+	Timezone    string      `gorm:"type:varchar(50)" json:"timezone"`
+	Language    string      `gorm:"type:varchar(10);default:'en'" json:"language"`
+	Preferences Preferences `gorm:"type:jsonb;default:'{}';index:idx_user_profiles_preferences,type:gin" json:"preferences"`
+	Version     int64       `gorm:"not null;default:1" json:"version"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	User        User        `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// Preferences is the JSON object of a user's preferences, stored as JSONB.
+// It holds the values the user chose; defaults are filled in on read.
+type Preferences json.RawMessage
+
+// MarshalJSON encodes empty preferences as {}
+func (p Preferences) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("{}"), nil
+	}
+	return p, nil
+}
+
+// UnmarshalJSON keeps a copy of the raw document
+func (p *Preferences) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[0:0], data...)
+	return nil
+}
+
+// Value implements driver.Valuer
+func (p Preferences) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "{}", nil
+	}
+	return string(p), nil
+}
+
+// Scan implements sql.Scanner
+func (p *Preferences) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		*p = append(Preferences(nil), v...)
+	case string:
+		*p = Preferences(v)
+	case nil:
+		*p = nil
+	default:
+		return fmt.Errorf("cannot scan %T into preferences", value)
+	}
+	return nil
 }
 
 // CreateUserRequest represents the request body for creating a user
 type CreateUserRequest struct {
-	Email     string `json:"email" binding:"required,email"`
+	Email     string `json:"email" binding:"required,email,max=255"`
 	Username  string `json:"username" binding:"required,min=3,max=100"`
-	Password  string `json:"password" binding:"required,min=8"`
+	Password  string `json:"password" binding:"required"` // checked against the password policy
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name" binding:"required"`
-	Phone     string `json:"phone"`
+	Phone     string `json:"phone" binding:"omitempty,e164_phone"`
+}
+
+// ChangePasswordRequest represents the request body for changing a
+// password. Users changing their own password give the current one;
+// admins resetting another user's password leave it out.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required"` // checked against the password policy
+}
+
+// VerifyCredentialsRequest represents the request body auth-service sends
+// to check a password. Login is an email address or a username.
+type VerifyCredentialsRequest struct {
+	Login    string `json:"login" binding:"required,max=255"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ChangeEmailRequest represents the request body for changing an email
+// address. Users changing their own email give their password; admins
+// changing another user's email leave it out.
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email" binding:"required,email,max=255"`
+	CurrentPassword string `json:"current_password"`
+}
+
+// ConfirmEmailRequest represents the request body confirming an email
+// change with the token sent to the new address
+type ConfirmEmailRequest struct {
+	Token string `json:"token" binding:"required,max=128"`
+}
+
+// EmailChangeResponse describes a pending email change
+type EmailChangeResponse struct {
+	NewEmail  string    `json:"new_email"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // UpdateUserRequest represents the request body for updating a user
 type UpdateUserRequest struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Phone     string `json:"phone"`
-	AvatarURL string `json:"avatar_url"`
+	FirstName string `json:"first_name" binding:"max=100"`
+	LastName  string `json:"last_name" binding:"max=100"`
+	Phone     string `json:"phone" binding:"omitempty,e164_phone"`
+	AvatarURL string `json:"avatar_url" binding:"omitempty,max=2048,avatar_url"`
 }
 
 // UpdateProfileRequest represents the request body for updating a user profile
 type UpdateProfileRequest struct {
-	Bio         string     `json:"bio"`
-	DateOfBirth *time.Time `json:"date_of_birth"`
-	Country     string     `json:"country"`
-	City        string     `json:"city"`
-	Timezone    string     `json:"timezone"`
-	Language    string     `json:"language"`
-	Preferences string     `json:"preferences"`
+	Bio         string     `json:"bio" binding:"max=5000"`
+	DateOfBirth *time.Time `json:"date_of_birth" binding:"omitempty,min_age"`
+	Country     string     `json:"country" binding:"omitempty,country_alpha2"`
+	City        string     `json:"city" binding:"max=100"`
+	Timezone    string     `json:"timezone" binding:"omitempty,max=50,iana_timezone"`
+	Language    string     `json:"language" binding:"omitempty,max=10,bcp47_tag"`
+	// Preferences replace the stored ones. A JSON string holding the
+	// document is accepted too, as clients used to send it that way.
+	Preferences json.RawMessage `json:"preferences"`
+}
+
+// SetPreferenceRequest represents the request body for setting a preference
+type SetPreferenceRequest struct {
+	Value json.RawMessage `json:"value" binding:"required"`
+}
+
+// PreferenceResponse represents a single preference and its current value
+type PreferenceResponse struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// PatchUserRequest lists the fields a JSON merge patch of a user may set,
+// with their validation rules. A null member clears a field unless it is
+// tagged patch:"notnull".
+type PatchUserRequest struct {
+	FirstName *string `json:"first_name" binding:"omitempty,min=1,max=100" patch:"notnull"`
+	LastName  *string `json:"last_name" binding:"omitempty,min=1,max=100" patch:"notnull"`
+	Phone     *string `json:"phone" binding:"omitempty,e164_phone"`
+	AvatarURL *string `json:"avatar_url" binding:"omitempty,max=2048,avatar_url"`
+}
+
+// PatchProfileRequest lists the fields a JSON merge patch of a user profile
+// may set. Preferences are merged into the stored document rather than
+// replacing it.
+type PatchProfileRequest struct {
+	Bio         *string         `json:"bio" binding:"omitempty,max=5000"`
+	DateOfBirth *time.Time      `json:"date_of_birth" binding:"omitempty,min_age"`
+	Country     *string         `json:"country" binding:"omitempty,country_alpha2"`
+	City        *string         `json:"city" binding:"omitempty,max=100"`
+	Timezone    *string         `json:"timezone" binding:"omitempty,max=50,iana_timezone"`
+	Language    *string         `json:"language" binding:"omitempty,max=10,bcp47_tag" patch:"notnull"`
+	Preferences json.RawMessage `json:"preferences" patch:"merge"`
 }
 
 // UserResponse represents the response for user operations
@@ -84,8 +230,18 @@ type UserResponse struct {
 	IsVerified  bool       `json:"is_verified"`
 	Role        string     `json:"role"`
 	LastLoginAt *time.Time `json:"last_login_at"`
+	Version     int64      `json:"version"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
+
+	PasswordChangedAt *time.Time `json:"password_changed_at"`
+}
+
+// UserStats holds aggregate user counts
+type UserStats struct {
+	Total    int64
+	Active   int64
+	Verified int64
 }
 
 // TableName overrides the table name for User model
@@ -98,6 +254,16 @@ func (UserProfile) TableName() string {
 	return "user_profiles"
 }
 
+// TableName overrides the table name for PasswordHistory model
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
+
+// TableName overrides the table name for EmailChange model
+func (EmailChange) TableName() string {
+	return "email_changes"
+}
+
 // ToResponse converts User model to UserResponse
 func (u *User) ToResponse() *UserResponse {
 	return &UserResponse{
@@ -112,7 +278,10 @@ func (u *User) ToResponse() *UserResponse {
 		IsVerified:  u.IsVerified,
 		Role:        u.Role,
 		LastLoginAt: u.LastLoginAt,
+		Version:     u.Version,
 		CreatedAt:   u.CreatedAt,
 		UpdatedAt:   u.UpdatedAt,
+
+		PasswordChangedAt: u.PasswordChangedAt,
 	}
 }
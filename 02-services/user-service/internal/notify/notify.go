@@ -0,0 +1,133 @@
+// Package notify sends notifications to users through notification-service
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Types of notification
+const (
+	EmailChangeRequested = "email_change_requested"
+	EmailChanged         = "email_changed"
+)
+
+// Notification is an email to a user. Data holds the values the message was
+// built from, such as a confirmation link, for templates to use instead.
+type Notification struct {
+	UserID  uuid.UUID
+	Type    string
+	Email   string
+	Subject string
+	Message string
+	Data    map[string]string
+}
+
+// Notifier delivers notifications
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// New returns a Client for notification-service at baseURL, or a Logger if
+// baseURL is empty
+func New(baseURL string, httpClient *http.Client, log *logrus.Logger) Notifier {
+	if baseURL == "" {
+		log.Warn("No notification service configured, notifications are only logged")
+		return NewLogger(log)
+	}
+	return NewClient(baseURL, httpClient)
+}
+
+// Client sends notifications to notification-service, which queues them
+// for delivery
+type Client struct {
+	url  string
+	http *http.Client
+}
+
+// NewClient creates a client for notification-service at baseURL
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	return &Client{
+		url:  strings.TrimSuffix(baseURL, "/") + "/api/v1/notifications/send",
+		http: httpClient,
+	}
+}
+
+// sendRequest is the body of notification-service's send endpoint, which
+// takes the recipient from data.email
+type sendRequest struct {
+	UserID  uuid.UUID         `json:"user_id"`
+	Type    string            `json:"type"`
+	Channel string            `json:"channel"`
+	Subject string            `json:"subject"`
+	Message string            `json:"message"`
+	Data    map[string]string `json:"data"`
+}
+
+// Notify queues n for delivery by email
+func (c *Client) Notify(ctx context.Context, n Notification) error {
+	data := map[string]string{"email": n.Email}
+	for key, value := range n.Data {
+		data[key] = value
+	}
+	body, err := json.Marshal(sendRequest{
+		UserID:  n.UserID,
+		Type:    n.Type,
+		Channel: "email",
+		Subject: n.Subject,
+		Message: n.Message,
+		Data:    data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send %s notification: %w", n.Type, err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("notification service returned %d for %s notification", res.StatusCode, n.Type)
+	}
+	return nil
+}
+
+// Logger logs notifications instead of sending them, for development
+// without notification-service. Messages may carry secrets such as
+// confirmation links, so they are only logged at debug level.
+type Logger struct {
+	log *logrus.Logger
+}
+
+// NewLogger creates a notifier logging to log
+func NewLogger(log *logrus.Logger) *Logger {
+	return &Logger{log: log}
+}
+
+// Notify logs n
+func (l *Logger) Notify(ctx context.Context, n Notification) error {
+	entry := l.log.WithContext(ctx).WithFields(logrus.Fields{
+		"user_id": n.UserID,
+		"type":    n.Type,
+	})
+	entry.Infof("Notification not sent: %s", n.Subject)
+	entry.Debugf("Notification to %s: %s", n.Email, n.Message)
+	return nil
+}
@@ -0,0 +1,156 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// prefixLength is the number of hex digits of a SHA-1 hash a corpus is
+// queried by, as in the Pwned Passwords range API
+const prefixLength = 5
+
+// Corpus looks passwords up in an offline copy of the Pwned Passwords list:
+// one "<SHA-1 in hex>:<count>" line per breached password, sorted by hash,
+// as the haveibeenpwned-downloader writes it. Lines without a count count
+// once. Like the online range API it is queried by the first five digits of
+// a hash (k-anonymity) and the suffixes are matched by Count. The file is
+// binary searched in place, as the full list is tens of gigabytes.
+type Corpus struct {
+	file *os.File
+	size int64
+}
+
+// OpenCorpus opens the corpus at path and checks its first line
+func OpenCorpus(path string) (*Corpus, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open breached password file: %w", err)
+	}
+
+	c := &Corpus{file: file, size: info.Size()}
+	if line, _, err := c.lineAt(0); err != nil || (line != "" && !validHash(hashOf(line))) {
+		file.Close()
+		return nil, fmt.Errorf("invalid breached password file %s: expected <SHA-1>:<count> lines", path)
+	}
+	return c, nil
+}
+
+// Close closes the corpus file
+func (c *Corpus) Close() error {
+	return c.file.Close()
+}
+
+// Count returns the number of breaches password was seen in
+func (c *Corpus) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := c.Range(hash[:prefixLength])
+	if err != nil {
+		return 0, err
+	}
+	return suffixes[hash[prefixLength:]], nil
+}
+
+// Range returns the breach count of every hash starting with prefix, five
+// upper-case hex digits, by the rest of the hash
+func (c *Corpus) Range(prefix string) (map[string]int, error) {
+	// Find the first line whose hash is not before prefix
+	lo, hi := int64(0), c.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, _, err := c.lineAt(mid)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" || hashOf(line) >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	_, start, err := c.lineAt(lo)
+	if err != nil {
+		return nil, err
+	}
+
+	suffixes := map[string]int{}
+	scanner := bufio.NewScanner(io.NewSectionReader(c.file, start, c.size-start))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		hash := hashOf(line)
+		if !strings.HasPrefix(hash, prefix) {
+			break
+		}
+		suffixes[hash[prefixLength:]] = countOf(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password file: %w", err)
+	}
+	return suffixes, nil
+}
+
+// lineAt returns the first line starting at or after offset and its
+// offset, or an empty line at the end of the file
+func (c *Corpus) lineAt(offset int64) (string, int64, error) {
+	start := offset
+	if offset > 0 {
+		// Start from the previous byte, so a line starting at offset is kept
+		start = offset - 1
+	}
+	reader := bufio.NewReader(io.NewSectionReader(c.file, start, c.size-start))
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return "", c.size, nil
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read breached password file: %w", err)
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, fmt.Errorf("failed to read breached password file: %w", err)
+	}
+	return strings.TrimSpace(line), start, nil
+}
+
+// hashOf returns the hash of a corpus line in upper case
+func hashOf(line string) string {
+	hash, _, _ := strings.Cut(line, ":")
+	return strings.ToUpper(hash)
+}
+
+// countOf returns the count of a corpus line, 1 if it has none
+func countOf(line string) int {
+	_, count, ok := strings.Cut(line, ":")
+	if !ok {
+		return 1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+func validHash(hash string) bool {
+	if len(hash) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
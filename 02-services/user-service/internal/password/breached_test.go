@@ -0,0 +1,190 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func writeCorpus(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// findPassword returns a password with the given prefix whose hash sorts
+// as accept requires
+func findPassword(t *testing.T, prefix string, accept func(hash string) bool) string {
+	t.Helper()
+	for i := 0; i < 100000; i++ {
+		password := fmt.Sprintf("%s-%d", prefix, i)
+		if accept(sha1Hex(password)) {
+			return password
+		}
+	}
+	t.Fatalf("no %s password found", prefix)
+	return ""
+}
+
+func TestCorpusCount(t *testing.T) {
+	// The corpus holds the hashes of breached-0..49, sorted, with a count
+	// equal to their index plus one; one line has no count
+	var lines []string
+	counts := map[string]int{}
+	for i := 0; i < 50; i++ {
+		password := fmt.Sprintf("breached-%d", i)
+		counts[password] = i + 1
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(password), i+1))
+	}
+	sort.Strings(lines)
+
+	first := strings.SplitN(lines[0], ":", 2)[0]
+	last := strings.SplitN(lines[len(lines)-1], ":", 2)[0]
+	var firstPassword, lastPassword string
+	for password := range counts {
+		switch sha1Hex(password) {
+		case first:
+			firstPassword = password
+		case last:
+			lastPassword = password
+		}
+	}
+	lines[len(lines)/2] = strings.SplitN(lines[len(lines)/2], ":", 2)[0]
+	var middlePassword string
+	for password := range counts {
+		if sha1Hex(password) == lines[len(lines)/2] {
+			middlePassword = password
+		}
+	}
+
+	beforeFirst := findPassword(t, "before", func(hash string) bool { return hash < first })
+	afterLast := findPassword(t, "after", func(hash string) bool { return hash > last })
+
+	for _, trailingNewline := range []bool{true, false} {
+		content := strings.Join(lines, "\r\n")
+		if trailingNewline {
+			content += "\r\n"
+		}
+
+		corpus, err := OpenCorpus(writeCorpus(t, content))
+		if err != nil {
+			t.Fatalf("OpenCorpus: %v", err)
+		}
+		t.Cleanup(func() { corpus.Close() })
+
+		tests := []struct {
+			name     string
+			password string
+			want     int
+		}{
+			{name: "first entry", password: firstPassword, want: counts[firstPassword]},
+			{name: "last entry", password: lastPassword, want: counts[lastPassword]},
+			{name: "entry without count", password: middlePassword, want: 1},
+			{name: "miss before first entry", password: beforeFirst},
+			{name: "miss after last entry", password: afterLast},
+			{name: "miss in between", password: "not-breached"},
+		}
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/trailing newline %v", tt.name, trailingNewline), func(t *testing.T) {
+				got, err := corpus.Count(tt.password)
+				if err != nil {
+					t.Fatalf("Count: %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("Count(%q) = %d, want %d", tt.password, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCorpusRange(t *testing.T) {
+	content := strings.Join([]string{
+		"00000" + strings.Repeat("0", 35) + ":7",
+		"00000" + strings.Repeat("A", 35) + ":2",
+		"00001" + strings.Repeat("0", 35) + ":1",
+		"8BE3C" + strings.Repeat("B", 35) + ":4",
+		"FFFFF" + strings.Repeat("E", 35) + ":3",
+		"FFFFF" + strings.Repeat("F", 35) + ":5",
+	}, "\n") + "\n"
+
+	corpus, err := OpenCorpus(writeCorpus(t, content))
+	if err != nil {
+		t.Fatalf("OpenCorpus: %v", err)
+	}
+	defer corpus.Close()
+
+	tests := []struct {
+		prefix string
+		want   map[string]int
+	}{
+		{prefix: "00000", want: map[string]int{strings.Repeat("0", 35): 7, strings.Repeat("A", 35): 2}},
+		{prefix: "00001", want: map[string]int{strings.Repeat("0", 35): 1}},
+		{prefix: "8BE3C", want: map[string]int{strings.Repeat("B", 35): 4}},
+		{prefix: "FFFFF", want: map[string]int{strings.Repeat("E", 35): 3, strings.Repeat("F", 35): 5}},
+		{prefix: "12345", want: map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := corpus.Range(tt.prefix)
+			if err != nil {
+				t.Fatalf("Range: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Range(%s) = %v, want %v", tt.prefix, got, tt.want)
+			}
+			for suffix, count := range tt.want {
+				if got[suffix] != count {
+					t.Errorf("Range(%s)[%s] = %d, want %d", tt.prefix, suffix, got[suffix], count)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenCorpus(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "empty", content: ""},
+		{name: "valid", content: sha1Hex("password") + ":10\n"},
+		{name: "lower case", content: strings.ToLower(sha1Hex("password")) + ":10\n"},
+		{name: "not hashes", content: "password\n123456\n", wantErr: true},
+		{name: "short hash", content: "5BAA6:10\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corpus, err := OpenCorpus(writeCorpus(t, tt.content))
+			if tt.wantErr {
+				if err == nil {
+					corpus.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenCorpus: %v", err)
+			}
+			corpus.Close()
+		})
+	}
+
+	if _, err := OpenCorpus(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing file: expected an error")
+	}
+}
@@ -0,0 +1,154 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashing algorithms
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// bcryptMaxBytes is the length bcrypt refuses passwords beyond
+const bcryptMaxBytes = 72
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Hasher hashes passwords with one algorithm and verifies hashes made with
+// either, so the algorithm and its parameters can change without resetting
+// passwords. Hashes are stored in their usual text form: $2a$... for bcrypt
+// and the PHC string $argon2id$v=19$m=...,t=...,p=...$salt$key for argon2id.
+type Hasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     argon2Params
+}
+
+// argon2Params are the parameters of an argon2id hash
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	keyLength   uint32
+}
+
+// NewBcrypt creates a Hasher using bcrypt with the given cost
+func NewBcrypt(cost int) (*Hasher, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("invalid bcrypt cost %d", cost)
+	}
+	return &Hasher{algorithm: Bcrypt, bcryptCost: cost}, nil
+}
+
+// NewArgon2id creates a Hasher using argon2id with memory KiB, the given
+// number of iterations and parallelism
+func NewArgon2id(memory, iterations, parallelism int) (*Hasher, error) {
+	if memory < 8*parallelism || iterations < 1 || parallelism < 1 || parallelism > 255 {
+		return nil, fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d", memory, iterations, parallelism)
+	}
+	return &Hasher{algorithm: Argon2id, argon2: argon2Params{
+		memory:      uint32(memory),
+		iterations:  uint32(iterations),
+		parallelism: uint8(parallelism),
+		keyLength:   argon2KeyLength,
+	}}, nil
+}
+
+// MaxBytes returns the longest password in bytes the algorithm can hash in
+// full, or 0 if there is no limit
+func (h *Hasher) MaxBytes() int {
+	if h.algorithm == Bcrypt {
+		return bcryptMaxBytes
+	}
+	return 0
+}
+
+// Hash hashes password with a random salt
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.algorithm == Bcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password: %w", err)
+		}
+		return string(hashed), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	p := h.argon2
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches hash, and if so whether hash was
+// made with another algorithm or other parameters than h uses and should
+// be replaced by a new one
+func (h *Hasher) Verify(hash, password string) (ok, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("failed to verify bcrypt hash: %w", err)
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, err != nil || h.algorithm != Bcrypt || cost != h.bcryptCost, nil
+
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, params.keyLength)
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+		return true, h.algorithm != Argon2id || params != h.argon2, nil
+	}
+	return false, false, errors.New("unsupported password hash")
+}
+
+// parseArgon2id decodes the parameters, salt and key of an argon2id hash
+func parseArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errors.New("malformed argon2id key")
+	}
+	p.keyLength = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,200 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parameters small enough to keep the tests fast
+const (
+	testMemory      = 64
+	testIterations  = 1
+	testParallelism = 1
+)
+
+func mustArgon2id(t *testing.T, memory, iterations, parallelism int) *Hasher {
+	t.Helper()
+	h, err := NewArgon2id(memory, iterations, parallelism)
+	if err != nil {
+		t.Fatalf("NewArgon2id: %v", err)
+	}
+	return h
+}
+
+func mustHash(t *testing.T, h *Hasher, password string) string {
+	t.Helper()
+	hash, err := h.Hash(password)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	return hash
+}
+
+func TestParseArgon2id(t *testing.T) {
+	const (
+		salt = "c2FsdHNhbHRzYWx0c2FsdA"
+		key  = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	)
+
+	tests := []struct {
+		name    string
+		hash    string
+		want    argon2Params
+		wantErr string
+	}{
+		{
+			name: "valid",
+			hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$" + key,
+			want: argon2Params{memory: 19456, iterations: 2, parallelism: 1, keyLength: 29},
+		},
+		{name: "missing key", hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt, wantErr: "malformed argon2id hash"},
+		{name: "extra segment", hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$" + key + "$", wantErr: "malformed argon2id hash"},
+		{name: "old version", hash: "$argon2id$v=16$m=19456,t=2,p=1$" + salt + "$" + key, wantErr: "unsupported argon2id version"},
+		{name: "no version", hash: "$argon2id$m=19456,t=2,p=1$" + salt + "$" + key + "$x", wantErr: "unsupported argon2id version"},
+		{name: "missing parameter", hash: "$argon2id$v=19$m=19456,t=2$" + salt + "$" + key, wantErr: "malformed argon2id parameters"},
+		{name: "non-numeric parameter", hash: "$argon2id$v=19$m=lots,t=2,p=1$" + salt + "$" + key, wantErr: "malformed argon2id parameters"},
+		{name: "bad salt", hash: "$argon2id$v=19$m=19456,t=2,p=1$not*base64$" + key, wantErr: "malformed argon2id salt"},
+		{name: "bad key", hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$not*base64", wantErr: "malformed argon2id key"},
+		{name: "empty key", hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$", wantErr: "malformed argon2id key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, _, _, err := parseArgon2id(tt.hash)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgon2id: %v", err)
+			}
+			if params != tt.want {
+				t.Errorf("got %+v, want %+v", params, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasherVerify(t *testing.T) {
+	const password = "correct horse battery staple"
+
+	argon := mustArgon2id(t, testMemory, testIterations, testParallelism)
+	bcryptHasher, err := NewBcrypt(bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("NewBcrypt: %v", err)
+	}
+	otherBcrypt, err := NewBcrypt(bcrypt.MinCost + 1)
+	if err != nil {
+		t.Fatalf("NewBcrypt: %v", err)
+	}
+
+	argonHash := mustHash(t, argon, password)
+	bcryptHash := mustHash(t, bcryptHasher, password)
+
+	tests := []struct {
+		name       string
+		hasher     *Hasher
+		hash       string
+		password   string
+		wantOK     bool
+		wantRehash bool
+		wantErr    bool
+	}{
+		{name: "argon2id current parameters", hasher: argon, hash: argonHash, password: password, wantOK: true},
+		{name: "argon2id wrong password", hasher: argon, hash: argonHash, password: "wrong"},
+		{
+			name:   "argon2id less memory",
+			hasher: argon, password: password, wantOK: true, wantRehash: true,
+			hash: mustHash(t, mustArgon2id(t, testMemory/2, testIterations, testParallelism), password),
+		},
+		{
+			name:   "argon2id fewer iterations",
+			hasher: mustArgon2id(t, testMemory, testIterations+1, testParallelism),
+			hash:   argonHash, password: password, wantOK: true, wantRehash: true,
+		},
+		{
+			name:   "argon2id other parallelism",
+			hasher: argon, password: password, wantOK: true, wantRehash: true,
+			hash: mustHash(t, mustArgon2id(t, testMemory, testIterations, 2), password),
+		},
+		{
+			name:   "argon2id shorter key",
+			hasher: argon, password: password, wantOK: true, wantRehash: true,
+			hash: func() string {
+				h := mustArgon2id(t, testMemory, testIterations, testParallelism)
+				h.argon2.keyLength = 16
+				return mustHash(t, h, password)
+			}(),
+		},
+		{name: "bcrypt hash with argon2id configured", hasher: argon, hash: bcryptHash, password: password, wantOK: true, wantRehash: true},
+		{name: "bcrypt hash wrong password", hasher: argon, hash: bcryptHash, password: "wrong"},
+		{name: "bcrypt current cost", hasher: bcryptHasher, hash: bcryptHash, password: password, wantOK: true},
+		{name: "bcrypt other cost", hasher: otherBcrypt, hash: bcryptHash, password: password, wantOK: true, wantRehash: true},
+		{name: "argon2id hash with bcrypt configured", hasher: bcryptHasher, hash: argonHash, password: password, wantOK: true, wantRehash: true},
+		{name: "malformed argon2id hash", hasher: argon, hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", password: password, wantErr: true},
+		{name: "malformed bcrypt hash", hasher: argon, hash: "$2a$04$short", password: password, wantErr: true},
+		{name: "unsupported hash", hasher: argon, hash: "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", password: password, wantErr: true},
+		{name: "plain text", hasher: argon, hash: password, password: password, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := tt.hasher.Verify(tt.hash, tt.password)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got no error, want one")
+				}
+				if ok {
+					t.Errorf("malformed hash verified")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("got (ok %v, rehash %v), want (ok %v, rehash %v)", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	h := mustArgon2id(t, testMemory, testIterations, testParallelism)
+	first := mustHash(t, h, "password")
+	second := mustHash(t, h, "password")
+
+	if !strings.HasPrefix(first, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash %q is not an argon2id PHC string with the configured parameters", first)
+	}
+	if first == second {
+		t.Error("two hashes of the same password are equal, salts are not random")
+	}
+
+	params, salt, key, err := parseArgon2id(first)
+	if err != nil {
+		t.Fatalf("parseArgon2id: %v", err)
+	}
+	if params != h.argon2 || len(salt) != argon2SaltLength || len(key) != argon2KeyLength {
+		t.Errorf("parsed %+v with %d byte salt and %d byte key", params, len(salt), len(key))
+	}
+}
+
+func TestNewHasherParameters(t *testing.T) {
+	for _, cost := range []int{bcrypt.MinCost - 1, bcrypt.MaxCost + 1} {
+		if _, err := NewBcrypt(cost); err == nil {
+			t.Errorf("NewBcrypt(%d): expected an error", cost)
+		}
+	}
+
+	invalid := [][3]int{{7, 1, 1}, {64, 0, 1}, {64, 1, 0}, {8 * 256, 1, 256}, {15, 1, 2}}
+	for _, p := range invalid {
+		if _, err := NewArgon2id(p[0], p[1], p[2]); err == nil {
+			t.Errorf("NewArgon2id(%d, %d, %d): expected an error", p[0], p[1], p[2])
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package password enforces the password policy, rejects passwords found in
+// breaches and reused passwords and hashes passwords with bcrypt or argon2id
+package password
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Manager checks new passwords against the policy and the breached password
+// corpus, hashes them and verifies them against stored hashes
+type Manager struct {
+	policy    Policy
+	hasher    *Hasher
+	breached  *Corpus
+	threshold int
+	lockout   Lockout
+	history   int
+	decoy     string
+	log       *logrus.Logger
+}
+
+// Lockout locks an account for Duration after Threshold consecutive wrong
+// passwords. A zero Threshold disables it.
+type Lockout struct {
+	Threshold int
+	Duration  time.Duration
+}
+
+// New creates a Manager from the password settings of cfg, opening the
+// breached password file if one is configured
+func New(cfg *config.Config, log *logrus.Logger) (*Manager, error) {
+	var hasher *Hasher
+	var err error
+	switch cfg.PasswordHashAlgorithm {
+	case Bcrypt:
+		hasher, err = NewBcrypt(cfg.PasswordBcryptCost)
+	case Argon2id:
+		hasher, err = NewArgon2id(cfg.PasswordArgon2Memory, cfg.PasswordArgon2Iterations, cfg.PasswordArgon2Parallelism)
+	default:
+		err = fmt.Errorf("unknown password hash algorithm %q", cfg.PasswordHashAlgorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		policy: Policy{
+			MinLength:       cfg.PasswordMinLength,
+			MaxLength:       cfg.PasswordMaxLength,
+			RequiredClasses: cfg.PasswordRequiredClasses,
+			ForbidPersonal:  cfg.PasswordForbidPersonal,
+		},
+		hasher:    hasher,
+		threshold: cfg.PasswordBreachedThreshold,
+		lockout: Lockout{
+			Threshold: cfg.PasswordLockoutThreshold,
+			Duration:  cfg.PasswordLockoutDuration,
+		},
+		history: cfg.PasswordHistorySize,
+		log:     log,
+	}
+
+	// Unknown users are checked against a decoy, so they take as long as
+	// real ones and cannot be told apart by timing
+	decoy := make([]byte, 16)
+	if _, err := rand.Read(decoy); err != nil {
+		return nil, fmt.Errorf("failed to generate decoy password: %w", err)
+	}
+	if m.decoy, err = hasher.Hash(hex.EncodeToString(decoy)); err != nil {
+		return nil, err
+	}
+
+	if cfg.PasswordBreachedFile != "" {
+		if m.breached, err = OpenCorpus(cfg.PasswordBreachedFile); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Validate checks a new password against the policy, the length limit of
+// the hash algorithm and the breached password corpus. personal holds the
+// username and email of its user.
+func (m *Manager) Validate(password string, personal ...string) error {
+	problems := m.policy.Check(password, personal...)
+	if max := m.hasher.MaxBytes(); max > 0 && len(password) > max {
+		problems = append(problems, apperrors.FieldError{Field: Field, Rule: "max_bytes", Param: strconv.Itoa(max)})
+	}
+
+	// Only passwords the policy accepts are worth looking up
+	if len(problems) == 0 && m.breached != nil {
+		count, err := m.breached.Count(password)
+		if err != nil {
+			return err
+		}
+		if count >= m.threshold {
+			problems = append(problems, apperrors.FieldError{Field: Field, Rule: "password_breached"})
+		}
+	}
+
+	if len(problems) > 0 {
+		return apperrors.Validation(apperrors.CodeValidation, "Invalid request data", problems...)
+	}
+	return nil
+}
+
+// Hash hashes a password for storage
+func (m *Manager) Hash(password string) (string, error) {
+	return m.hasher.Hash(password)
+}
+
+// Verify reports whether password matches hash. If it does but hash was made
+// with another algorithm or other parameters than configured, the password
+// is hashed again and handed to rehash to be stored. Rehashing failures are
+// only logged, as the password was verified all the same. An empty hash,
+// for a user who does not exist, never matches but takes as long to check.
+func (m *Manager) Verify(ctx context.Context, hash, password string, rehash func(hash string) error) (bool, error) {
+	if hash == "" {
+		_, _, err := m.hasher.Verify(m.decoy, password)
+		return false, err
+	}
+
+	ok, outdated, err := m.hasher.Verify(hash, password)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if outdated && rehash != nil {
+		upgraded, err := m.hasher.Hash(password)
+		if err == nil {
+			err = rehash(upgraded)
+		}
+		if err != nil {
+			m.log.WithContext(ctx).Warnf("Failed to rehash password: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// Reused reports whether password matches one of hashes, the current and
+// previous hashes of a user. Hashes in formats it cannot verify are skipped.
+func (m *Manager) Reused(password string, hashes []string) bool {
+	for _, hash := range hashes {
+		if ok, _, err := m.hasher.Verify(hash, password); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// HistorySize returns the number of recent passwords, the current one
+// included, a new password must differ from
+func (m *Manager) HistorySize() int {
+	return m.history
+}
+
+// Lockout returns the account lockout settings
+func (m *Manager) Lockout() Lockout {
+	return m.lockout
+}
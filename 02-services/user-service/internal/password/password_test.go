@@ -0,0 +1,159 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestManager(t *testing.T, algorithm, breachedFile string) *Manager {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	m, err := New(&config.Config{
+		PasswordMinLength:         10,
+		PasswordMaxLength:         128,
+		PasswordRequiredClasses:   []string{ClassLower, ClassDigit},
+		PasswordBreachedFile:      breachedFile,
+		PasswordBreachedThreshold: 2,
+		PasswordHashAlgorithm:     algorithm,
+		PasswordBcryptCost:        bcrypt.MinCost,
+		PasswordArgon2Memory:      testMemory,
+		PasswordArgon2Iterations:  testIterations,
+		PasswordArgon2Parallelism: testParallelism,
+	}, log)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return m
+}
+
+func TestManagerVerifyRehashesBcryptToArgon2id(t *testing.T) {
+	const password = "correct horse 1"
+	m := newTestManager(t, Argon2id, "")
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	ok, err := m.Verify(context.Background(), string(legacy), password, func(hash string) error {
+		stored = hash
+		return nil
+	})
+	if err != nil || !ok {
+		t.Fatalf("Verify(bcrypt hash) = %v, %v, want true", ok, err)
+	}
+	if !strings.HasPrefix(stored, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("rehashed to %q, want an argon2id hash with the configured parameters", stored)
+	}
+
+	// The new hash verifies and is not rehashed again
+	ok, err = m.Verify(context.Background(), stored, password, func(string) error {
+		t.Error("current hash was rehashed")
+		return nil
+	})
+	if err != nil || !ok {
+		t.Fatalf("Verify(rehashed) = %v, %v, want true", ok, err)
+	}
+
+	// Wrong passwords are never rehashed
+	ok, err = m.Verify(context.Background(), string(legacy), "wrong password 1", func(string) error {
+		t.Error("hash was rehashed for a wrong password")
+		return nil
+	})
+	if err != nil || ok {
+		t.Fatalf("Verify(wrong password) = %v, %v, want false", ok, err)
+	}
+}
+
+func TestManagerVerify(t *testing.T) {
+	const password = "correct horse 1"
+	m := newTestManager(t, Argon2id, "")
+	hash, err := m.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed rehash is logged, the password still verifies
+	legacy, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	ok, err := m.Verify(context.Background(), string(legacy), password, func(string) error {
+		return errors.New("database down")
+	})
+	if err != nil || !ok {
+		t.Errorf("Verify with failing rehash = %v, %v, want true", ok, err)
+	}
+
+	// Unknown users are checked against the decoy and never match
+	ok, err = m.Verify(context.Background(), "", password, nil)
+	if err != nil || ok {
+		t.Errorf("Verify(empty hash) = %v, %v, want false", ok, err)
+	}
+
+	if _, err := m.Verify(context.Background(), "$argon2id$v=19$broken", password, nil); err == nil {
+		t.Error("Verify(malformed hash): expected an error")
+	}
+
+	if !m.Reused(password, []string{"$unknown$", string(legacy)}) {
+		t.Error("Reused did not find the bcrypt hash")
+	}
+	if m.Reused("other password 2", []string{hash, string(legacy)}) {
+		t.Error("Reused matched another password")
+	}
+}
+
+func TestManagerValidate(t *testing.T) {
+	// breached-once was seen once, below the threshold of 2
+	lines := []string{sha1Hex("breached password 1") + ":3", sha1Hex("breached-once 1") + ":1"}
+	sort.Strings(lines)
+	corpus := writeCorpus(t, strings.Join(lines, "\n")+"\n")
+
+	tests := []struct {
+		name      string
+		algorithm string
+		password  string
+		wantRules []string
+	}{
+		{name: "valid", algorithm: Argon2id, password: "fresh password 1"},
+		{name: "breached", algorithm: Argon2id, password: "breached password 1", wantRules: []string{"password_breached"}},
+		{name: "below threshold", algorithm: Argon2id, password: "breached-once 1"},
+		{name: "policy before corpus", algorithm: Argon2id, password: "short 1", wantRules: []string{"min_length"}},
+		{name: "bcrypt byte limit", algorithm: Bcrypt, password: strings.Repeat("é", 37) + "1", wantRules: []string{"max_bytes"}},
+		{name: "argon2id has no byte limit", algorithm: Argon2id, password: strings.Repeat("é", 37) + "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, tt.algorithm, corpus)
+			err := m.Validate(tt.password, "someone", "someone@example.com")
+			if len(tt.wantRules) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+
+			var appErr *apperrors.Error
+			if !errors.As(err, &appErr) || !errors.Is(err, apperrors.ErrValidation) {
+				t.Fatalf("got error %v, want a validation error", err)
+			}
+			var rules []string
+			for _, field := range appErr.Fields {
+				rules = append(rules, field.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(tt.wantRules, ",") {
+				t.Errorf("got rules %v, want %v", rules, tt.wantRules)
+			}
+		})
+	}
+}
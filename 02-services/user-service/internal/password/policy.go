@@ -0,0 +1,89 @@
+package password
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+)
+
+// Field is the request member password problems are reported under
+const Field = "password"
+
+// minPersonalLength is the shortest username or email part a password may
+// not contain; shorter ones would rule out too many passwords
+const minPersonalLength = 3
+
+// Character classes a policy may require
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// Policy is what a new password must satisfy. Lengths are in characters.
+type Policy struct {
+	MinLength       int
+	MaxLength       int
+	RequiredClasses []string
+	ForbidPersonal  bool
+}
+
+// Check returns the rules password breaks. personal holds the username and
+// email of its user, which it may not contain if ForbidPersonal is set.
+func (p Policy) Check(password string, personal ...string) []apperrors.FieldError {
+	var problems []apperrors.FieldError
+	problem := func(rule, param string) {
+		problems = append(problems, apperrors.FieldError{Field: Field, Rule: rule, Param: param})
+	}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		problem("min_length", strconv.Itoa(p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		problem("max_length", strconv.Itoa(p.MaxLength))
+	}
+
+	for _, class := range p.RequiredClasses {
+		if !strings.ContainsFunc(password, classes[class]) {
+			problem("password_class", class)
+		}
+	}
+
+	if p.ForbidPersonal && containsPersonal(password, personal) {
+		problem("password_personal", "")
+	}
+	return problems
+}
+
+// classes tells the characters of each class apart
+var classes = map[string]func(rune) bool{
+	ClassLower: unicode.IsLower,
+	ClassUpper: unicode.IsUpper,
+	ClassDigit: unicode.IsDigit,
+	ClassSymbol: func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	},
+}
+
+// containsPersonal reports whether password contains, regardless of case,
+// one of the values in personal or the local part of an email among them
+func containsPersonal(password string, personal []string) bool {
+	lower := strings.ToLower(password)
+	for _, value := range personal {
+		value = strings.ToLower(value)
+		candidates := []string{value}
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			candidates = append(candidates, local)
+		}
+		for _, candidate := range candidates {
+			if len([]rune(candidate)) >= minPersonalLength && strings.Contains(lower, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
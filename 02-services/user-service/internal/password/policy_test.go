@@ -0,0 +1,73 @@
+package password
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := Policy{
+		MinLength:       10,
+		MaxLength:       20,
+		RequiredClasses: []string{ClassLower, ClassUpper, ClassDigit, ClassSymbol},
+		ForbidPersonal:  true,
+	}
+	personal := []string{"alice", "Alice.Smith@example.com"}
+
+	problem := func(rule, param string) apperrors.FieldError {
+		return apperrors.FieldError{Field: Field, Rule: rule, Param: param}
+	}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		personal []string
+		want     []apperrors.FieldError
+	}{
+		{name: "valid", policy: policy, password: "Tr0ub4dor&3x"},
+		{name: "too short", policy: policy, password: "Tr0ub&4", want: []apperrors.FieldError{problem("min_length", "10")}},
+		{name: "too long", policy: policy, password: "Tr0ub4dor&3-Tr0ub4dor&3", want: []apperrors.FieldError{problem("max_length", "20")}},
+		{name: "length in characters", policy: policy, password: "Éé1!Éé1!Éé"},
+		{
+			name: "missing classes", policy: policy, password: "troubadorxx",
+			want: []apperrors.FieldError{problem("password_class", ClassUpper), problem("password_class", ClassDigit), problem("password_class", ClassSymbol)},
+		},
+		{name: "non-ASCII letters count", policy: policy, password: "ÄÖÜäöü123!"},
+		{name: "username", policy: policy, password: "x1!ALICEx1!Y", want: []apperrors.FieldError{problem("password_personal", "")}},
+		{name: "email local part", policy: policy, password: "X1!alice.smith", want: []apperrors.FieldError{problem("password_personal", "")}},
+		{
+			name: "short personal values ignored", policy: policy, password: "Tr0ub4dor&3x",
+			personal: []string{"tr", "ub@example.com"},
+		},
+		{
+			name: "personal allowed", password: "x1!ALICEx1!Y",
+			policy: Policy{MinLength: 10, MaxLength: 20, RequiredClasses: policy.RequiredClasses},
+		},
+		{name: "no maximum", policy: Policy{MinLength: 1}, password: string(make([]rune, 500))},
+		{
+			name: "several problems", policy: policy, password: "alice",
+			want: []apperrors.FieldError{
+				problem("min_length", "10"),
+				problem("password_class", ClassUpper),
+				problem("password_class", ClassDigit),
+				problem("password_class", ClassSymbol),
+				problem("password_personal", ""),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.personal == nil {
+				tt.personal = personal
+			}
+			got := tt.policy.Check(tt.password, tt.personal...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %+v, want %+v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
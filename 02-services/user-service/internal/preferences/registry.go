@@ -0,0 +1,277 @@
+// Package preferences validates user preferences against a registered JSON
+// Schema and fills in the defaults it declares
+package preferences
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/pkg/mergepatch"
+)
+
+//go:embed schema.json
+var defaultSchema []byte
+
+// Fields reported in validation errors: the preferences member of a profile
+// update, under which problems are reported by path, and the value of a
+// single preference update. ParseValue reports problems under the key.
+const (
+	documentField = "preferences"
+	valueField    = "value"
+)
+
+// Registry holds the schema preferences are validated against. Documents
+// are stored without defaults, so changing a default in the schema applies
+// to every user who has not chosen a value.
+type Registry struct {
+	source   []byte
+	schema   *Schema
+	defaults map[string]interface{}
+}
+
+// Load creates a registry from the schema file at path, or from the built-in
+// schema if path is empty
+func Load(path string) (*Registry, error) {
+	if path == "" {
+		return NewRegistry(defaultSchema)
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preferences schema: %w", err)
+	}
+	return NewRegistry(source)
+}
+
+// NewRegistry creates a registry from a schema document
+func NewRegistry(source []byte) (*Registry, error) {
+	schema, err := parseSchema(source)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults, _ := schema.defaults().(map[string]interface{})
+	if defaults == nil {
+		defaults = map[string]interface{}{}
+	}
+	return &Registry{source: source, schema: schema, defaults: defaults}, nil
+}
+
+// Schema returns the schema document
+func (r *Registry) Schema() []byte {
+	return r.source
+}
+
+// Validate checks a preferences document against the schema
+func (r *Registry) Validate(doc []byte) error {
+	value, err := decode(doc)
+	if err != nil {
+		return apperrors.Validation(apperrors.CodeInvalidPreferences, "Preferences must be a JSON object",
+			apperrors.FieldError{Field: documentField, Rule: apperrors.RuleType, Param: "object"})
+	}
+	if problems := r.schema.validate(documentField, value); len(problems) > 0 {
+		return apperrors.Validation(apperrors.CodeInvalidPreferences, "Preferences do not match the schema", problems...)
+	}
+	return nil
+}
+
+// WithDefaults returns doc with the default of every preference it does not
+// set
+func (r *Registry) WithDefaults(doc []byte) ([]byte, error) {
+	value, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(withDefaults(value, r.defaults))
+}
+
+// Get returns the value of the preference key, a dotted path such as
+// notifications.email, falling back to its default. It returns null for a
+// preference that is neither set nor has a default.
+func (r *Registry) Get(doc []byte, key string) (json.RawMessage, error) {
+	path, _, err := r.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	found, _ := at(withDefaults(value, r.defaults), path)
+	return json.Marshal(found)
+}
+
+// Set returns doc with the preference key set to value, validated against
+// the schema
+func (r *Registry) Set(doc []byte, key string, value json.RawMessage) ([]byte, error) {
+	path, _, err := r.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build {"a": {"b": value}} and merge it, which creates parents as needed
+	patch := []byte(value)
+	if mergepatch.IsNull(value) {
+		return nil, apperrors.Validation(apperrors.CodeInvalidPreferences, "Preferences do not match the schema",
+			apperrors.FieldError{Field: valueField, Rule: apperrors.RuleNotNull})
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		name, _ := json.Marshal(path[i])
+		patch = []byte(fmt.Sprintf("{%s:%s}", name, patch))
+	}
+
+	updated, err := mergepatch.Apply(doc, patch)
+	if err != nil {
+		return nil, apperrors.Validation(apperrors.CodeInvalidPreferences, "Preference value must be JSON",
+			apperrors.FieldError{Field: valueField, Rule: apperrors.RuleSyntax})
+	}
+	if err := r.Validate(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Unset returns doc without the preference key, so its default applies
+func (r *Registry) Unset(doc []byte, key string) ([]byte, error) {
+	path, _, err := r.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := []byte("null")
+	for i := len(path) - 1; i >= 0; i-- {
+		name, _ := json.Marshal(path[i])
+		patch = []byte(fmt.Sprintf("{%s:%s}", name, patch))
+	}
+	return mergepatch.Apply(doc, patch)
+}
+
+// ParseValue converts the text form of a value of the preference key, as
+// found in a query string, to JSON: booleans and numbers are parsed, strings
+// are taken as is. It also reports whether the value is the preference's
+// default, which users who never set the preference have too.
+func (r *Registry) ParseValue(key, text string) (json.RawMessage, bool, error) {
+	_, schema, err := r.lookup(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var value interface{}
+	switch schema.Type {
+	case "boolean":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, false, apperrors.Validation(apperrors.CodeInvalidPreferences, "Invalid preference value",
+				apperrors.FieldError{Field: key, Rule: apperrors.RuleType, Param: "boolean"})
+		}
+		value = b
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, false, apperrors.Validation(apperrors.CodeInvalidPreferences, "Invalid preference value",
+				apperrors.FieldError{Field: key, Rule: apperrors.RuleType, Param: "number"})
+		}
+		value = json.Number(text)
+	case "string":
+		value = text
+	default:
+		return nil, false, apperrors.Validation(apperrors.CodeInvalidPreferences, "Invalid preference value",
+			apperrors.FieldError{Field: key, Rule: "scalar"})
+	}
+
+	if problems := schema.validate(key, value); len(problems) > 0 {
+		return nil, false, apperrors.Validation(apperrors.CodeInvalidPreferences, "Invalid preference value", problems...)
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, false, err
+	}
+	return raw, schema.Default != nil && equal(schema.Default, value), nil
+}
+
+// Path splits a dotted preference key into property names
+func Path(key string) []string {
+	return strings.Split(key, ".")
+}
+
+// lookup returns the path and schema of the preference key
+func (r *Registry) lookup(key string) ([]string, *Schema, error) {
+	path := Path(key)
+	schema := r.schema
+	for _, name := range path {
+		property, ok := schema.Properties[name]
+		if !ok {
+			return nil, nil, apperrors.NotFound(apperrors.CodePreferenceNotFound, fmt.Sprintf("Unknown preference %q", key))
+		}
+		schema = property
+	}
+	return path, schema, nil
+}
+
+// decode decodes a preferences document, treating an empty one as {}
+func decode(doc []byte) (map[string]interface{}, error) {
+	if len(bytes.TrimSpace(doc)) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid preferences: %w", err)
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("preferences must be a JSON object")
+	}
+	return object, nil
+}
+
+// withDefaults fills the members of value missing from defaults,
+// recursively for nested objects
+func withDefaults(value, defaults interface{}) interface{} {
+	defaultObject, ok := defaults.(map[string]interface{})
+	if !ok {
+		if value == nil {
+			return defaults
+		}
+		return value
+	}
+	if value == nil {
+		value = map[string]interface{}{}
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+
+	merged := make(map[string]interface{}, len(object)+len(defaultObject))
+	for name, v := range object {
+		merged[name] = v
+	}
+	for name, d := range defaultObject {
+		merged[name] = withDefaults(merged[name], d)
+	}
+	return merged
+}
+
+// at returns the member of a decoded document at path
+func at(value interface{}, path []string) (interface{}, bool) {
+	for _, name := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,310 @@
+package preferences
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/i18n"
+)
+
+// keyPattern restricts property names so dotted keys are unambiguous
+var keyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Schema is the subset of JSON Schema used to describe preferences: types,
+// properties, additionalProperties (boolean), required, enum, default,
+// minimum and maximum, minLength, maxLength and pattern, and items,
+// minItems and maxItems. Other keywords are rejected, so a schema never
+// silently enforces less than it appears to.
+type Schema struct {
+	Schema      string        `json:"$schema,omitempty"`
+	ID          string        `json:"$id,omitempty"`
+	Title       string        `json:"title,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Examples    []interface{} `json:"examples,omitempty"`
+
+	Type                 string             `json:"type"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// parseSchema decodes and checks a schema document
+func parseSchema(source []byte) (*Schema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+
+	var schema Schema
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("invalid preferences schema: %w", err)
+	}
+	if schema.Type != "object" {
+		return nil, fmt.Errorf("invalid preferences schema: root type must be object")
+	}
+	if err := schema.compile(""); err != nil {
+		return nil, fmt.Errorf("invalid preferences schema: %w", err)
+	}
+	return &schema, nil
+}
+
+// compile checks a schema and its subschemas and compiles their patterns
+func (s *Schema) compile(path string) error {
+	at := func(format string, args ...interface{}) error {
+		if path == "" {
+			return fmt.Errorf(format, args...)
+		}
+		return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case "object", "array", "string", "integer", "number", "boolean", "null":
+	case "":
+		return at("type is required")
+	default:
+		return at("unsupported type %q", s.Type)
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return at("invalid pattern: %v", err)
+		}
+		s.pattern = pattern
+	}
+
+	for name, property := range s.Properties {
+		if !keyPattern.MatchString(name) {
+			return at("property name %q must match %s", name, keyPattern)
+		}
+		if err := property.compile(join(path, name)); err != nil {
+			return err
+		}
+	}
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			return at("required property %q is not defined", name)
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(path + "[]"); err != nil {
+			return err
+		}
+	}
+
+	if s.Default != nil {
+		if problems := s.validate(path, s.Default); len(problems) > 0 {
+			return at("default does not match the schema: %s", describe(problems))
+		}
+	}
+	for _, value := range s.Enum {
+		if problems := s.validate(path, value); len(problems) > 0 {
+			return at("enum value does not match the schema: %s", describe(problems))
+		}
+	}
+	return nil
+}
+
+// validate returns the problems found with value at path
+func (s *Schema) validate(path string, value interface{}) []apperrors.FieldError {
+	problem := func(rule, param string) apperrors.FieldError {
+		return apperrors.FieldError{Field: path, Rule: rule, Param: param}
+	}
+
+	if !hasType(value, s.Type) {
+		return []apperrors.FieldError{problem(apperrors.RuleType, s.Type)}
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, value) {
+		return []apperrors.FieldError{problem("enum", formatValues(s.Enum))}
+	}
+
+	var problems []apperrors.FieldError
+	switch v := value.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			switch {
+			case ok:
+				problems = append(problems, property.validate(join(path, name), v[name])...)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				problems = append(problems, apperrors.FieldError{Field: join(path, name), Rule: apperrors.RuleUnknownField})
+			}
+		}
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				problems = append(problems, apperrors.FieldError{Field: join(path, name), Rule: "required"})
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			problems = append(problems, problem("min_items", strconv.Itoa(*s.MinItems)))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			problems = append(problems, problem("max_items", strconv.Itoa(*s.MaxItems)))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				problems = append(problems, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			problems = append(problems, problem("min_length", strconv.Itoa(*s.MinLength)))
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			problems = append(problems, problem("max_length", strconv.Itoa(*s.MaxLength)))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			problems = append(problems, problem("pattern", s.Pattern))
+		}
+	case json.Number:
+		n, _ := v.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			problems = append(problems, problem("min", fmt.Sprint(*s.Minimum)))
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			problems = append(problems, problem("max", fmt.Sprint(*s.Maximum)))
+		}
+	}
+	return problems
+}
+
+// defaults returns the document of every default value in the schema
+func (s *Schema) defaults() interface{} {
+	if s.Type != "object" || s.Default != nil {
+		return s.Default
+	}
+
+	values := map[string]interface{}{}
+	for name, property := range s.Properties {
+		if value := property.defaults(); value != nil {
+			values[name] = value
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// hasType reports whether a decoded JSON value is of a JSON Schema type
+func hasType(value interface{}, schemaType string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return schemaType == "object"
+	case []interface{}:
+		return schemaType == "array"
+	case string:
+		return schemaType == "string"
+	case bool:
+		return schemaType == "boolean"
+	case nil:
+		return schemaType == "null"
+	case json.Number:
+		if schemaType == "number" {
+			return true
+		}
+		n, err := v.Float64()
+		return schemaType == "integer" && err == nil && n == math.Trunc(n)
+	}
+	return false
+}
+
+// equal compares decoded JSON values, numbers by value
+func equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aErr := av.Float64()
+		bf, bErr := bv.Float64()
+		return aErr == nil && bErr == nil && af == bf
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for name, value := range av {
+			if other, ok := bv[name]; !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatValues(values []interface{}) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		data, _ := json.Marshal(value)
+		formatted[i] = string(data)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// describe joins problems in English, for schema errors
+func describe(problems []apperrors.FieldError) string {
+	described := make([]string, len(problems))
+	for i, problem := range problems {
+		described[i] = i18n.FieldMessage(i18n.Default, problem)
+		if problem.Field != "" {
+			described[i] = problem.Field + ": " + described[i]
+		}
+	}
+	return strings.Join(described, "; ")
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
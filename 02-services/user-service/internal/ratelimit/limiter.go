@@ -0,0 +1,306 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+)
+
+// Request carries the attributes of an HTTP request used to pick and key a
+// rule. UserID, Role and APIKey are set once the auth layer authenticated
+// them; APIKey identifies the key, it is not the secret.
+type Request struct {
+	Method   string
+	Route    string
+	ClientIP string
+	UserID   string
+	Role     string
+	APIKey   string
+}
+
+// Result is the outcome of a rate limit check
+type Result struct {
+	Exempt bool
+	Rule   string
+	limiter.Context
+}
+
+// Limiter applies a hot-reloadable rate limit policy
+type Limiter struct {
+	store    limiter.Store
+	fallback *Policy
+	path     string
+	log      *logrus.Logger
+
+	mu      sync.RWMutex
+	active  *compiledPolicy
+	modTime time.Time
+}
+
+type compiledPolicy struct {
+	policy   *Policy
+	ip       compiledRule
+	rules    []compiledRule
+	fallback compiledRule
+	cidrs    []*net.IPNet
+}
+
+type compiledRule struct {
+	rule     Rule
+	instance *limiter.Limiter
+}
+
+// NewLimiter creates a limiter for the policy file at path. When path is
+// empty the fallback policy is used and reloading is a no-op.
+func NewLimiter(path string, fallback *Policy, log *logrus.Logger) (*Limiter, error) {
+	if err := fallback.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid default rate limit policy: %w", err)
+	}
+
+	l := &Limiter{
+		store:    memory.NewStore(),
+		fallback: fallback,
+		path:     path,
+		log:      log,
+	}
+
+	if path == "" {
+		l.active = l.compile(fallback)
+		return l, nil
+	}
+
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// SetDefault replaces the fallback policy, e.g. after RATE_LIMIT_* settings
+// were reloaded. With a policy file the file is re-read so its default rule
+// picks up the new fallback values.
+func (l *Limiter) SetDefault(fallback *Policy) error {
+	if err := fallback.Validate(); err != nil {
+		return fmt.Errorf("invalid default rate limit policy: %w", err)
+	}
+
+	if l.path != "" {
+		l.mu.Lock()
+		previous := l.fallback
+		l.fallback = fallback
+		l.mu.Unlock()
+
+		if err := l.Reload(); err != nil {
+			l.mu.Lock()
+			l.fallback = previous
+			l.mu.Unlock()
+			return err
+		}
+		return nil
+	}
+
+	compiled := l.compile(fallback)
+
+	l.mu.Lock()
+	l.fallback = fallback
+	l.active = compiled
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Reload re-reads the policy file. The active policy is kept if the new one is invalid.
+func (l *Limiter) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat rate limit policy: %w", err)
+	}
+
+	l.mu.RLock()
+	fallback := l.fallback
+	l.mu.RUnlock()
+
+	policy, err := LoadPolicyFile(l.path, fallback)
+	if err != nil {
+		return err
+	}
+
+	compiled := l.compile(policy)
+
+	l.mu.Lock()
+	l.active = compiled
+	l.modTime = info.ModTime()
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Watch polls the policy file every interval and reloads it when it changes,
+// until ctx is cancelled
+func (l *Limiter) Watch(ctx context.Context, interval time.Duration) {
+	if l.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(l.path)
+			if err != nil {
+				l.log.Warnf("Failed to stat rate limit policy: %v", err)
+				continue
+			}
+
+			l.mu.RLock()
+			changed := !info.ModTime().Equal(l.modTime)
+			l.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := l.Reload(); err != nil {
+				l.log.Errorf("Failed to reload rate limit policy, keeping previous policy: %v", err)
+				continue
+			}
+			l.log.Infof("Rate limit policy reloaded from %s", l.path)
+		}
+	}
+}
+
+// Policy returns the active policy
+func (l *Limiter) Policy() *Policy {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.active.policy
+}
+
+// AllowIP counts the request against the IP rule, before authentication,
+// and reports whether the limit has been reached. Only exempt routes and
+// CIDRs apply, as the role is not known yet.
+func (l *Limiter) AllowIP(ctx context.Context, req Request) (Result, error) {
+	l.mu.RLock()
+	active := l.active
+	l.mu.RUnlock()
+
+	req.UserID, req.Role, req.APIKey = "", "", ""
+	if active.exempt(req) {
+		return Result{Exempt: true}, nil
+	}
+	return active.ip.allow(ctx, req)
+}
+
+// Allow counts the authenticated request against the first matching rule
+// and reports whether the limit has been reached
+func (l *Limiter) Allow(ctx context.Context, req Request) (Result, error) {
+	l.mu.RLock()
+	active := l.active
+	l.mu.RUnlock()
+
+	if active.exempt(req) {
+		return Result{Exempt: true}, nil
+	}
+	return active.match(req).allow(ctx, req)
+}
+
+// allow counts the request in its bucket of the rule
+func (r compiledRule) allow(ctx context.Context, req Request) (Result, error) {
+	key := r.rule.Name + ":" + identity(r.rule.Key, req)
+
+	lctx, err := r.instance.Get(ctx, key)
+	if err != nil {
+		return Result{}, fmt.Errorf("rate limit check failed: %w", err)
+	}
+
+	return Result{Rule: r.rule.Name, Context: lctx}, nil
+}
+
+// compile builds limiter instances for a policy. All instances share the
+// same store so counters survive reloads of unchanged rules.
+func (l *Limiter) compile(policy *Policy) *compiledPolicy {
+	newRule := func(rule Rule) compiledRule {
+		rate := limiter.Rate{Period: rule.Window, Limit: rule.Requests}
+		return compiledRule{rule: rule, instance: limiter.New(l.store, rate)}
+	}
+
+	compiled := &compiledPolicy{
+		policy:   policy,
+		ip:       newRule(policy.IP),
+		fallback: newRule(policy.Default),
+	}
+
+	for _, rule := range policy.Rules {
+		compiled.rules = append(compiled.rules, newRule(rule))
+	}
+
+	for _, cidr := range policy.Exempt.CIDRs {
+		// Already validated by Policy.Validate
+		_, network, _ := net.ParseCIDR(cidr)
+		compiled.cidrs = append(compiled.cidrs, network)
+	}
+
+	return compiled
+}
+
+func (p *compiledPolicy) exempt(req Request) bool {
+	if matchRoute(p.policy.Exempt.Routes, req.Route) {
+		return true
+	}
+	if req.Role != "" && contains(p.policy.Exempt.Roles, req.Role) {
+		return true
+	}
+
+	if ip := net.ParseIP(req.ClientIP); ip != nil {
+		for _, network := range p.cidrs {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *compiledPolicy) match(req Request) compiledRule {
+	for _, rule := range p.rules {
+		if rule.rule.matches(req) {
+			return rule
+		}
+	}
+	return p.fallback
+}
+
+// identity returns the bucket key for a request, falling back to the
+// client IP when the requested identity is not available
+func identity(key KeyType, req Request) string {
+	switch key {
+	case KeyUser:
+		if req.UserID != "" {
+			return "user:" + req.UserID
+		}
+	case KeyRole:
+		if req.Role != "" {
+			return "role:" + req.Role
+		}
+	case KeyAPIKey:
+		if req.APIKey != "" {
+			return "api_key:" + req.APIKey
+		}
+	}
+	return "ip:" + req.ClientIP
+}
@@ -0,0 +1,229 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KeyType selects which identity a rule counts requests against
+type KeyType string
+
+const (
+	// KeyIP counts requests per client IP
+	KeyIP KeyType = "ip"
+	// KeyUser counts requests per authenticated user_id, falling back to IP
+	KeyUser KeyType = "user"
+	// KeyRole counts requests per authenticated role, falling back to IP
+	KeyRole KeyType = "role"
+	// KeyAPIKey counts requests per API key the auth layer authenticated,
+	// falling back to IP
+	KeyAPIKey KeyType = "api_key"
+)
+
+// Policy describes the rate limits applied to incoming requests. Every
+// request that is not exempt first counts against the IP rule, before
+// authentication, so requests with missing or invalid credentials are
+// limited too. Authenticated requests then count against the first
+// matching rule, or the default rule.
+type Policy struct {
+	IP      Rule       `yaml:"ip"`
+	Default Rule       `yaml:"default"`
+	Rules   []Rule     `yaml:"rules"`
+	Exempt  Exemptions `yaml:"exempt"`
+}
+
+// Rule limits the requests matching its route, method and role selectors.
+// Empty selectors match everything.
+type Rule struct {
+	Name     string        `yaml:"name"`
+	Routes   []string      `yaml:"routes"`
+	Methods  []string      `yaml:"methods"`
+	Roles    []string      `yaml:"roles"`
+	Key      KeyType       `yaml:"key"`
+	Requests int64         `yaml:"requests"`
+	Window   time.Duration `yaml:"window"`
+}
+
+// Exemptions lists requests that are never rate limited
+type Exemptions struct {
+	Routes []string `yaml:"routes"`
+	CIDRs  []string `yaml:"cidrs"`
+	Roles  []string `yaml:"roles"`
+}
+
+// DefaultPolicy returns a policy limiting each client IP to requests per
+// window, before and after authentication, that exempts the health probes
+// and the metrics endpoint
+func DefaultPolicy(requests int64, window time.Duration) *Policy {
+	return &Policy{
+		IP: Rule{
+			Name:     "ip",
+			Key:      KeyIP,
+			Requests: requests,
+			Window:   window,
+		},
+		Default: Rule{
+			Name:     "default",
+			Key:      KeyIP,
+			Requests: requests,
+			Window:   window,
+		},
+		Exempt: Exemptions{
+			Routes: []string{"/health", "/health/*", "/metrics"},
+		},
+	}
+}
+
+// LoadPolicyFile reads a YAML policy from path. Fields missing from the IP
+// and default rules are taken from fallback.
+func LoadPolicyFile(path string, fallback *Policy) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit policy: %w", err)
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse rate limit policy %s: %w", path, err)
+	}
+
+	policy.IP.fill(fallback.IP)
+	policy.Default.fill(fallback.Default)
+
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rate limit policy %s: %w", path, err)
+	}
+
+	return &policy, nil
+}
+
+// fill sets the name, key, requests and window missing from r to those of
+// fallback
+func (r *Rule) fill(fallback Rule) {
+	if r.Name == "" {
+		r.Name = fallback.Name
+	}
+	if r.Key == "" {
+		r.Key = fallback.Key
+	}
+	if r.Requests == 0 {
+		r.Requests = fallback.Requests
+	}
+	if r.Window == 0 {
+		r.Window = fallback.Window
+	}
+}
+
+// Validate checks that every rule is usable and every exemption parses
+func (p *Policy) Validate() error {
+	if p.IP.Name == "" {
+		return fmt.Errorf("ip rule: name is required")
+	}
+	if p.IP.Name == p.Default.Name {
+		return fmt.Errorf("ip rule %q: duplicate name", p.IP.Name)
+	}
+	names := map[string]bool{p.IP.Name: true, p.Default.Name: true}
+
+	// Nothing but the client IP is known before authentication
+	if err := p.IP.validate(); err != nil {
+		return fmt.Errorf("ip rule: %w", err)
+	}
+	if p.IP.Key != KeyIP || len(p.IP.Routes) > 0 || len(p.IP.Methods) > 0 || len(p.IP.Roles) > 0 {
+		return fmt.Errorf("ip rule: must be keyed on ip and apply to every route, method and role")
+	}
+
+	if err := p.Default.validate(); err != nil {
+		return fmt.Errorf("default rule: %w", err)
+	}
+
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+
+	for _, cidr := range p.Exempt.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("exempt cidr %q: %w", cidr, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if r.Key == "" {
+		r.Key = KeyIP
+	}
+
+	switch r.Key {
+	case KeyIP, KeyUser, KeyRole, KeyAPIKey:
+	default:
+		return fmt.Errorf("unknown key %q", r.Key)
+	}
+
+	if r.Requests <= 0 {
+		return fmt.Errorf("requests must be positive")
+	}
+	if r.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+
+	for i, method := range r.Methods {
+		r.Methods[i] = strings.ToUpper(method)
+	}
+
+	return nil
+}
+
+// matches reports whether the rule applies to the request
+func (r *Rule) matches(req Request) bool {
+	if len(r.Routes) > 0 && !matchRoute(r.Routes, req.Route) {
+		return false
+	}
+	if len(r.Methods) > 0 && !contains(r.Methods, req.Method) {
+		return false
+	}
+	if len(r.Roles) > 0 && !contains(r.Roles, req.Role) {
+		return false
+	}
+	return true
+}
+
+// matchRoute matches a route against exact patterns or prefixes ending in "*"
+func matchRoute(patterns []string, route string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(route, prefix) {
+				return true
+			}
+			continue
+		}
+		if route == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation
+const uniqueViolation = "23505"
+
+func errUserNotFound() error {
+	return apperrors.NotFound(apperrors.CodeUserNotFound, "User not found")
+}
+
+func errProfileNotFound() error {
+	return apperrors.NotFound(apperrors.CodeProfileNotFound, "Profile not found")
+}
+
+func errEmailChangeNotFound() error {
+	return apperrors.NotFound(apperrors.CodeNotFound, "Email change not found")
+}
+
+func errUserVersion() error {
+	return apperrors.PreconditionFailed(apperrors.CodeVersionMismatch, "User was modified by another request")
+}
+
+func errProfileVersion() error {
+	return apperrors.PreconditionFailed(apperrors.CodeVersionMismatch, "Profile was modified by another request")
+}
+
+// errDuplicate reports a violation of the unique constraint named
+// constraint. The constraints on users are idx_users_email when created by
+// AutoMigrate and users_email_key when created by scripts/init-db.sql.
+func errDuplicate(constraint string) *apperrors.Error {
+	switch column := constraintColumn(constraint); column {
+	case "email":
+		return apperrors.Conflict(apperrors.CodeEmailExists, "Email already registered")
+	case "username":
+		return apperrors.Conflict(apperrors.CodeUsernameExists, "Username already taken")
+	default:
+		return apperrors.Conflict(apperrors.CodeConflict, "A record with the same "+column+" already exists")
+	}
+}
+
+// dbError translates a database error into an apperrors kind. notFound
+// replaces gorm.ErrRecordNotFound; cancellations are returned unchanged.
+func dbError(err error, notFound func() error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound) && notFound != nil:
+		return notFound()
+	case errors.Is(err, context.Canceled):
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == uniqueViolation:
+			return errDuplicate(pgErr.ConstraintName).Wrap(err)
+		// Connection exceptions, insufficient resources and operator intervention
+		case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"), strings.HasPrefix(pgErr.Code, "57"):
+			return apperrors.Unavailable(err)
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || pgconn.Timeout(err) || errors.As(err, &netErr) {
+		return apperrors.Unavailable(err)
+	}
+
+	return err
+}
+
+// constraintColumn names the column of a unique constraint such as
+// idx_users_email or users_email_key
+func constraintColumn(constraint string) string {
+	parts := strings.Split(strings.TrimSuffix(constraint, "_key"), "_")
+	if len(parts) < 2 {
+		return "value"
+	}
+	return parts[len(parts)-1]
+}
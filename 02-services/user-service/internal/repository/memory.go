@@ -0,0 +1,610 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// MemoryStore is an in-memory UserStore for tests and local development. It
+// mirrors the constraints and column defaults of the Postgres schema.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	users    map[uuid.UUID]*models.User
+	profiles map[uuid.UUID]*models.UserProfile
+	history  map[uuid.UUID][]string            // previous password hashes, newest first
+	emails   map[uuid.UUID]*models.EmailChange // pending email changes by user
+
+	userSchema    *schema.Schema
+	profileSchema *schema.Schema
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	cache := &sync.Map{}
+	userSchema, err := schema.Parse(&models.User{}, cache, schema.NamingStrategy{})
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse user schema: %v", err))
+	}
+	profileSchema, err := schema.Parse(&models.UserProfile{}, cache, schema.NamingStrategy{})
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse user profile schema: %v", err))
+	}
+
+	return &MemoryStore{
+		users:         map[uuid.UUID]*models.User{},
+		profiles:      map[uuid.UUID]*models.UserProfile{},
+		history:       map[uuid.UUID][]string{},
+		emails:        map[uuid.UUID]*models.EmailChange{},
+		userSchema:    userSchema,
+		profileSchema: profileSchema,
+	}
+}
+
+// Create creates a new user and its profile
+func (s *MemoryStore) Create(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.checkUnique(uuid.Nil, user.Email, user.Username); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.ID = uuid.New()
+	user.Version = 1
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	// Zero values are replaced by the column defaults, as GORM omits them
+	if user.Role == "" {
+		user.Role = "user"
+	}
+	user.IsActive = true
+
+	stored := *user
+	s.users[user.ID] = &stored
+	s.profiles[user.ID] = &models.UserProfile{
+		ID:          uuid.New(),
+		UserID:      user.ID,
+		Language:    "en",
+		Preferences: models.Preferences("{}"),
+		Version:     1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	return nil
+}
+
+// FindByID finds a user by ID
+func (s *MemoryStore) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return s.findUser(ctx, func(u *models.User) bool { return u.ID == id })
+}
+
+// FindByEmail finds a user by email
+func (s *MemoryStore) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.findUser(ctx, func(u *models.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+// FindByUsername finds a user by username
+func (s *MemoryStore) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return s.findUser(ctx, func(u *models.User) bool { return strings.EqualFold(u.Username, username) })
+}
+
+// List retrieves users matching filters with pagination, oldest first
+func (s *MemoryStore) List(ctx context.Context, page, limit int, filters ...PreferenceFilter) ([]models.User, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	users := s.active()
+	if len(filters) > 0 {
+		matching := users[:0]
+		for _, user := range users {
+			ok, err := s.matchPreferences(user.ID, filters)
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok {
+				matching = append(matching, user)
+			}
+		}
+		users = matching
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].ID.String() < users[j].ID.String()
+	})
+
+	total := int64(len(users))
+	offset := (page - 1) * limit
+	if offset >= len(users) {
+		return []models.User{}, total, nil
+	}
+	end := offset + limit
+	if end > len(users) {
+		end = len(users)
+	}
+
+	return users[offset:end], total, nil
+}
+
+// Update updates a user if it is at version, or any version for AnyVersion
+func (s *MemoryStore) Update(ctx context.Context, id uuid.UUID, version int64, updates map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	user, err := s.liveUser(id, version)
+	if user == nil {
+		return err
+	}
+
+	updated := *user
+	if err := setColumns(ctx, s.userSchema, &updated, updates); err != nil {
+		return err
+	}
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+	if err := s.checkUnique(id, updated.Email, updated.Username); err != nil {
+		return err
+	}
+
+	s.users[id] = &updated
+	return nil
+}
+
+// RehashPassword replaces the password hash of a user if it is still current
+func (s *MemoryStore) RehashPassword(ctx context.Context, id uuid.UUID, current, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	user, _ := s.liveUser(id, AnyVersion)
+	if user == nil || user.PasswordHash != current {
+		return nil
+	}
+	updated := *user
+	updated.PasswordHash = hash
+	s.users[id] = &updated
+	return nil
+}
+
+// FindCredentials finds a user by ID with the password hash and lockout state
+func (s *MemoryStore) FindCredentials(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return s.FindByID(ctx, id)
+}
+
+// RecordLoginFailure counts a wrong password, locking the user for lockFor
+// at threshold consecutive failures
+func (s *MemoryStore) RecordLoginFailure(ctx context.Context, id uuid.UUID, threshold int, lockFor time.Duration) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	user, _ := s.liveUser(id, AnyVersion)
+	if user == nil {
+		return nil, nil
+	}
+	updated := *user
+	updated.FailedLogins++
+	var lockedUntil *time.Time
+	if updated.FailedLogins >= threshold {
+		until := time.Now().Add(lockFor)
+		updated.FailedLogins = 0
+		updated.LockedUntil = &until
+		lockedUntil = &until
+	}
+	s.users[id] = &updated
+	return lockedUntil, nil
+}
+
+// RecordLogin clears the failed logins and lock of a user and sets its last
+// login time
+func (s *MemoryStore) RecordLogin(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	user, _ := s.liveUser(id, AnyVersion)
+	if user == nil {
+		return nil
+	}
+	now := time.Now()
+	updated := *user
+	updated.FailedLogins = 0
+	updated.LockedUntil = nil
+	updated.LastLoginAt = &now
+	s.users[id] = &updated
+	return nil
+}
+
+// ChangePassword replaces the password hash of a user, keeping the keep
+// newest previous hashes
+func (s *MemoryStore) ChangePassword(ctx context.Context, id uuid.UUID, hash string, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	user, err := s.liveUser(id, AnyVersion)
+	if user == nil {
+		if err == nil {
+			err = errUserNotFound()
+		}
+		return err
+	}
+
+	history := append([]string{user.PasswordHash}, s.history[id]...)
+	if len(history) > keep {
+		history = history[:keep]
+	}
+	s.history[id] = history
+
+	now := time.Now()
+	updated := *user
+	updated.PasswordHash = hash
+	updated.PasswordChangedAt = &now
+	updated.FailedLogins = 0
+	updated.LockedUntil = nil
+	updated.UpdatedAt = now
+	updated.Version++
+	s.users[id] = &updated
+	return nil
+}
+
+// PasswordHistory returns up to limit previous password hashes of a user,
+// newest first
+func (s *MemoryStore) PasswordHistory(ctx context.Context, id uuid.UUID, limit int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	history := s.history[id]
+	if limit < len(history) {
+		history = history[:max(limit, 0)]
+	}
+	return append([]string(nil), history...), nil
+}
+
+// RequestEmailChange stores a pending email change in place of any earlier
+// one of its user
+func (s *MemoryStore) RequestEmailChange(ctx context.Context, change *models.EmailChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	user, err := s.liveUser(change.UserID, AnyVersion)
+	if user == nil {
+		if err == nil {
+			err = errUserNotFound()
+		}
+		return err
+	}
+	if err := s.checkUnique(user.ID, change.NewEmail, user.Username); err != nil {
+		return err
+	}
+
+	change.ID = uuid.New()
+	change.CreatedAt = time.Now()
+	for id, pending := range s.emails {
+		if pending.ExpiresAt.Before(change.CreatedAt) {
+			delete(s.emails, id)
+		}
+	}
+	stored := *change
+	s.emails[user.ID] = &stored
+	return nil
+}
+
+// ConfirmEmailChange applies the pending email change with the given token
+// hash, returning the updated user and its previous email
+func (s *MemoryStore) ConfirmEmailChange(ctx context.Context, tokenHash string) (*models.User, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var change *models.EmailChange
+	for _, pending := range s.emails {
+		if pending.TokenHash == tokenHash {
+			change = pending
+			break
+		}
+	}
+	if change == nil {
+		return nil, "", errEmailChangeNotFound()
+	}
+	now := time.Now()
+	if !change.ExpiresAt.After(now) {
+		delete(s.emails, change.UserID)
+		return nil, "", errEmailChangeNotFound()
+	}
+
+	user, _ := s.liveUser(change.UserID, AnyVersion)
+	if user == nil {
+		return nil, "", errEmailChangeNotFound()
+	}
+	if err := s.checkUnique(user.ID, change.NewEmail, user.Username); err != nil {
+		return nil, "", err
+	}
+
+	delete(s.emails, user.ID)
+	updated := *user
+	updated.Email = change.NewEmail
+	updated.IsVerified = true
+	updated.UpdatedAt = now
+	updated.Version++
+	s.users[user.ID] = &updated
+
+	found := updated
+	return &found, user.Email, nil
+}
+
+// Delete soft deletes a user if it is at version, or any version for AnyVersion
+func (s *MemoryStore) Delete(ctx context.Context, id uuid.UUID, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	user, err := s.liveUser(id, version)
+	if user == nil {
+		return err
+	}
+
+	deleted := *user
+	deleted.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.users[id] = &deleted
+	return nil
+}
+
+// GetProfile retrieves user profile
+func (s *MemoryStore) GetProfile(ctx context.Context, userID uuid.UUID) (*models.UserProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	profile, ok := s.profiles[userID]
+	if !ok {
+		return nil, errProfileNotFound()
+	}
+
+	found := *profile
+	return &found, nil
+}
+
+// UpdateProfile updates user profile if it is at version, or any version for
+// AnyVersion
+func (s *MemoryStore) UpdateProfile(ctx context.Context, userID uuid.UUID, version int64, updates map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	profile, ok := s.profiles[userID]
+	switch {
+	case !ok && version == AnyVersion:
+		return nil
+	case !ok:
+		return errProfileNotFound()
+	case version != AnyVersion && profile.Version != version:
+		return errProfileVersion()
+	}
+
+	updated := *profile
+	if err := setColumns(ctx, s.profileSchema, &updated, updates); err != nil {
+		return err
+	}
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+
+	s.profiles[userID] = &updated
+	return nil
+}
+
+// UserStats returns the number of registered, active and verified users
+func (s *MemoryStore) UserStats(ctx context.Context) (*models.UserStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var stats models.UserStats
+	for _, user := range s.active() {
+		stats.Total++
+		if user.IsActive {
+			stats.Active++
+		}
+		if user.IsVerified {
+			stats.Verified++
+		}
+	}
+
+	return &stats, nil
+}
+
+// ExistsByEmail checks if user exists by email
+func (s *MemoryStore) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	user, err := s.FindByEmail(ctx, email)
+	if err != nil && ctx.Err() != nil {
+		return false, err
+	}
+	return user != nil, nil
+}
+
+// ExistsByUsername checks if user exists by username
+func (s *MemoryStore) ExistsByUsername(ctx context.Context, username string) (bool, error) {
+	user, err := s.FindByUsername(ctx, username)
+	if err != nil && ctx.Err() != nil {
+		return false, err
+	}
+	return user != nil, nil
+}
+
+// findUser returns a copy of the first user that is not deleted and matches
+func (s *MemoryStore) findUser(ctx context.Context, match func(*models.User) bool) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, user := range s.users {
+		if !user.DeletedAt.Valid && match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+
+	return nil, errUserNotFound()
+}
+
+// liveUser returns the user a write applies to: a user that is not deleted
+// and is at version unless it is AnyVersion. It returns nil and the error to
+// report, if any, otherwise.
+func (s *MemoryStore) liveUser(id uuid.UUID, version int64) (*models.User, error) {
+	user, ok := s.users[id]
+	switch {
+	case (!ok || user.DeletedAt.Valid) && version == AnyVersion:
+		return nil, nil
+	case !ok || user.DeletedAt.Valid:
+		return nil, errUserNotFound()
+	case version != AnyVersion && user.Version != version:
+		return nil, errUserVersion()
+	}
+	return user, nil
+}
+
+// active returns copies of the users that are not deleted
+func (s *MemoryStore) active() []models.User {
+	users := make([]models.User, 0, len(s.users))
+	for _, user := range s.users {
+		if !user.DeletedAt.Valid {
+			users = append(users, *user)
+		}
+	}
+	return users
+}
+
+// matchPreferences reports whether the preferences of user id match every
+// filter, comparing JSON values as the jsonb containment operator does
+func (s *MemoryStore) matchPreferences(id uuid.UUID, filters []PreferenceFilter) (bool, error) {
+	var preferences interface{}
+	if profile, ok := s.profiles[id]; ok && len(profile.Preferences) > 0 {
+		if err := json.Unmarshal(profile.Preferences, &preferences); err != nil {
+			return false, fmt.Errorf("invalid preferences of user %s: %w", id, err)
+		}
+	}
+
+	for _, filter := range filters {
+		var want interface{}
+		if err := json.Unmarshal(filter.Value, &want); err != nil {
+			return false, fmt.Errorf("invalid preference filter value: %w", err)
+		}
+
+		value, found := preferences, true
+		for _, name := range filter.Path {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if value, ok = object[name]; !ok {
+				found = false
+				break
+			}
+		}
+
+		switch {
+		case !found && !filter.IncludeUnset:
+			return false, nil
+		case found && !reflect.DeepEqual(value, want):
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkUnique enforces the case-insensitive unique indexes on email and
+// username, which cover deleted users too
+func (s *MemoryStore) checkUnique(id uuid.UUID, email, username string) error {
+	for _, user := range s.users {
+		if user.ID == id {
+			continue
+		}
+		if strings.EqualFold(user.Email, email) {
+			return errDuplicate("idx_users_email")
+		}
+		if strings.EqualFold(user.Username, username) {
+			return errDuplicate("idx_users_username")
+		}
+	}
+	return nil
+}
+
+// setColumns assigns updates keyed by column name, as GORM's Updates does
+func setColumns(ctx context.Context, s *schema.Schema, model interface{}, updates map[string]interface{}) error {
+	value := reflect.ValueOf(model).Elem()
+	for column, v := range updates {
+		f := s.LookUpField(column)
+		if f == nil || f.DBName == "" {
+			return fmt.Errorf("column %q of relation %q does not exist", column, s.Table)
+		}
+		if err := f.Set(ctx, value, v); err != nil {
+			return fmt.Errorf("invalid value for column %q: %w", column, err)
+		}
+	}
+	return nil
+}
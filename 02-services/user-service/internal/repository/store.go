@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/google/uuid"
+)
+
+// UserStore persists users and their profiles. UserRepository implements it
+// on Postgres and MemoryStore in memory, with the same semantics:
+//
+//   - emails and usernames are unique regardless of case, including those
+//     of deleted users, and are looked up case-insensitively
+//   - Delete is a soft delete; deleted users are no longer found, listed,
+//     counted or updated, but their profile is kept
+//   - Create stores the PasswordHash set by the caller, assigns the ID,
+//     timestamps and version 1 and creates an empty profile
+//   - Update and UpdateProfile take column names and increment the version
+//   - Update, UpdateProfile and Delete only apply to the given version and
+//     fail with ErrPrecondition if it is not current, or ErrNotFound if the
+//     user is gone; with AnyVersion they apply to any version and ignore
+//     unknown IDs
+//   - List only returns users whose preferences match every filter
+//   - RehashPassword replaces a password hash made with outdated parameters
+//     only if it is still the stored one, and keeps the version and
+//     updated_at, as the user did not change
+//   - FindByID may be served from a cache that leaves out the password hash
+//     and lockout state; FindCredentials, FindByEmail and FindByUsername
+//     read them from the store
+//   - RecordLoginFailure counts a wrong password and, at the threshold,
+//     locks the user for lockFor and starts counting again; it returns the
+//     end of the lock it set, if any. RecordLogin clears the count and the
+//     lock and sets last_login_at. Both keep the version and updated_at and
+//     ignore unknown IDs.
+//   - ChangePassword stores a new password hash, moves the previous one to
+//     the password history, keeping the keep newest entries, sets
+//     password_changed_at, clears the lockout and increments the version,
+//     or fails with ErrNotFound if the user is gone.
+//     UserRepository also revokes the refresh tokens of the user, when
+//     auth-service's refresh_tokens table is in the same schema.
+//   - PasswordHistory returns up to limit previous password hashes, newest
+//     first
+//   - RequestEmailChange stores a pending email change, assigning its ID
+//     and created_at, in place of any earlier one of the user, and drops
+//     expired changes. It fails with ErrNotFound if the user is gone, or
+//     EMAIL_EXISTS if another user has the new email.
+//   - ConfirmEmailChange removes the pending change with the given token
+//     hash and applies it: it sets the email, marks it verified, as the
+//     token proves the user receives mail there, and increments the
+//     version. It returns the updated user and the previous email, and
+//     fails with ErrNotFound if the token is unknown, used or expired or
+//     the user is gone, or EMAIL_EXISTS if the email was taken meanwhile.
+type UserStore interface {
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	List(ctx context.Context, page, limit int, filters ...PreferenceFilter) ([]models.User, int64, error)
+	Update(ctx context.Context, id uuid.UUID, version int64, updates map[string]interface{}) error
+	Delete(ctx context.Context, id uuid.UUID, version int64) error
+	RehashPassword(ctx context.Context, id uuid.UUID, current, hash string) error
+	FindCredentials(ctx context.Context, id uuid.UUID) (*models.User, error)
+	RecordLoginFailure(ctx context.Context, id uuid.UUID, threshold int, lockFor time.Duration) (*time.Time, error)
+	RecordLogin(ctx context.Context, id uuid.UUID) error
+	ChangePassword(ctx context.Context, id uuid.UUID, hash string, keep int) error
+	PasswordHistory(ctx context.Context, id uuid.UUID, limit int) ([]string, error)
+	RequestEmailChange(ctx context.Context, change *models.EmailChange) error
+	ConfirmEmailChange(ctx context.Context, tokenHash string) (*models.User, string, error)
+	GetProfile(ctx context.Context, userID uuid.UUID) (*models.UserProfile, error)
+	UpdateProfile(ctx context.Context, userID uuid.UUID, version int64, updates map[string]interface{}) error
+	UserStats(ctx context.Context) (*models.UserStats, error)
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	ExistsByUsername(ctx context.Context, username string) (bool, error)
+}
+
+// AnyVersion makes a write unconditional
+const AnyVersion int64 = 0
+
+// PreferenceFilter matches users whose preference at Path, a list of nested
+// property names, equals the scalar JSON Value. With IncludeUnset, users who
+// have not set the preference match too, for a value that is its default.
+type PreferenceFilter struct {
+	Path         []string
+	Value        json.RawMessage
+	IncludeUnset bool
+}
+
+var (
+	_ UserStore = (*UserRepository)(nil)
+	_ UserStore = (*MemoryStore)(nil)
+)
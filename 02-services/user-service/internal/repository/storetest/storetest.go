@@ -0,0 +1,801 @@
+// Package storetest checks that a repository.UserStore keeps the contract
+// documented on the interface, so the in-memory store can stand in for
+// Postgres in handler tests.
+//
+// storetest_test.go runs the suite against both implementations: against
+// the in-memory store with
+//
+//	storetest.Run(t, storetest.Memory)
+//
+// and, when TEST_DATABASE_URL points to a scratch database, against a
+// freshly migrated schema returned by OpenPostgres.
+package storetest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Factory returns an empty store for each case of the suite
+type Factory func() (repository.UserStore, error)
+
+// Memory is the Factory for repository.MemoryStore
+func Memory() (repository.UserStore, error) {
+	return repository.NewMemoryStore(), nil
+}
+
+type testCase struct {
+	name string
+	run  func(ctx context.Context, store repository.UserStore) error
+}
+
+var cases = []testCase{
+	{"create assigns identity, defaults and profile", testCreate},
+	{"email and username are unique", testUnique},
+	{"email and username ignore case", testCaseInsensitive},
+	{"concurrent creation with the same email", testConcurrentCreate},
+	{"find by id, email and username", testFind},
+	{"delete is a soft delete", testSoftDelete},
+	{"update sets columns of live users", testUpdate},
+	{"update profile", testUpdateProfile},
+	{"rehash password", testRehashPassword},
+	{"login failures lock the user", testLockout},
+	{"change password keeps a history", testChangePassword},
+	{"email changes are confirmed once", testEmailChange},
+	{"versions guard conditional writes", testVersions},
+	{"list pages oldest first", testList},
+	{"list filters by preference", testListPreferences},
+	{"user stats", testUserStats},
+	{"cancelled context", testCancelled},
+}
+
+// Run runs every case as a subtest of t against a new store from newStore
+func Run(t *testing.T, newStore Factory) {
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := newStore()
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			if err := tc.run(context.Background(), store); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func testCreate(ctx context.Context, store repository.UserStore) error {
+	before := time.Now().Add(-time.Second)
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case user.ID == uuid.Nil:
+		return errors.New("ID not assigned")
+	case user.CreatedAt.Before(before) || user.UpdatedAt.Before(before):
+		return errors.New("timestamps not assigned")
+	case user.Version != 1:
+		return fmt.Errorf("got version %d, want 1", user.Version)
+	}
+
+	found, err := store.FindByID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("FindByID: %w", err)
+	}
+	if found.PasswordHash != passwordHash("alice") {
+		return fmt.Errorf("got password hash %q, want the one given to Create", found.PasswordHash)
+	}
+	if found.Role != "user" || !found.IsActive || found.IsVerified {
+		return fmt.Errorf("got role %q, active %t, verified %t; want column defaults user, true, false",
+			found.Role, found.IsActive, found.IsVerified)
+	}
+
+	profile, err := store.GetProfile(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("profile not created: %w", err)
+	}
+	if profile.UserID != user.ID || profile.Language != "en" || string(profile.Preferences) != "{}" || profile.Version != 1 {
+		return fmt.Errorf("unexpected default profile %+v", profile)
+	}
+	return nil
+}
+
+func testUnique(ctx context.Context, store repository.UserStore) error {
+	if _, err := createUser(ctx, store, "alice"); err != nil {
+		return err
+	}
+
+	sameEmail := newUser("alice2")
+	sameEmail.Email = "alice@example.com"
+	if err := store.Create(ctx, sameEmail); !isConflict(err, apperrors.CodeEmailExists) {
+		return fmt.Errorf("Create with duplicate email returned %v, want EMAIL_EXISTS", err)
+	}
+
+	sameUsername := newUser("alice")
+	sameUsername.Email = "other@example.com"
+	if err := store.Create(ctx, sameUsername); !isConflict(err, apperrors.CodeUsernameExists) {
+		return fmt.Errorf("Create with duplicate username returned %v, want USERNAME_EXISTS", err)
+	}
+
+	bob, err := createUser(ctx, store, "bob")
+	if err != nil {
+		return err
+	}
+	if err := store.Update(ctx, bob.ID, repository.AnyVersion, map[string]interface{}{"email": "alice@example.com"}); !errors.Is(err, apperrors.ErrConflict) {
+		return fmt.Errorf("Update to a duplicate email returned %v, want ErrConflict", err)
+	}
+
+	exists, err := store.ExistsByEmail(ctx, "alice@example.com")
+	if err != nil || !exists {
+		return fmt.Errorf("ExistsByEmail = %t, %v; want true", exists, err)
+	}
+	exists, err = store.ExistsByUsername(ctx, "nobody")
+	if err != nil || exists {
+		return fmt.Errorf("ExistsByUsername of unknown user = %t, %v; want false", exists, err)
+	}
+	return nil
+}
+
+func testCaseInsensitive(ctx context.Context, store repository.UserStore) error {
+	user := newUser("Alice")
+	user.Email = "Alice@Example.com"
+	if err := store.Create(ctx, user); err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+
+	found, err := store.FindByEmail(ctx, "alice@EXAMPLE.com")
+	if err != nil {
+		return fmt.Errorf("FindByEmail with different case: %w", err)
+	}
+	if found.Email != "Alice@Example.com" || found.Username != "Alice" {
+		return fmt.Errorf("got %q and %q, want the original case preserved", found.Email, found.Username)
+	}
+	if _, err := store.FindByUsername(ctx, "ALICE"); err != nil {
+		return fmt.Errorf("FindByUsername with different case: %w", err)
+	}
+	if exists, err := store.ExistsByEmail(ctx, "ALICE@example.com"); err != nil || !exists {
+		return fmt.Errorf("ExistsByEmail with different case = %t, %v; want true", exists, err)
+	}
+
+	sameEmail := newUser("bob")
+	sameEmail.Email = "alice@example.COM"
+	if err := store.Create(ctx, sameEmail); !isConflict(err, apperrors.CodeEmailExists) {
+		return fmt.Errorf("Create with email differing in case returned %v, want EMAIL_EXISTS", err)
+	}
+	sameUsername := newUser("aLiCe")
+	sameUsername.Email = "carol@example.com"
+	if err := store.Create(ctx, sameUsername); !isConflict(err, apperrors.CodeUsernameExists) {
+		return fmt.Errorf("Create with username differing in case returned %v, want USERNAME_EXISTS", err)
+	}
+	return nil
+}
+
+func testConcurrentCreate(ctx context.Context, store repository.UserStore) error {
+	const attempts = 8
+
+	results := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := newUser(fmt.Sprintf("racer%d", i))
+			user.Email = "racer@example.com"
+			results <- store.Create(ctx, user)
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	created := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			created++
+		case !isConflict(err, apperrors.CodeEmailExists):
+			return fmt.Errorf("Create returned %v, want nil or EMAIL_EXISTS", err)
+		}
+	}
+	if created != 1 {
+		return fmt.Errorf("%d users created with the same email, want 1", created)
+	}
+	return nil
+}
+
+func testFind(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+
+	lookups := map[string]func() (*models.User, error){
+		"FindByID":       func() (*models.User, error) { return store.FindByID(ctx, user.ID) },
+		"FindByEmail":    func() (*models.User, error) { return store.FindByEmail(ctx, user.Email) },
+		"FindByUsername": func() (*models.User, error) { return store.FindByUsername(ctx, user.Username) },
+	}
+	for name, lookup := range lookups {
+		found, err := lookup()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if found.ID != user.ID || found.Email != user.Email || found.FirstName != user.FirstName {
+			return fmt.Errorf("%s returned %+v", name, found)
+		}
+	}
+
+	if _, err := store.FindByID(ctx, uuid.New()); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("FindByID of unknown ID returned %v, want ErrNotFound", err)
+	}
+	if _, err := store.FindByEmail(ctx, "nobody@example.com"); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("FindByEmail of unknown email returned %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetProfile(ctx, uuid.New()); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("GetProfile of unknown user returned %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func testSoftDelete(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(ctx, user.ID, repository.AnyVersion); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	if err := store.Delete(ctx, user.ID, repository.AnyVersion); err != nil {
+		return fmt.Errorf("second Delete: %w", err)
+	}
+
+	if _, err := store.FindByID(ctx, user.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("FindByID of deleted user returned %v, want ErrNotFound", err)
+	}
+	if _, err := store.FindByEmail(ctx, user.Email); err == nil {
+		return errors.New("deleted user found by email")
+	}
+	if exists, err := store.ExistsByUsername(ctx, user.Username); err != nil || exists {
+		return fmt.Errorf("ExistsByUsername of deleted user = %t, %v; want false", exists, err)
+	}
+	if _, total, err := store.List(ctx, 1, 10); err != nil || total != 0 {
+		return fmt.Errorf("List total = %d, %v; want 0", total, err)
+	}
+	if stats, err := store.UserStats(ctx); err != nil || stats.Total != 0 {
+		return fmt.Errorf("UserStats = %+v, %v; want no users", stats, err)
+	}
+
+	// The unique indexes still cover the deleted row
+	if _, err := createUser(ctx, store, "alice"); !errors.Is(err, apperrors.ErrConflict) {
+		return fmt.Errorf("Create reusing the email of a deleted user returned %v, want ErrConflict", err)
+	}
+
+	if _, err := store.GetProfile(ctx, user.ID); err != nil {
+		return fmt.Errorf("profile of deleted user removed: %w", err)
+	}
+
+	if err := store.Update(ctx, user.ID, repository.AnyVersion, map[string]interface{}{"first_name": "Changed"}); err != nil {
+		return fmt.Errorf("Update of deleted user: %w", err)
+	}
+	return nil
+}
+
+func testUpdate(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+
+	err = store.Update(ctx, user.ID, repository.AnyVersion, map[string]interface{}{
+		"first_name": "Alicia",
+		"avatar_url": "https://cdn.example.com/alice.png",
+		"updated_at": time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+
+	found, err := store.FindByID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("FindByID: %w", err)
+	}
+	if found.FirstName != "Alicia" || found.AvatarURL != "https://cdn.example.com/alice.png" || found.LastName != user.LastName {
+		return fmt.Errorf("unexpected user after update %+v", found)
+	}
+	if found.UpdatedAt.Before(user.UpdatedAt) {
+		return errors.New("updated_at moved backwards")
+	}
+
+	if err := store.Update(ctx, uuid.New(), repository.AnyVersion, map[string]interface{}{"first_name": "Nobody"}); err != nil {
+		return fmt.Errorf("Update of unknown ID: %w", err)
+	}
+	if err := store.Update(ctx, user.ID, repository.AnyVersion, map[string]interface{}{"no_such_column": "x"}); err == nil {
+		return errors.New("update of unknown column accepted")
+	}
+	return nil
+}
+
+func testUpdateProfile(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+
+	birthday := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	err = store.UpdateProfile(ctx, user.ID, repository.AnyVersion, map[string]interface{}{
+		"bio":           "Hello",
+		"date_of_birth": &birthday,
+		"timezone":      "Europe/Paris",
+		"preferences":   `{"theme": "dark"}`,
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateProfile: %w", err)
+	}
+
+	profile, err := store.GetProfile(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("GetProfile: %w", err)
+	}
+	if profile.Bio != "Hello" || profile.Timezone != "Europe/Paris" || profile.Language != "en" ||
+		profile.DateOfBirth == nil || !profile.DateOfBirth.Equal(birthday) {
+		return fmt.Errorf("unexpected profile after update %+v", profile)
+	}
+	return nil
+}
+
+func testRehashPassword(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+
+	if err := store.RehashPassword(ctx, user.ID, "$hash$stale", "$hash$lost"); err != nil {
+		return fmt.Errorf("RehashPassword of a replaced hash: %w", err)
+	}
+	if err := store.RehashPassword(ctx, user.ID, passwordHash("alice"), "$hash$rehashed"); err != nil {
+		return fmt.Errorf("RehashPassword: %w", err)
+	}
+
+	found, err := store.FindByID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("FindByID: %w", err)
+	}
+	if found.PasswordHash != "$hash$rehashed" {
+		return fmt.Errorf("got password hash %q, want $hash$rehashed", found.PasswordHash)
+	}
+	if found.Version != 1 || !found.UpdatedAt.Equal(user.UpdatedAt) {
+		return fmt.Errorf("got version %d updated at %s, want them unchanged", found.Version, found.UpdatedAt)
+	}
+	return nil
+}
+
+func testLockout(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i < 3; i++ {
+		if until, err := store.RecordLoginFailure(ctx, user.ID, 3, time.Minute); err != nil || until != nil {
+			return fmt.Errorf("RecordLoginFailure %d = %v, %v; want no lock", i, until, err)
+		}
+	}
+	found, err := store.FindCredentials(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("FindCredentials: %w", err)
+	}
+	if found.FailedLogins != 2 || found.LockedUntil != nil {
+		return fmt.Errorf("got %d failed logins, locked until %v; want 2, not locked", found.FailedLogins, found.LockedUntil)
+	}
+
+	until, err := store.RecordLoginFailure(ctx, user.ID, 3, time.Minute)
+	if err != nil {
+		return fmt.Errorf("RecordLoginFailure: %w", err)
+	}
+	if until == nil || time.Until(*until) < 50*time.Second || time.Until(*until) > time.Minute {
+		return fmt.Errorf("third failure locked until %v, want about a minute from now", until)
+	}
+	if found, err = store.FindCredentials(ctx, user.ID); err != nil {
+		return fmt.Errorf("FindCredentials: %w", err)
+	}
+	if found.FailedLogins != 0 || found.LockedUntil == nil || !found.LockedUntil.Equal(*until) {
+		return fmt.Errorf("got %d failed logins, locked until %v; want 0, locked until %v", found.FailedLogins, found.LockedUntil, *until)
+	}
+
+	if err := store.RecordLogin(ctx, user.ID); err != nil {
+		return fmt.Errorf("RecordLogin: %w", err)
+	}
+	if found, err = store.FindCredentials(ctx, user.ID); err != nil {
+		return fmt.Errorf("FindCredentials: %w", err)
+	}
+	if found.FailedLogins != 0 || found.LockedUntil != nil || found.LastLoginAt == nil {
+		return fmt.Errorf("got %d failed logins, locked until %v, last login %v; want the lock cleared and the login recorded",
+			found.FailedLogins, found.LockedUntil, found.LastLoginAt)
+	}
+	if found.Version != 1 {
+		return fmt.Errorf("got version %d, want it unchanged", found.Version)
+	}
+
+	if until, err := store.RecordLoginFailure(ctx, uuid.New(), 3, time.Minute); err != nil || until != nil {
+		return fmt.Errorf("RecordLoginFailure of an unknown user = %v, %v; want it ignored", until, err)
+	}
+	return nil
+}
+
+func testChangePassword(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+	if _, err := store.RecordLoginFailure(ctx, user.ID, 3, time.Minute); err != nil {
+		return fmt.Errorf("RecordLoginFailure: %w", err)
+	}
+
+	for _, hash := range []string{"$hash$1", "$hash$2", "$hash$3"} {
+		if err := store.ChangePassword(ctx, user.ID, hash, 2); err != nil {
+			return fmt.Errorf("ChangePassword to %s: %w", hash, err)
+		}
+	}
+
+	found, err := store.FindCredentials(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("FindCredentials: %w", err)
+	}
+	switch {
+	case found.PasswordHash != "$hash$3":
+		return fmt.Errorf("got password hash %q, want $hash$3", found.PasswordHash)
+	case found.PasswordChangedAt == nil:
+		return errors.New("password_changed_at not set")
+	case found.FailedLogins != 0:
+		return fmt.Errorf("got %d failed logins, want them cleared", found.FailedLogins)
+	case found.Version != 4:
+		return fmt.Errorf("got version %d, want 4", found.Version)
+	}
+
+	history, err := store.PasswordHistory(ctx, user.ID, 5)
+	if err != nil {
+		return fmt.Errorf("PasswordHistory: %w", err)
+	}
+	if fmt.Sprint(history) != "[$hash$2 $hash$1]" {
+		return fmt.Errorf("got history %v, want the 2 newest previous hashes", history)
+	}
+	if history, err = store.PasswordHistory(ctx, user.ID, 1); err != nil || fmt.Sprint(history) != "[$hash$2]" {
+		return fmt.Errorf("PasswordHistory with limit 1 = %v, %v; want [$hash$2]", history, err)
+	}
+
+	if err := store.ChangePassword(ctx, user.ID, "$hash$4", 0); err != nil {
+		return fmt.Errorf("ChangePassword keeping no history: %w", err)
+	}
+	if history, err = store.PasswordHistory(ctx, user.ID, 5); err != nil || len(history) != 0 {
+		return fmt.Errorf("PasswordHistory = %v, %v; want it emptied", history, err)
+	}
+
+	if err := store.ChangePassword(ctx, uuid.New(), "$hash$x", 2); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("ChangePassword of an unknown user returned %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func testEmailChange(ctx context.Context, store repository.UserStore) error {
+	alice, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+	bob, err := createUser(ctx, store, "bob")
+	if err != nil {
+		return err
+	}
+	request := func(user uuid.UUID, email, token string, expiresIn time.Duration) error {
+		return store.RequestEmailChange(ctx, &models.EmailChange{
+			UserID:    user,
+			NewEmail:  email,
+			TokenHash: tokenHash(token),
+			ExpiresAt: time.Now().Add(expiresIn),
+		})
+	}
+
+	if err := request(alice.ID, "BOB@example.com", "taken", time.Hour); !errors.Is(err, apperrors.ErrConflict) {
+		return fmt.Errorf("RequestEmailChange to another user's email returned %v, want ErrConflict", err)
+	}
+	if err := request(uuid.New(), "nobody@example.com", "unknown", time.Hour); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("RequestEmailChange of an unknown user returned %v, want ErrNotFound", err)
+	}
+
+	// A new request replaces the pending one
+	if err := request(alice.ID, "alice@example.org", "first", time.Hour); err != nil {
+		return fmt.Errorf("RequestEmailChange: %w", err)
+	}
+	if err := request(alice.ID, "alice@example.net", "second", time.Hour); err != nil {
+		return fmt.Errorf("RequestEmailChange again: %w", err)
+	}
+	if _, _, err := store.ConfirmEmailChange(ctx, tokenHash("first")); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("ConfirmEmailChange with a replaced token returned %v, want ErrNotFound", err)
+	}
+
+	changed, previous, err := store.ConfirmEmailChange(ctx, tokenHash("second"))
+	if err != nil {
+		return fmt.Errorf("ConfirmEmailChange: %w", err)
+	}
+	switch {
+	case changed.ID != alice.ID || changed.Email != "alice@example.net":
+		return fmt.Errorf("got user %s with email %q, want %s with alice@example.net", changed.ID, changed.Email, alice.ID)
+	case previous != "alice@example.com":
+		return fmt.Errorf("got previous email %q, want alice@example.com", previous)
+	case !changed.IsVerified:
+		return errors.New("confirmed email not marked verified")
+	case changed.Version != 2:
+		return fmt.Errorf("got version %d, want 2", changed.Version)
+	}
+	if found, err := store.FindByID(ctx, alice.ID); err != nil || found.Email != "alice@example.net" {
+		return fmt.Errorf("FindByID after the change = %v, %v; want the new email", found, err)
+	}
+	if _, _, err := store.ConfirmEmailChange(ctx, tokenHash("second")); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("ConfirmEmailChange with a used token returned %v, want ErrNotFound", err)
+	}
+
+	if err := request(bob.ID, "bob@example.org", "expired", -time.Minute); err != nil {
+		return fmt.Errorf("RequestEmailChange: %w", err)
+	}
+	if _, _, err := store.ConfirmEmailChange(ctx, tokenHash("expired")); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("ConfirmEmailChange with an expired token returned %v, want ErrNotFound", err)
+	}
+
+	// The new email may be taken between the request and the confirmation
+	if err := request(bob.ID, "carol@example.com", "raced", time.Hour); err != nil {
+		return fmt.Errorf("RequestEmailChange: %w", err)
+	}
+	if _, err := createUser(ctx, store, "carol"); err != nil {
+		return err
+	}
+	if _, _, err := store.ConfirmEmailChange(ctx, tokenHash("raced")); !errors.Is(err, apperrors.ErrConflict) {
+		return fmt.Errorf("ConfirmEmailChange to a taken email returned %v, want ErrConflict", err)
+	}
+	if found, err := store.FindByID(ctx, bob.ID); err != nil || found.Email != "bob@example.com" || found.Version != 1 {
+		return fmt.Errorf("FindByID after a failed change = %v, %v; want bob unchanged", found, err)
+	}
+	return nil
+}
+
+func testVersions(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+
+	rename := map[string]interface{}{"first_name": "Alicia"}
+	if err := store.Update(ctx, user.ID, 1, rename); err != nil {
+		return fmt.Errorf("Update at current version: %w", err)
+	}
+	if err := store.Update(ctx, user.ID, 1, map[string]interface{}{"first_name": "Stale"}); !errors.Is(err, apperrors.ErrPrecondition) {
+		return fmt.Errorf("Update at stale version returned %v, want ErrPrecondition", err)
+	}
+	if err := store.Update(ctx, user.ID, repository.AnyVersion, map[string]interface{}{"last_name": "Any"}); err != nil {
+		return fmt.Errorf("Update at any version: %w", err)
+	}
+
+	found, err := store.FindByID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("FindByID: %w", err)
+	}
+	if found.Version != 3 || found.FirstName != "Alicia" {
+		return fmt.Errorf("got version %d and first name %q, want 3 and Alicia", found.Version, found.FirstName)
+	}
+
+	if err := store.UpdateProfile(ctx, user.ID, 2, map[string]interface{}{"bio": "Stale"}); !errors.Is(err, apperrors.ErrPrecondition) {
+		return fmt.Errorf("UpdateProfile at stale version returned %v, want ErrPrecondition", err)
+	}
+	if err := store.UpdateProfile(ctx, user.ID, 1, map[string]interface{}{"bio": "Hello"}); err != nil {
+		return fmt.Errorf("UpdateProfile at current version: %w", err)
+	}
+	profile, err := store.GetProfile(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("GetProfile: %w", err)
+	}
+	if profile.Version != 2 || profile.Bio != "Hello" {
+		return fmt.Errorf("got profile version %d and bio %q, want 2 and Hello", profile.Version, profile.Bio)
+	}
+	if err := store.UpdateProfile(ctx, uuid.New(), 1, map[string]interface{}{"bio": "Nobody"}); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("UpdateProfile of unknown ID at a version returned %v, want ErrNotFound", err)
+	}
+
+	if err := store.Delete(ctx, user.ID, 2); !errors.Is(err, apperrors.ErrPrecondition) {
+		return fmt.Errorf("Delete at stale version returned %v, want ErrPrecondition", err)
+	}
+	if err := store.Delete(ctx, user.ID, 3); err != nil {
+		return fmt.Errorf("Delete at current version: %w", err)
+	}
+	if err := store.Delete(ctx, user.ID, 3); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("Delete of deleted user at a version returned %v, want ErrNotFound", err)
+	}
+	if err := store.Update(ctx, user.ID, 3, rename); !errors.Is(err, apperrors.ErrNotFound) {
+		return fmt.Errorf("Update of deleted user at a version returned %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func testList(ctx context.Context, store repository.UserStore) error {
+	var ids []uuid.UUID
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := createUser(ctx, store, name)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, user.ID)
+		// Distinct creation times keep the expected order unambiguous
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	first, total, err := store.List(ctx, 1, 2)
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	second, _, err := store.List(ctx, 2, 2)
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	beyond, _, err := store.List(ctx, 3, 2)
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+
+	if total != 3 || len(first) != 2 || len(second) != 1 || len(beyond) != 0 {
+		return fmt.Errorf("got total %d and pages of %d, %d, %d users; want 3 and 2, 1, 0",
+			total, len(first), len(second), len(beyond))
+	}
+	if first[0].ID != ids[0] || first[1].ID != ids[1] || second[0].ID != ids[2] {
+		return errors.New("users not listed oldest first")
+	}
+	return nil
+}
+
+func testListPreferences(ctx context.Context, store repository.UserStore) error {
+	preferences := map[string]string{
+		"alice": `{"notifications": {"email": false}, "items_per_page": 50}`,
+		"bob":   `{"notifications": {"email": true}}`,
+		"carol": `{}`,
+	}
+	ids := map[uuid.UUID]string{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := createUser(ctx, store, name)
+		if err != nil {
+			return err
+		}
+		ids[user.ID] = name
+		update := map[string]interface{}{"preferences": models.Preferences(preferences[name])}
+		if err := store.UpdateProfile(ctx, user.ID, repository.AnyVersion, update); err != nil {
+			return fmt.Errorf("UpdateProfile: %w", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	emailPath := []string{"notifications", "email"}
+	tests := []struct {
+		name    string
+		filters []repository.PreferenceFilter
+		want    []string
+	}{
+		{"email=false", []repository.PreferenceFilter{{Path: emailPath, Value: json.RawMessage(`false`)}}, []string{"alice"}},
+		{"email=true", []repository.PreferenceFilter{{Path: emailPath, Value: json.RawMessage(`true`)}}, []string{"bob"}},
+		{"email=true or unset", []repository.PreferenceFilter{{Path: emailPath, Value: json.RawMessage(`true`), IncludeUnset: true}}, []string{"bob", "carol"}},
+		{"items_per_page=50.0", []repository.PreferenceFilter{{Path: []string{"items_per_page"}, Value: json.RawMessage(`50.0`)}}, []string{"alice"}},
+		{"email=true or unset and items_per_page=50", []repository.PreferenceFilter{
+			{Path: emailPath, Value: json.RawMessage(`true`), IncludeUnset: true},
+			{Path: []string{"items_per_page"}, Value: json.RawMessage(`50`)},
+		}, nil},
+	}
+	for _, tt := range tests {
+		users, total, err := store.List(ctx, 1, 10, tt.filters...)
+		if err != nil {
+			return fmt.Errorf("List: %w", err)
+		}
+		var got []string
+		for _, user := range users {
+			got = append(got, ids[user.ID])
+		}
+		if total != int64(len(tt.want)) || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			return fmt.Errorf("List with %s returned %v of %d users, want %v", tt.name, got, total, tt.want)
+		}
+	}
+	return nil
+}
+
+func testUserStats(ctx context.Context, store repository.UserStore) error {
+	alice, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+	bob, err := createUser(ctx, store, "bob")
+	if err != nil {
+		return err
+	}
+	if _, err := createUser(ctx, store, "carol"); err != nil {
+		return err
+	}
+
+	if err := store.Update(ctx, alice.ID, repository.AnyVersion, map[string]interface{}{"is_verified": true}); err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+	if err := store.Update(ctx, bob.ID, repository.AnyVersion, map[string]interface{}{"is_active": false}); err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+
+	stats, err := store.UserStats(ctx)
+	if err != nil {
+		return fmt.Errorf("UserStats: %w", err)
+	}
+	if *stats != (models.UserStats{Total: 3, Active: 2, Verified: 1}) {
+		return fmt.Errorf("got %+v, want 3 users, 2 active, 1 verified", *stats)
+	}
+	return nil
+}
+
+func testCancelled(ctx context.Context, store repository.UserStore) error {
+	user, err := createUser(ctx, store, "alice")
+	if err != nil {
+		return err
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := store.FindByID(cancelled, user.ID); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("FindByID returned %v, want context.Canceled", err)
+	}
+	if err := store.Create(cancelled, newUser("bob")); err == nil {
+		return errors.New("Create succeeded with a cancelled context")
+	}
+	if _, err := store.FindByUsername(ctx, "bob"); err == nil {
+		return errors.New("user created with a cancelled context")
+	}
+	return nil
+}
+
+// isConflict reports whether err is an ErrConflict with the given code
+func isConflict(err error, code string) bool {
+	var appErr *apperrors.Error
+	return errors.Is(err, apperrors.ErrConflict) && errors.As(err, &appErr) && appErr.Code == code
+}
+
+func newUser(name string) *models.User {
+	return &models.User{
+		Email:     name + "@example.com",
+		Username:  name,
+		FirstName: name,
+		LastName:  "Example",
+		IsActive:  true,
+
+		PasswordHash: passwordHash(name),
+	}
+}
+
+// tokenHash stands in for the SHA-256 hash of an email change token
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// passwordHash is the hash stored for a user; stores keep it as given
+func passwordHash(name string) string {
+	return "$hash$" + name
+}
+
+func createUser(ctx context.Context, store repository.UserStore, name string) (*models.User, error) {
+	user := newUser(name)
+	if err := store.Create(ctx, user); err != nil {
+		return nil, fmt.Errorf("Create %s: %w", name, err)
+	}
+	return user, nil
+}
@@ -0,0 +1,31 @@
+package storetest_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/repository/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, storetest.Memory)
+}
+
+func TestUserRepository(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	newStore, closeDB, err := storetest.OpenPostgres(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := closeDB(); err != nil {
+			t.Error(err)
+		}
+	})
+	storetest.Run(t, newStore)
+}
@@ -3,21 +3,37 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/metrics"
 	"github.com/devsecops/user-service/internal/models"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // UserRepository handles database operations for users
 type UserRepository struct {
-	db    *gorm.DB
-	cache CacheInterface
-	log   *logrus.Logger
+	db       *gorm.DB
+	cache    CacheInterface
+	cacheTTL atomic.Int64
+	timeouts Timeouts
+	log      *logrus.Logger
+}
+
+// Timeouts bound individual repository operations so a slow database or
+// cache fails fast even when the caller's context allows more time. Zero
+// leaves an operation bounded by the caller's context only.
+type Timeouts struct {
+	Query time.Duration
+	Write time.Duration
+	Cache time.Duration
 }
 
 // CacheInterface defines cache operations
@@ -27,31 +43,41 @@ type CacheInterface interface {
 	Delete(ctx context.Context, key string) error
 }
 
-// NewUserRepository creates a new user repository
-func NewUserRepository(db *gorm.DB, cache CacheInterface, log *logrus.Logger) *UserRepository {
-	return &UserRepository{
-		db:    db,
-		cache: cache,
-		log:   log,
+// NewUserRepository creates a new user repository caching users for cacheTTL
+func NewUserRepository(db *gorm.DB, cache CacheInterface, cacheTTL time.Duration, timeouts Timeouts, log *logrus.Logger) *UserRepository {
+	r := &UserRepository{
+		db:       db,
+		cache:    cache,
+		timeouts: timeouts,
+		log:      log,
 	}
+	r.SetCacheTTL(cacheTTL)
+	return r
 }
 
-// Create creates a new user
-func (r *UserRepository) Create(user *models.User, password string) error {
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		r.log.Errorf("Failed to hash password: %v", err)
-		return fmt.Errorf("failed to hash password")
-	}
+// SetCacheTTL changes how long users are cached from now on
+func (r *UserRepository) SetCacheTTL(ttl time.Duration) {
+	r.cacheTTL.Store(int64(ttl))
+}
+
+// Create creates a new user with the password hash it holds
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	log := r.log.WithContext(ctx)
 
 	user.ID = uuid.New()
-	user.PasswordHash = string(hashedPassword)
+	user.Version = 1
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 
-	if err := r.db.Create(user).Error; err != nil {
-		r.log.Errorf("Failed to create user: %v", err)
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+	db := r.db.WithContext(writeCtx)
+
+	if err := db.Create(user).Error; err != nil {
+		err = dbError(err, nil)
+		if !errors.Is(err, apperrors.ErrConflict) {
+			log.Errorf("Failed to create user: %v", err)
+		}
 		return err
 	}
 
@@ -59,172 +85,592 @@ func (r *UserRepository) Create(user *models.User, password string) error {
 	profile := &models.UserProfile{
 		ID:        uuid.New(),
 		UserID:    user.ID,
+		Version:   1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	if err := r.db.Create(profile).Error; err != nil {
-		r.log.Warnf("Failed to create user profile: %v", err)
+	if err := db.Create(profile).Error; err != nil {
+		log.Warnf("Failed to create user profile: %v", err)
 	}
 
 	return nil
 }
 
 // FindByID finds a user by ID
-func (r *UserRepository) FindByID(id uuid.UUID) (*models.User, error) {
+func (r *UserRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	log := r.log.WithContext(ctx)
+
 	// Try cache first
 	if r.cache != nil {
 		cacheKey := fmt.Sprintf("user:%s", id.String())
-		cached, err := r.cache.Get(context.Background(), cacheKey)
-		if err == nil && cached != "" {
+		cacheCtx, cancel := withTimeout(ctx, r.timeouts.Cache)
+		cached, err := r.cache.Get(cacheCtx, cacheKey)
+		cancel()
+		switch {
+		case err == nil && cached != "":
+			// Entries cached before users had versions are treated as misses
 			var user models.User
-			if err := json.Unmarshal([]byte(cached), &user); err == nil {
-				r.log.Debugf("User %s found in cache", id)
+			if err := json.Unmarshal([]byte(cached), &user); err == nil && user.Version > 0 {
+				metrics.CacheRequests.WithLabelValues("user", metrics.CacheHit).Inc()
+				log.Debugf("User %s found in cache", id)
 				return &user, nil
 			}
+			metrics.CacheRequests.WithLabelValues("user", metrics.CacheError).Inc()
+		case err == nil:
+			metrics.CacheRequests.WithLabelValues("user", metrics.CacheMiss).Inc()
+		default:
+			metrics.CacheRequests.WithLabelValues("user", metrics.CacheError).Inc()
 		}
 	}
 
 	// Query database
+	queryCtx, cancel := withTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
 	var user models.User
-	if err := r.db.Where("id = ?", id).First(&user).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+	if err := r.db.WithContext(queryCtx).Where("id = ?", id).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errUserNotFound()
 		}
-		r.log.Errorf("Failed to find user: %v", err)
-		return nil, err
+		log.Errorf("Failed to find user: %v", err)
+		return nil, dbError(err, nil)
 	}
 
 	// Cache the result
 	if r.cache != nil {
 		cacheKey := fmt.Sprintf("user:%s", id.String())
 		userJSON, _ := json.Marshal(user)
-		_ = r.cache.Set(context.Background(), cacheKey, string(userJSON), 5*time.Minute)
+		cacheCtx, cancel := withTimeout(ctx, r.timeouts.Cache)
+		_ = r.cache.Set(cacheCtx, cacheKey, string(userJSON), time.Duration(r.cacheTTL.Load()))
+		cancel()
 	}
 
 	return &user, nil
 }
 
 // FindByEmail finds a user by email
-func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	queryCtx, cancel := withTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
 	var user models.User
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+	if err := r.db.WithContext(queryCtx).Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errUserNotFound()
 		}
-		r.log.Errorf("Failed to find user by email: %v", err)
-		return nil, err
+		r.log.WithContext(ctx).Errorf("Failed to find user by email: %v", err)
+		return nil, dbError(err, nil)
 	}
 
 	return &user, nil
 }
 
 // FindByUsername finds a user by username
-func (r *UserRepository) FindByUsername(username string) (*models.User, error) {
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	queryCtx, cancel := withTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
 	var user models.User
-	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+	if err := r.db.WithContext(queryCtx).Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errUserNotFound()
 		}
-		r.log.Errorf("Failed to find user by username: %v", err)
-		return nil, err
+		r.log.WithContext(ctx).Errorf("Failed to find user by username: %v", err)
+		return nil, dbError(err, nil)
 	}
 
 	return &user, nil
 }
 
-// List retrieves users with pagination
-func (r *UserRepository) List(page, limit int) ([]models.User, int64, error) {
+// List retrieves users matching filters with pagination
+func (r *UserRepository) List(ctx context.Context, page, limit int, filters ...PreferenceFilter) ([]models.User, int64, error) {
 	var users []models.User
 	var total int64
 
 	offset := (page - 1) * limit
+	queryCtx, cancel := withTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+	db := r.db.WithContext(queryCtx)
+	for _, filter := range filters {
+		db = wherePreference(db, filter)
+	}
 
 	// Count total records
-	if err := r.db.Model(&models.User{}).Count(&total).Error; err != nil {
-		r.log.Errorf("Failed to count users: %v", err)
-		return nil, 0, err
+	if err := db.Model(&models.User{}).Count(&total).Error; err != nil {
+		r.log.WithContext(ctx).Errorf("Failed to count users: %v", err)
+		return nil, 0, dbError(err, nil)
 	}
 
-	// Query with pagination
-	if err := r.db.Offset(offset).Limit(limit).Find(&users).Error; err != nil {
-		r.log.Errorf("Failed to list users: %v", err)
-		return nil, 0, err
+	// Query with pagination, oldest first so pages are stable
+	if err := db.Order("created_at, id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		r.log.WithContext(ctx).Errorf("Failed to list users: %v", err)
+		return nil, 0, dbError(err, nil)
 	}
 
 	return users, total, nil
 }
 
-// Update updates a user
-func (r *UserRepository) Update(id uuid.UUID, updates map[string]interface{}) error {
+// Update updates a user if it is at version, or any version for AnyVersion
+func (r *UserRepository) Update(ctx context.Context, id uuid.UUID, version int64, updates map[string]interface{}) error {
 	updates["updated_at"] = time.Now()
+	updates["version"] = gorm.Expr("version + 1")
 
-	if err := r.db.Model(&models.User{}).Where("id = ?", id).Updates(updates).Error; err != nil {
-		r.log.Errorf("Failed to update user: %v", err)
-		return err
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+	db := r.db.WithContext(writeCtx)
+
+	result := whereVersion(db.Model(&models.User{}).Where("id = ?", id), version).Updates(updates)
+	if result.Error != nil {
+		r.log.WithContext(ctx).Errorf("Failed to update user: %v", result.Error)
+		return dbError(result.Error, nil)
 	}
 
 	// Invalidate cache
 	if r.cache != nil {
-		cacheKey := fmt.Sprintf("user:%s", id.String())
-		_ = r.cache.Delete(context.Background(), cacheKey)
+		r.invalidate(ctx, id)
 	}
 
+	if result.RowsAffected == 0 && version != AnyVersion {
+		return versionError(db.Model(&models.User{}).Where("id = ?", id), errUserNotFound, errUserVersion)
+	}
 	return nil
 }
 
-// Delete soft deletes a user
-func (r *UserRepository) Delete(id uuid.UUID) error {
-	if err := r.db.Delete(&models.User{}, id).Error; err != nil {
-		r.log.Errorf("Failed to delete user: %v", err)
-		return err
+// Delete soft deletes a user if it is at version, or any version for AnyVersion
+func (r *UserRepository) Delete(ctx context.Context, id uuid.UUID, version int64) error {
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+	db := r.db.WithContext(writeCtx)
+
+	result := whereVersion(db.Where("id = ?", id), version).Delete(&models.User{})
+	if result.Error != nil {
+		r.log.WithContext(ctx).Errorf("Failed to delete user: %v", result.Error)
+		return dbError(result.Error, nil)
 	}
 
 	// Invalidate cache
 	if r.cache != nil {
-		cacheKey := fmt.Sprintf("user:%s", id.String())
-		_ = r.cache.Delete(context.Background(), cacheKey)
+		r.invalidate(ctx, id)
 	}
 
+	if result.RowsAffected == 0 && version != AnyVersion {
+		return versionError(db.Model(&models.User{}).Where("id = ?", id), errUserNotFound, errUserVersion)
+	}
+	return nil
+}
+
+// RehashPassword replaces the password hash of a user if it is still current
+func (r *UserRepository) RehashPassword(ctx context.Context, id uuid.UUID, current, hash string) error {
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	// UpdateColumn leaves updated_at alone
+	err := r.db.WithContext(writeCtx).Model(&models.User{}).
+		Where("id = ? AND password_hash = ?", id, current).
+		UpdateColumn("password_hash", hash).Error
+	if err != nil {
+		r.log.WithContext(ctx).Errorf("Failed to rehash password: %v", err)
+		return dbError(err, nil)
+	}
 	return nil
 }
 
+// FindCredentials finds a user by ID with the password hash and lockout
+// state, bypassing the cache
+func (r *UserRepository) FindCredentials(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	queryCtx, cancel := withTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	var user models.User
+	if err := r.db.WithContext(queryCtx).Where("id = ?", id).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errUserNotFound()
+		}
+		r.log.WithContext(ctx).Errorf("Failed to find user credentials: %v", err)
+		return nil, dbError(err, nil)
+	}
+
+	return &user, nil
+}
+
+// RecordLoginFailure counts a wrong password, locking the user for lockFor
+// at threshold consecutive failures
+func (r *UserRepository) RecordLoginFailure(ctx context.Context, id uuid.UUID, threshold int, lockFor time.Duration) (*time.Time, error) {
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	// One statement, so concurrent failures are all counted
+	var result struct {
+		FailedLogins int
+		LockedUntil  *time.Time
+	}
+	err := r.db.WithContext(writeCtx).Raw(
+		"UPDATE users SET "+
+			"failed_logins = CASE WHEN failed_logins + 1 >= ? THEN 0 ELSE failed_logins + 1 END, "+
+			"locked_until = CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END "+
+			"WHERE id = ? AND deleted_at IS NULL RETURNING failed_logins, locked_until",
+		threshold, threshold, time.Now().Add(lockFor), id).
+		Scan(&result).Error
+	if err != nil {
+		r.log.WithContext(ctx).Errorf("Failed to record login failure: %v", err)
+		return nil, dbError(err, nil)
+	}
+
+	if result.FailedLogins == 0 && result.LockedUntil != nil {
+		return result.LockedUntil, nil
+	}
+	return nil, nil
+}
+
+// RecordLogin clears the failed logins and lock of a user and sets its last
+// login time
+func (r *UserRepository) RecordLogin(ctx context.Context, id uuid.UUID) error {
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	// UpdateColumns leaves updated_at alone
+	err := r.db.WithContext(writeCtx).Model(&models.User{}).Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{
+			"failed_logins": 0,
+			"locked_until":  nil,
+			"last_login_at": time.Now(),
+		}).Error
+	if err != nil {
+		r.log.WithContext(ctx).Errorf("Failed to record login: %v", err)
+		return dbError(err, nil)
+	}
+
+	if r.cache != nil {
+		r.invalidate(ctx, id)
+	}
+	return nil
+}
+
+// ChangePassword replaces the password hash of a user, keeping the keep
+// newest previous hashes, and revokes the user's refresh tokens
+func (r *UserRepository) ChangePassword(ctx context.Context, id uuid.UUID, hash string, keep int) error {
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	err := r.db.WithContext(writeCtx).Transaction(func(tx *gorm.DB) error {
+		// Lock the user so concurrent changes record each previous hash once
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "password_hash").
+			Where("id = ?", id).First(&user).Error; err != nil {
+			return err
+		}
+
+		if keep > 0 {
+			entry := &models.PasswordHistory{ID: uuid.New(), UserID: id, PasswordHash: user.PasswordHash, CreatedAt: now}
+			if err := tx.Create(entry).Error; err != nil {
+				return err
+			}
+			newest := tx.Model(&models.PasswordHistory{}).Select("id").Where("user_id = ?", id).
+				Order("created_at DESC, id DESC").Limit(keep)
+			if err := tx.Where("user_id = ? AND id NOT IN (?)", id, newest).Delete(&models.PasswordHistory{}).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Where("user_id = ?", id).Delete(&models.PasswordHistory{}).Error; err != nil {
+			return err
+		}
+
+		err := tx.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"password_hash":       hash,
+			"password_changed_at": now,
+			"failed_logins":       0,
+			"locked_until":        nil,
+			"updated_at":          now,
+			"version":             gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// Refresh tokens belong to auth-service, which shares the database
+		// in the default deployment
+		if tx.Migrator().HasTable("refresh_tokens") {
+			return tx.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", now, id).Error
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			r.log.WithContext(ctx).Errorf("Failed to change password: %v", err)
+		}
+		return dbError(err, errUserNotFound)
+	}
+
+	if r.cache != nil {
+		r.invalidate(ctx, id)
+	}
+	return nil
+}
+
+// PasswordHistory returns up to limit previous password hashes of a user,
+// newest first
+func (r *UserRepository) PasswordHistory(ctx context.Context, id uuid.UUID, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	queryCtx, cancel := withTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	var hashes []string
+	err := r.db.WithContext(queryCtx).Model(&models.PasswordHistory{}).Where("user_id = ?", id).
+		Order("created_at DESC, id DESC").Limit(limit).Pluck("password_hash", &hashes).Error
+	if err != nil {
+		r.log.WithContext(ctx).Errorf("Failed to get password history: %v", err)
+		return nil, dbError(err, nil)
+	}
+	return hashes, nil
+}
+
+// RequestEmailChange stores a pending email change in place of any earlier
+// one of its user
+func (r *UserRepository) RequestEmailChange(ctx context.Context, change *models.EmailChange) error {
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	change.ID = uuid.New()
+	change.CreatedAt = time.Now()
+	err := r.db.WithContext(writeCtx).Transaction(func(tx *gorm.DB) error {
+		// Lock the user so concurrent requests replace each other in turn
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+			Where("id = ?", change.UserID).First(&user).Error; err != nil {
+			return err
+		}
+
+		// Deleted users keep their email, as the unique index covers them
+		var taken int64
+		if err := tx.Unscoped().Model(&models.User{}).
+			Where("email = ? AND id <> ?", change.NewEmail, change.UserID).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errDuplicate("idx_users_email")
+		}
+
+		if err := tx.Where("user_id = ? OR expires_at < ?", change.UserID, change.CreatedAt).
+			Delete(&models.EmailChange{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(change).Error
+	})
+	if err != nil {
+		err = dbError(err, errUserNotFound)
+		if !errors.Is(err, apperrors.ErrNotFound) && !errors.Is(err, apperrors.ErrConflict) {
+			r.log.WithContext(ctx).Errorf("Failed to request email change: %v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// ConfirmEmailChange applies the pending email change with the given token
+// hash, returning the updated user and its previous email
+func (r *UserRepository) ConfirmEmailChange(ctx context.Context, tokenHash string) (*models.User, string, error) {
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	var user models.User
+	var previous string
+	expired := false
+	err := r.db.WithContext(writeCtx).Transaction(func(tx *gorm.DB) error {
+		// Deleting the change before applying it uses the token once, even
+		// when it is confirmed concurrently
+		var changes []models.EmailChange
+		if err := tx.Clauses(clause.Returning{}).Where("token_hash = ?", tokenHash).
+			Delete(&changes).Error; err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		change := changes[0]
+		if !change.ExpiresAt.After(now) {
+			// Commit the deletion of the expired change
+			expired = true
+			return nil
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", change.UserID).First(&user).Error; err != nil {
+			return err
+		}
+		previous = user.Email
+
+		err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"email":       change.NewEmail,
+			"is_verified": true,
+			"updated_at":  now,
+			"version":     gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("id = ?", user.ID).First(&user).Error
+	})
+	if err == nil && expired {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		err = dbError(err, errEmailChangeNotFound)
+		if !errors.Is(err, apperrors.ErrNotFound) && !errors.Is(err, apperrors.ErrConflict) {
+			r.log.WithContext(ctx).Errorf("Failed to confirm email change: %v", err)
+		}
+		return nil, "", err
+	}
+
+	if r.cache != nil {
+		r.invalidate(ctx, user.ID)
+	}
+	return &user, previous, nil
+}
+
 // GetProfile retrieves user profile
-func (r *UserRepository) GetProfile(userID uuid.UUID) (*models.UserProfile, error) {
+func (r *UserRepository) GetProfile(ctx context.Context, userID uuid.UUID) (*models.UserProfile, error) {
+	queryCtx, cancel := withTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
 	var profile models.UserProfile
-	if err := r.db.Where("user_id = ?", userID).First(&profile).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("profile not found")
+	if err := r.db.WithContext(queryCtx).Where("user_id = ?", userID).First(&profile).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errProfileNotFound()
 		}
-		r.log.Errorf("Failed to get profile: %v", err)
-		return nil, err
+		r.log.WithContext(ctx).Errorf("Failed to get profile: %v", err)
+		return nil, dbError(err, nil)
 	}
 
 	return &profile, nil
 }
 
-// UpdateProfile updates user profile
-func (r *UserRepository) UpdateProfile(userID uuid.UUID, updates map[string]interface{}) error {
+// UpdateProfile updates user profile if it is at version, or any version for
+// AnyVersion
+func (r *UserRepository) UpdateProfile(ctx context.Context, userID uuid.UUID, version int64, updates map[string]interface{}) error {
 	updates["updated_at"] = time.Now()
+	updates["version"] = gorm.Expr("version + 1")
 
-	if err := r.db.Model(&models.UserProfile{}).Where("user_id = ?", userID).Updates(updates).Error; err != nil {
-		r.log.Errorf("Failed to update profile: %v", err)
-		return err
+	writeCtx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+	db := r.db.WithContext(writeCtx)
+
+	result := whereVersion(db.Model(&models.UserProfile{}).Where("user_id = ?", userID), version).Updates(updates)
+	if result.Error != nil {
+		r.log.WithContext(ctx).Errorf("Failed to update profile: %v", result.Error)
+		return dbError(result.Error, nil)
 	}
 
+	if result.RowsAffected == 0 && version != AnyVersion {
+		return versionError(db.Model(&models.UserProfile{}).Where("user_id = ?", userID), errProfileNotFound, errProfileVersion)
+	}
 	return nil
 }
 
+// UserStats returns the number of registered, active and verified users. The
+// aggregate may take longer than a lookup, so it is bounded by ctx alone.
+func (r *UserRepository) UserStats(ctx context.Context) (*models.UserStats, error) {
+	var stats models.UserStats
+	err := r.db.WithContext(ctx).Model(&models.User{}).
+		Select("COUNT(*) AS total, " +
+			"COUNT(*) FILTER (WHERE is_active) AS active, " +
+			"COUNT(*) FILTER (WHERE is_verified) AS verified").
+		Scan(&stats).Error
+	if err != nil {
+		r.log.WithContext(ctx).Errorf("Failed to count user stats: %v", err)
+		return nil, dbError(err, nil)
+	}
+
+	return &stats, nil
+}
+
 // ExistsByEmail checks if user exists by email
-func (r *UserRepository) ExistsByEmail(email string) bool {
-	var count int64
-	r.db.Model(&models.User{}).Where("email = ?", email).Count(&count)
-	return count > 0
+func (r *UserRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	return r.exists(ctx, "email = ?", email)
 }
 
 // ExistsByUsername checks if user exists by username
-func (r *UserRepository) ExistsByUsername(username string) bool {
+func (r *UserRepository) ExistsByUsername(ctx context.Context, username string) (bool, error) {
+	return r.exists(ctx, "username = ?", username)
+}
+
+func (r *UserRepository) exists(ctx context.Context, query string, value string) (bool, error) {
+	queryCtx, cancel := withTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
 	var count int64
-	r.db.Model(&models.User{}).Where("username = ?", username).Count(&count)
-	return count > 0
+	if err := r.db.WithContext(queryCtx).Model(&models.User{}).Where(query, value).Count(&count).Error; err != nil {
+		r.log.WithContext(ctx).Errorf("Failed to check if user exists: %v", err)
+		return false, dbError(err, nil)
+	}
+	return count > 0, nil
+}
+
+// whereVersion restricts a write to rows at version, unless it is AnyVersion
+func whereVersion(db *gorm.DB, version int64) *gorm.DB {
+	if version == AnyVersion {
+		return db
+	}
+	return db.Where("version = ?", version)
+}
+
+// wherePreference restricts a query on users to those whose profile
+// preferences match filter. Containment of the nested document can use the
+// GIN index on preferences.
+func wherePreference(db *gorm.DB, filter PreferenceFilter) *gorm.DB {
+	document := []byte(filter.Value)
+	for i := len(filter.Path) - 1; i >= 0; i-- {
+		name, _ := json.Marshal(filter.Path[i])
+		document = []byte(fmt.Sprintf("{%s:%s}", name, document))
+	}
+
+	if filter.IncludeUnset {
+		return db.Where("EXISTS (SELECT 1 FROM user_profiles p WHERE p.user_id = users.id AND (p.preferences @> ?::jsonb OR p.preferences #> ?::text[] IS NULL))",
+			string(document), textArray(filter.Path))
+	}
+	return db.Where("EXISTS (SELECT 1 FROM user_profiles p WHERE p.user_id = users.id AND p.preferences @> ?::jsonb)", string(document))
+}
+
+// textArray formats values as a Postgres text array literal
+func textArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		quoted[i] = `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+// versionError explains why a conditional write matched no rows: the row
+// selected by db is gone, or it is at another version
+func versionError(db *gorm.DB, notFound, mismatch func() error) error {
+	var count int64
+	if err := db.Count(&count).Error; err != nil {
+		return dbError(err, nil)
+	}
+	if count == 0 {
+		return notFound()
+	}
+	return mismatch()
+}
+
+// invalidate removes a cached user. It runs after a committed write, so it
+// must not be skipped because the caller has gone away meanwhile.
+func (r *UserRepository) invalidate(ctx context.Context, id uuid.UUID) {
+	cacheCtx, cancel := withTimeout(context.WithoutCancel(ctx), r.timeouts.Cache)
+	defer cancel()
+	_ = r.cache.Delete(cacheCtx, fmt.Sprintf("user:%s", id.String()))
+}
+
+// withTimeout derives a context for one operation, keeping the caller's
+// deadline when it is earlier
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
@@ -0,0 +1,71 @@
+package response
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/devsecops/user-service/internal/i18n"
+	"github.com/devsecops/user-service/internal/models"
+	"github.com/devsecops/user-service/pkg/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// Error writes an error response tagged with the request ID
+func Error(c *gin.Context, status int, detail models.ErrorDetail) {
+	c.JSON(status, newErrorResponse(c, detail))
+}
+
+// AbortWithError writes an error response tagged with the request ID and
+// stops the remaining handlers from running
+func AbortWithError(c *gin.Context, status int, detail models.ErrorDetail) {
+	c.AbortWithStatusJSON(status, newErrorResponse(c, detail))
+}
+
+// Language returns the language of the messages of error responses to the
+// request, negotiated from its Accept-Language header
+func Language(c *gin.Context) string {
+	return i18n.Negotiate(c.GetHeader("Accept-Language"))
+}
+
+// newErrorResponse tags detail with the request ID and translates its
+// message into the language of the request. Field messages are translated
+// by whoever builds the details, with i18n.Fields.
+func newErrorResponse(c *gin.Context, detail models.ErrorDetail) models.ErrorResponse {
+	lang := Language(c)
+	detail.Message = i18n.Message(lang, detail.Code, detail.Message)
+	c.Header("Content-Language", lang)
+	c.Writer.Header().Add("Vary", "Accept-Language")
+
+	return models.ErrorResponse{
+		Error:     detail,
+		RequestID: requestid.FromContext(c.Request.Context()),
+	}
+}
+
+// StatusClientClosedRequest is the non-standard status logged when the
+// client went away before the response was written
+const StatusClientClosedRequest = 499
+
+// ContextError writes the response for an error caused by the request being
+// cancelled or running out of time, and reports whether it did. A cancelled
+// request gets 499, a timed out one 503 so the client may retry.
+func ContextError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(c.Request.Context().Err(), context.Canceled):
+		Error(c, StatusClientClosedRequest, models.ErrorDetail{
+			Code:    apperrors.CodeRequestCanceled,
+			Message: "Request was cancelled by the client",
+		})
+	case errors.Is(err, context.DeadlineExceeded) || c.Request.Context().Err() != nil:
+		c.Header("Retry-After", "1")
+		Error(c, http.StatusServiceUnavailable, models.ErrorDetail{
+			Code:    apperrors.CodeRequestTimeout,
+			Message: "Request timed out, please try again",
+		})
+	default:
+		return false
+	}
+	return true
+}
@@ -1,60 +1,201 @@
 package routes
 
 import (
-	"time"
+	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/internal/emailchange"
 	"github.com/devsecops/user-service/internal/handlers"
+	"github.com/devsecops/user-service/internal/health"
+	"github.com/devsecops/user-service/internal/idempotency"
+	"github.com/devsecops/user-service/internal/metrics"
 	"github.com/devsecops/user-service/internal/middleware"
+	"github.com/devsecops/user-service/internal/notify"
+	"github.com/devsecops/user-service/internal/password"
+	"github.com/devsecops/user-service/internal/preferences"
+	"github.com/devsecops/user-service/internal/ratelimit"
 	"github.com/devsecops/user-service/internal/repository"
+	"github.com/devsecops/user-service/internal/validation"
+	"github.com/devsecops/user-service/pkg/mergepatch"
 	pkgRedis "github.com/devsecops/user-service/pkg/redis"
+	"github.com/devsecops/user-service/pkg/requestid"
+	"github.com/devsecops/user-service/pkg/tracing"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
 // SetupRoutes configures all routes for the application
-func SetupRoutes(router *gin.Engine, db *gorm.DB, redisClient *pkgRedis.RedisClient, cfg *config.Config, log *logrus.Logger) {
+func SetupRoutes(router *gin.Engine, db *gorm.DB, redisClient *pkgRedis.RedisClient, idempotencyStore *idempotency.Store, rateLimiter *ratelimit.Limiter, healthChecks *health.Registry, configs *config.Store, log *logrus.Logger) error {
+	cfg := configs.Current()
+
+	// Only honour forwarding headers set by trusted proxies
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	router.RemoteIPHeaders = ginRemoteIPHeaders(cfg.ClientIPHeaders)
+
+	clientIPResolver, err := middleware.NewClientIPResolver(cfg.TrustedProxies, cfg.ClientIPHeaders)
+	if err != nil {
+		return err
+	}
+
 	// Global middleware
-	router.Use(gin.Recovery())
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.ClientIPMiddleware(clientIPResolver))
+	router.Use(middleware.TracingMiddleware())
+	router.Use(middleware.MetricsMiddleware())
 	router.Use(middleware.LoggingMiddleware(log))
-	router.Use(middleware.CORSMiddleware())
-
-	// Rate limiting (100 requests per minute)
-	router.Use(middleware.RateLimitMiddleware(int64(cfg.RateLimitRequests), time.Duration(cfg.RateLimitWindow)*time.Second))
+	// Recover inside tracing, metrics and logging so they see the 500 of a
+	// panicking request
+	router.Use(middleware.RecoveryMiddleware(log))
+	router.Use(middleware.ErrorMiddleware(log))
+	corsPolicy, err := middleware.NewCORS(cfg)
+	if err != nil {
+		return err
+	}
+	router.Use(corsPolicy.Middleware())
+	router.Use(middleware.SecurityHeadersMiddleware(cfg))
+	// Count every request per IP before authentication; the policy's
+	// exempt routes and CIDRs skip it
+	router.Use(middleware.IPRateLimitMiddleware(rateLimiter))
+	router.Use(middleware.BodyLimitMiddleware(cfg.MaxBodyBytes))
+	router.Use(middleware.TimeoutMiddleware(cfg.RequestTimeout))
 
 	// Initialize repositories
-	userRepo := repository.NewUserRepository(db, redisClient, log)
+	// A nil *RedisClient must not become a non-nil CacheInterface
+	var cache repository.CacheInterface
+	if redisClient != nil {
+		cache = redisClient
+	}
+	userRepo := repository.NewUserRepository(db, cache, cfg.CacheTTL, repository.Timeouts{
+		Query: cfg.DBQueryTimeout,
+		Write: cfg.DBWriteTimeout,
+		Cache: cfg.CacheTimeout,
+	}, log)
+
+	// Apply reloadable settings when the configuration changes
+	configs.OnReload(func(cfg *config.Config) {
+		if err := corsPolicy.Update(cfg); err != nil {
+			log.Errorf("Failed to apply CORS policy, keeping previous policy: %v", err)
+		}
+		userRepo.SetCacheTTL(cfg.CacheTTL)
+	})
+
+	// Register Prometheus collectors
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	if err := metrics.Register(prometheus.DefaultRegisterer, cfg.ServiceName, sqlDB, userRepo); err != nil {
+		return fmt.Errorf("failed to register metrics: %w", err)
+	}
+
+	if err := validation.Register(cfg); err != nil {
+		return err
+	}
+	prefs, err := preferences.Load(cfg.PreferencesSchemaFile)
+	if err != nil {
+		return err
+	}
+	passwords, err := password.New(cfg, log)
+	if err != nil {
+		return err
+	}
+	notifier := notify.New(cfg.NotificationURL, &http.Client{
+		Timeout:   cfg.NotificationTimeout,
+		Transport: tracing.NewTransport(requestid.NewTransport(nil)),
+	}, log)
+	emails := emailchange.New(cfg, notifier)
 
 	// Initialize handlers
-	healthHandler := handlers.NewHealthHandler(db)
-	userHandler := handlers.NewUserHandler(userRepo, log)
+	healthHandler := handlers.NewHealthHandler(healthChecks, configs)
+	userHandler := handlers.NewUserHandler(userRepo, prefs, passwords, emails, cfg.RequireIfMatch, log)
 
 	// Health check routes (no auth required)
 	router.GET("/health", healthHandler.Health)
 	router.GET("/health/ready", healthHandler.ReadinessCheck)
 	router.GET("/health/live", healthHandler.LivenessCheck)
+	router.GET("/health/startup", healthHandler.StartupCheck)
 
-	// Prometheus metrics (no auth required)
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	// Prometheus metrics (no auth required), unless served on the admin port
+	if cfg.MetricsPort == "" {
+		router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	}
 
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
-		// User routes (protected by auth middleware)
+		// User routes (protected by auth middleware, rate limited per identity)
 		users := v1.Group("/users")
-		users.Use(middleware.AuthMiddleware(cfg))
+		users.Use(middleware.AuthMiddleware(cfg, userRepo))
+		users.Use(middleware.RateLimitMiddleware(rateLimiter))
+		users.Use(middleware.ContentTypeMiddleware("application/json", mergepatch.MediaType))
+		userBodyLimit := middleware.BodyLimitMiddleware(cfg.UserBodyBytes)
+		idempotent := middleware.IdempotencyMiddleware(idempotencyStore, cfg, log)
 		{
 			users.GET("", userHandler.ListUsers)
 			users.GET("/:id", userHandler.GetUser)
-			users.POST("", userHandler.CreateUser)
-			users.PUT("/:id", userHandler.UpdateUser)
+			users.POST("", userBodyLimit, idempotent, userHandler.CreateUser)
+			users.PUT("/:id", userBodyLimit, userHandler.UpdateUser)
+			users.PATCH("/:id", userBodyLimit, userHandler.PatchUser)
 			users.DELETE("/:id", userHandler.DeleteUser)
+			users.POST("/:id/password", userBodyLimit, userHandler.ChangePassword)
+			users.POST("/:id/email", userBodyLimit, userHandler.ChangeEmail)
 
 			// Profile routes
 			users.GET("/:id/profile", userHandler.GetProfile)
-			users.PUT("/:id/profile", userHandler.UpdateProfile)
+			users.PUT("/:id/profile", userBodyLimit, userHandler.UpdateProfile)
+			users.PATCH("/:id/profile", userBodyLimit, userHandler.PatchProfile)
+
+			// Preference routes
+			users.GET("/:id/preferences", userHandler.GetPreferences)
+			users.GET("/:id/preferences/:key", userHandler.GetPreference)
+			users.PUT("/:id/preferences/:key", userBodyLimit, userHandler.SetPreference)
+			users.DELETE("/:id/preferences/:key", userHandler.DeletePreference)
+		}
+
+		// Preferences schema (protected by auth middleware)
+		v1.GET("/preferences/schema", middleware.AuthMiddleware(cfg, userRepo), userHandler.PreferencesSchema)
+
+		// Email confirmation (no auth: the token sent to the new address
+		// authorizes the change, so identity rules fall back to the client IP)
+		email := v1.Group("/email")
+		email.Use(middleware.RateLimitMiddleware(rateLimiter))
+		email.Use(middleware.ContentTypeMiddleware("application/json"))
+		email.Use(middleware.BodyLimitMiddleware(cfg.UserBodyBytes))
+		{
+			email.POST("/confirm", userHandler.ConfirmEmail)
+		}
+	}
+
+	// Internal routes for other services (protected by a shared token)
+	if cfg.InternalAPIToken != "" {
+		internal := router.Group("/internal/v1")
+		internal.Use(middleware.InternalAuthMiddleware(cfg.InternalAPIToken))
+		internal.Use(middleware.RateLimitMiddleware(rateLimiter))
+		internal.Use(middleware.ContentTypeMiddleware("application/json"))
+		internal.Use(middleware.BodyLimitMiddleware(cfg.UserBodyBytes))
+		{
+			internal.POST("/credentials/verify", userHandler.VerifyCredentials)
+		}
+	}
+
+	return nil
+}
+
+// ginRemoteIPHeaders returns the client IP headers gin can parse itself,
+// so c.ClientIP() agrees with ClientIPMiddleware for everything but Forwarded
+func ginRemoteIPHeaders(headers []string) []string {
+	var supported []string
+	for _, header := range headers {
+		if !strings.EqualFold(header, "Forwarded") {
+			supported = append(supported, header)
 		}
 	}
+	return supported
 }
@@ -0,0 +1,55 @@
+package validation
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devsecops/user-service/internal/apperrors"
+	"github.com/go-playground/validator/v10"
+)
+
+// Fields describes each failed field by its JSON path, such as
+// address.city for nested structs, and the rule it breaks, sorted by field
+func Fields(errs validator.ValidationErrors) []apperrors.FieldError {
+	fields := make([]apperrors.FieldError, len(errs))
+	for i, fieldErr := range errs {
+		fields[i] = apperrors.FieldError{
+			Field: Field(fieldErr),
+			Rule:  Rule(fieldErr),
+			Param: fieldErr.Param(),
+		}
+		if fieldErr.Tag() == "min_age" && fields[i].Param == "" {
+			fields[i].Param = strconv.Itoa(minUserAge)
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields
+}
+
+// Field returns the JSON path of a failed field, without the name of the
+// request struct
+func Field(fieldErr validator.FieldError) string {
+	if _, path, ok := strings.Cut(fieldErr.Namespace(), "."); ok {
+		return path
+	}
+	return fieldErr.Field()
+}
+
+// Rule returns the rule a field broke: its validator tag, except that min
+// and max are named after what they bound, a length for strings and a
+// number of items for collections, as in the preferences schema
+func Rule(fieldErr validator.FieldError) string {
+	tag := fieldErr.Tag()
+	if tag != "min" && tag != "max" {
+		return tag
+	}
+	switch fieldErr.Kind() {
+	case reflect.String:
+		return tag + "_length"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return tag + "_items"
+	}
+	return tag
+}
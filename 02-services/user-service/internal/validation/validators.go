@@ -0,0 +1,164 @@
+// Package validation registers the field validators of request models with
+// gin's validator and describes their failures field by field
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// Time zones are validated against the embedded IANA database, so
+	// results do not depend on the zoneinfo files of the image
+	_ "time/tzdata"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/text/language"
+)
+
+var (
+	// timezonePattern matches IANA zone names such as Europe/Paris or UTC,
+	// ruling out file paths and "Local"
+	timezonePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+)*$`)
+
+	// e164Pattern matches E.164 numbers: a plus sign and up to 15 digits,
+	// the first of which (the country code) is not zero
+	e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+	// minUserAge is the age min_age requires without a parameter
+	minUserAge int
+)
+
+// Register adds the custom validators to gin's validator and makes it report
+// fields by their JSON names:
+//
+//   - iana_timezone: an IANA time zone name, e.g. Europe/Paris
+//   - bcp47_tag: a well-formed BCP 47 language tag, e.g. en or pt-BR
+//   - country_alpha2: an ISO 3166-1 alpha-2 country code, e.g. FR
+//   - e164_phone: an E.164 phone number, e.g. +33123456789
+//   - avatar_url: an HTTPS URL on one of cfg.AvatarAllowedHosts
+//   - min_age: a date of birth at least cfg.MinUserAge years ago, or the
+//     number of years given as parameter (min_age=18)
+func Register(cfg *config.Config) error {
+	minUserAge = cfg.MinUserAge
+
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return fmt.Errorf("unsupported validator engine %T", binding.Validator.Engine())
+	}
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			return ""
+		case "":
+			return field.Name
+		}
+		return name
+	})
+
+	validators := map[string]validator.Func{
+		"iana_timezone":  isTimezone,
+		"bcp47_tag":      isLanguageTag,
+		"country_alpha2": isCountryCode,
+		"e164_phone":     isE164,
+		"avatar_url":     avatarURL(cfg.AvatarAllowedHosts),
+		"min_age":        minAge(cfg.MinUserAge, time.Now),
+	}
+	for tag, fn := range validators {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			return fmt.Errorf("failed to register %s validator: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+func isTimezone(fl validator.FieldLevel) bool {
+	name := fl.Field().String()
+	if !timezonePattern.MatchString(name) || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+func isLanguageTag(fl validator.FieldLevel) bool {
+	tag := fl.Field().String()
+	// The parser also accepts underscores, which BCP 47 does not
+	if strings.Contains(tag, "_") {
+		return false
+	}
+	_, err := language.Parse(tag)
+	return err == nil
+}
+
+func isCountryCode(fl validator.FieldLevel) bool {
+	code := fl.Field().String()
+	if len(code) != 2 || strings.ToUpper(code) != code {
+		return false
+	}
+	// Deprecated and reserved codes such as UK canonicalize to another one
+	region, err := language.ParseRegion(code)
+	return err == nil && region.IsCountry() && region.Canonicalize() == region
+}
+
+func isE164(fl validator.FieldLevel) bool {
+	return e164Pattern.MatchString(fl.Field().String())
+}
+
+// avatarURL accepts absolute HTTPS URLs without credentials whose host is
+// one of hosts, or a subdomain of a *.domain entry. Any host is accepted if
+// hosts is empty.
+func avatarURL(hosts []string) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		u, err := url.Parse(fl.Field().String())
+		if err != nil || u.Scheme != "https" || u.User != nil || u.Hostname() == "" {
+			return false
+		}
+		if len(hosts) == 0 {
+			return true
+		}
+
+		host := strings.ToLower(u.Hostname())
+		for _, allowed := range hosts {
+			allowed = strings.ToLower(allowed)
+			if domain, ok := strings.CutPrefix(allowed, "*."); ok {
+				if strings.HasSuffix(host, "."+domain) {
+					return true
+				}
+			} else if host == allowed {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// minAge accepts dates of birth at least years before today, or the number
+// of years given as the tag's parameter. Dates in the future never pass.
+func minAge(years int, now func() time.Time) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		birth, ok := fl.Field().Interface().(time.Time)
+		if !ok {
+			return false
+		}
+
+		required := years
+		if param := fl.Param(); param != "" {
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				panic(fmt.Sprintf("invalid min_age parameter %q", param))
+			}
+			required = n
+		}
+
+		today := now()
+		return !birth.After(today) && !birth.AddDate(required, 0, 0).After(today)
+	}
+}
@@ -0,0 +1,12 @@
+package version
+
+// Build information, injected at link time:
+//
+//	go build -ldflags "-X github.com/devsecops/user-service/internal/version.Version=1.2.3 \
+//	  -X github.com/devsecops/user-service/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/devsecops/user-service/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Credentials authenticate connections to PostgreSQL
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Connector opens PostgreSQL connections with the current credentials.
+// Rotating the credentials only affects connections opened afterwards, so the
+// pool keeps serving queries while it is refreshed.
+type Connector struct {
+	base *pgx.ConnConfig
+	dial func(ctx context.Context, config pgx.ConnConfig) (driver.Conn, error)
+
+	mu    sync.RWMutex
+	creds Credentials
+}
+
+// NewConnector creates a connector for the database configured in cfg
+func NewConnector(cfg *config.Config, creds Credentials) (*Connector, error) {
+	// Credentials are set per connection, so they are left out of the DSN
+	dsn := fmt.Sprintf(
+		"host=%s port=%s dbname=%s sslmode=%s",
+		cfg.DBHost,
+		cfg.DBPort,
+		cfg.DBName,
+		cfg.DBSSLMode,
+	)
+
+	base, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database config: %w", err)
+	}
+
+	return &Connector{base: base, dial: dial, creds: creds}, nil
+}
+
+// dial opens a connection with pgx
+func dial(ctx context.Context, config pgx.ConnConfig) (driver.Conn, error) {
+	return stdlib.GetConnector(config).Connect(ctx)
+}
+
+// Connect implements driver.Connector
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.RLock()
+	creds := c.creds
+	c.mu.RUnlock()
+
+	connConfig := c.base.Copy()
+	connConfig.User = creds.Username
+	connConfig.Password = creds.Password
+
+	return c.dial(ctx, *connConfig)
+}
+
+// Driver implements driver.Connector
+func (c *Connector) Driver() driver.Driver {
+	return stdlib.GetDefaultDriver()
+}
+
+// Rotate switches to new credentials and closes the idle connections of
+// sqlDB that were opened with the old ones. Connections in use finish their
+// current work and are retired once they reach the pool's max lifetime.
+func (c *Connector) Rotate(sqlDB *sql.DB, creds Credentials, maxIdleConns int) {
+	c.mu.Lock()
+	c.creds = creds
+	c.mu.Unlock()
+
+	sqlDB.SetMaxIdleConns(0)
+	sqlDB.SetMaxIdleConns(maxIdleConns)
+}
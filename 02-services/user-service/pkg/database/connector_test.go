@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/pkg/secrets"
+	"github.com/devsecops/user-service/pkg/secrets/vaulttest"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeServer stands in for PostgreSQL, accepting the users Vault has leases for
+type fakeServer struct {
+	vault *vaulttest.Server
+
+	mu      sync.Mutex
+	conns   []*fakeConn
+	release chan struct{}
+}
+
+func (s *fakeServer) dial(ctx context.Context, config pgx.ConnConfig) (driver.Conn, error) {
+	for _, lease := range s.vault.Leases() {
+		if lease.Username == config.User && lease.Password == config.Password {
+			conn := &fakeConn{server: s, user: config.User}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("password authentication failed for user %q", config.User)
+}
+
+func (s *fakeServer) connections() []*fakeConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*fakeConn(nil), s.conns...)
+}
+
+type fakeConn struct {
+	server *fakeServer
+	user   string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *fakeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// ExecContext implements driver.ExecerContext. The query "wait" runs until
+// the server releases it.
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.isClosed() {
+		return nil, driver.ErrBadConn
+	}
+	if query == "wait" {
+		select {
+		case <-c.server.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestRotateVaultCredentials(t *testing.T) {
+	vault := vaulttest.NewServer("root")
+	t.Cleanup(vault.Close)
+	vault.AddDatabaseRole("database", "user-service", time.Second, time.Hour)
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := secrets.NewVaultClient(&config.Config{
+		VaultAddr:       vault.URL,
+		VaultAuthMethod: "token",
+		VaultToken:      "root",
+	}, http.DefaultClient)
+	creds, err := secrets.NewDatabaseCredentials(ctx, client, "database", "user-service", log)
+	if err != nil {
+		t.Fatalf("NewDatabaseCredentials: %v", err)
+	}
+	first := creds.Current()
+
+	connector, err := NewConnector(&config.Config{
+		DBHost:    "localhost",
+		DBPort:    "5432",
+		DBName:    "users",
+		DBSSLMode: "disable",
+	}, Credentials{Username: first.Username, Password: first.Password})
+	if err != nil {
+		t.Fatalf("NewConnector: %v", err)
+	}
+	server := &fakeServer{vault: vault, release: make(chan struct{})}
+	connector.dial = server.dial
+
+	const maxIdleConns = 2
+	sqlDB := sql.OpenDB(connector)
+	defer sqlDB.Close()
+	sqlDB.SetMaxIdleConns(maxIdleConns)
+
+	// One query stays in flight across the rotation, one connection idles
+	inFlight := make(chan error, 1)
+	go func() {
+		_, err := sqlDB.ExecContext(ctx, "wait")
+		inFlight <- err
+	}()
+	for len(server.connections()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := sqlDB.ExecContext(ctx, "select"); err != nil {
+		t.Fatalf("query before rotation: %v", err)
+	}
+	before := server.connections()
+	if len(before) != 2 {
+		t.Fatalf("got %d connections before rotation, want 2", len(before))
+	}
+
+	rotated := make(chan secrets.Lease, 1)
+	go creds.Run(ctx, func(lease secrets.Lease) {
+		connector.Rotate(sqlDB, Credentials{Username: lease.Username, Password: lease.Password}, maxIdleConns)
+		rotated <- lease
+	})
+
+	// Let the lease be renewed once, then make the next renewal fail
+	deadline := time.Now().Add(5 * time.Second)
+	for vault.Leases()[0].Expires.Sub(vault.Leases()[0].Issued) <= time.Second {
+		if time.Now().After(deadline) {
+			t.Fatal("lease was not renewed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	vault.RevokeLease(first.ID)
+
+	var lease secrets.Lease
+	select {
+	case lease = <-rotated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("credentials were not rotated after renewal failed")
+	}
+
+	// Idle connections with the revoked credentials are closed, the busy
+	// one is left alone
+	for _, conn := range before {
+		if conn.user != first.Username {
+			t.Fatalf("connection opened as %s, want %s", conn.user, first.Username)
+		}
+	}
+	closed := 0
+	for _, conn := range before {
+		if conn.isClosed() {
+			closed++
+		}
+	}
+	if closed != 1 {
+		t.Errorf("%d connections closed by rotation, want only the idle one", closed)
+	}
+
+	// New queries connect with the new credentials while the old query runs
+	if _, err := sqlDB.ExecContext(ctx, "select"); err != nil {
+		t.Fatalf("query after rotation: %v", err)
+	}
+	after := server.connections()
+	if len(after) != 3 || after[2].user != lease.Username {
+		t.Fatalf("query after rotation did not open a connection as %s", lease.Username)
+	}
+
+	close(server.release)
+	select {
+	case err := <-inFlight:
+		if err != nil {
+			t.Errorf("in-flight query failed across rotation: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in-flight query did not finish")
+	}
+}
@@ -1,8 +1,9 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
-	"time"
 
 	"github.com/devsecops/user-service/internal/config"
 	"github.com/devsecops/user-service/internal/models"
@@ -11,18 +12,9 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-// NewPostgresDB creates a new PostgreSQL database connection
-func NewPostgresDB(cfg *config.Config) (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.DBHost,
-		cfg.DBPort,
-		cfg.DBUser,
-		cfg.DBPassword,
-		cfg.DBName,
-		cfg.DBSSLMode,
-	)
-
+// NewPostgresDB creates a new PostgreSQL database connection whose
+// connections are opened by connector
+func NewPostgresDB(cfg *config.Config, connector *Connector) (*gorm.DB, error) {
 	// Configure GORM logger
 	gormConfig := &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
@@ -33,11 +25,16 @@ func NewPostgresDB(cfg *config.Config) (*gorm.DB, error) {
 	}
 
 	// Open database connection
-	db, err := gorm.Open(postgres.Open(dsn), gormConfig)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(connector)}), gormConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	// Trace every query as a child of the request span
+	if err := db.Use(TracingPlugin{}); err != nil {
+		return nil, fmt.Errorf("failed to register tracing plugin: %w", err)
+	}
+
 	// Get underlying SQL DB
 	sqlDB, err := db.DB()
 	if err != nil {
@@ -47,7 +44,7 @@ func NewPostgresDB(cfg *config.Config) (*gorm.DB, error) {
 	// Set connection pool settings
 	sqlDB.SetMaxOpenConns(cfg.DBMaxConnections)
 	sqlDB.SetMaxIdleConns(cfg.DBMaxIdleConns)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	sqlDB.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
 
 	// Test connection
 	if err := sqlDB.Ping(); err != nil {
@@ -59,8 +56,48 @@ func NewPostgresDB(cfg *config.Config) (*gorm.DB, error) {
 
 // AutoMigrate runs database migrations
 func AutoMigrate(db *gorm.DB) error {
+	// Emails and usernames are citext so uniqueness and lookups ignore case
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS citext").Error; err != nil {
+		return fmt.Errorf("failed to create citext extension: %w", err)
+	}
+
+	if db.Migrator().HasTable(&models.User{}) {
+		if err := checkCaseInsensitiveDuplicates(db); err != nil {
+			return err
+		}
+	}
+
 	return db.AutoMigrate(
 		&models.User{},
 		&models.UserProfile{},
+		&models.PasswordHistory{},
+		&models.EmailChange{},
+		&models.IdempotencyKey{},
 	)
 }
+
+// checkCaseInsensitiveDuplicates refuses to convert existing columns to
+// citext while values differing only in case, e.g. Alice@example.com and
+// alice@example.com, would violate the unique constraints. Such accounts
+// must be merged or renamed by hand first.
+func checkCaseInsensitiveDuplicates(db *gorm.DB) error {
+	var errs []error
+	for _, column := range []string{"email", "username"} {
+		var duplicates []string
+		err := db.Raw(fmt.Sprintf(
+			"SELECT string_agg(%[1]s::text, ', ' ORDER BY %[1]s::text) FROM users "+
+				"GROUP BY lower(%[1]s::text) HAVING count(*) > 1", column)).
+			Scan(&duplicates).Error
+		if err != nil {
+			return fmt.Errorf("failed to check for duplicate %s values: %w", column, err)
+		}
+		for _, d := range duplicates {
+			errs = append(errs, fmt.Errorf("users.%s values differ only in case: %s", column, d))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot make emails and usernames case-insensitive: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package logger
+
+import (
+	"github.com/devsecops/user-service/pkg/requestid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ContextHook adds request-scoped fields to entries logged with WithContext
+type ContextHook struct{}
+
+// Levels returns the levels the hook fires for
+func (ContextHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire adds the request ID and trace/span IDs from the entry's context
+func (ContextHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+
+	if id := requestid.FromContext(entry.Context); id != "" {
+		entry.Data["request_id"] = id
+	}
+
+	if spanContext := trace.SpanContextFromContext(entry.Context); spanContext.IsValid() {
+		entry.Data["trace_id"] = spanContext.TraceID().String()
+		entry.Data["span_id"] = spanContext.SpanID().String()
+	}
+
+	return nil
+}
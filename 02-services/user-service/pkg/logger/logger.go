@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/sirupsen/logrus"
@@ -33,5 +34,18 @@ func NewLogger() *logrus.Logger {
 		log.SetLevel(logrus.InfoLevel)
 	}
 
+	// Add request-scoped fields to entries logged with WithContext
+	log.AddHook(ContextHook{})
+
 	return log
 }
+
+// SetLevel changes the level of log to one of debug, info, warn or error
+func SetLevel(log *logrus.Logger, level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	log.SetLevel(parsed)
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package mergepatch implements JSON Merge Patch (RFC 7396)
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MediaType is the media type of a JSON merge patch document
+const MediaType = "application/merge-patch+json"
+
+// Apply returns target with patch applied. Members of an object patch
+// replace those of the target, recursively for nested objects, and null
+// members remove them; any other patch replaces the whole target. An empty
+// target is treated as null.
+func Apply(target, patch []byte) ([]byte, error) {
+	var targetValue, patchValue interface{}
+	if len(bytes.TrimSpace(target)) > 0 {
+		if err := decode(target, &targetValue); err != nil {
+			return nil, fmt.Errorf("invalid merge patch target: %w", err)
+		}
+	}
+	if err := decode(patch, &patchValue); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+
+	return json.Marshal(merge(targetValue, patchValue))
+}
+
+// Object decodes a merge patch that must be a JSON object into its members
+func Object(patch []byte) (map[string]json.RawMessage, error) {
+	if trimmed := bytes.TrimSpace(patch); len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, fmt.Errorf("merge patch must be a JSON object")
+	}
+
+	var members map[string]json.RawMessage
+	if err := decode(patch, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// IsNull reports whether a raw member is the JSON null
+func IsNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+func merge(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = merge(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// decode decodes a single JSON value, keeping numbers exact
+func decode(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
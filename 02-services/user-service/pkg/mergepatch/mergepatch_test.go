@@ -0,0 +1,109 @@
+package mergepatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// equalJSON reports whether two documents hold the same JSON value,
+// regardless of member order and whitespace
+func equalJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("invalid JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// TestApplyRFC7396 runs the examples of RFC 7396, Appendix A
+func TestApplyRFC7396(t *testing.T) {
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{target: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{target: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{target: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{target: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{target: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{target: `{"a":"foo"}`, patch: `"bar"`, want: `"bar"`},
+		{target: `{"e":null}`, patch: `{"a":1}`, want: `{"e":null,"a":1}`},
+		{target: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.patch, func(t *testing.T) {
+			got, err := Apply([]byte(tt.target), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if !equalJSON(t, got, []byte(tt.want)) {
+				t.Errorf("Apply(%s, %s) = %s, want %s", tt.target, tt.patch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	// Members are marshalled in sorted order, so results compare as text
+	tests := []struct {
+		name    string
+		target  string
+		patch   string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty target is null", target: "", patch: `{"a":{"b":null,"c":1}}`, want: `{"a":{"c":1}}`},
+		{name: "numbers stay exact", target: `{"n":12345678901234567890}`, patch: `{"m":0.1}`, want: `{"m":0.1,"n":12345678901234567890}`},
+		{name: "invalid target", target: `{"a":`, patch: `{}`, wantErr: true},
+		{name: "invalid patch", target: `{}`, patch: `{"a":`, wantErr: true},
+		{name: "trailing data", target: `{}`, patch: `{} {}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.target), []byte(tt.patch))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Apply = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Apply(%s, %s) = %s, want %s", tt.target, tt.patch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObject(t *testing.T) {
+	members, err := Object([]byte(` {"a":null,"b":{"c":1}}`))
+	if err != nil {
+		t.Fatalf("Object: %v", err)
+	}
+	if len(members) != 2 || !IsNull(members["a"]) || IsNull(members["b"]) {
+		t.Errorf("Object = %s", members)
+	}
+
+	for _, patch := range []string{``, `null`, `["a"]`, `"a"`, `{"a":1} {}`} {
+		if _, err := Object([]byte(patch)); err == nil {
+			t.Errorf("Object(%q): expected an error", patch)
+		}
+	}
+}
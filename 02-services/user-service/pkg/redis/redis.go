@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -22,6 +23,9 @@ func NewRedisClient(cfg *config.Config) (*RedisClient, error) {
 		DB:       cfg.RedisDB,
 	})
 
+	// Trace every command as a child of the request span
+	client.AddHook(tracingHook{})
+
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -33,9 +37,13 @@ func NewRedisClient(cfg *config.Config) (*RedisClient, error) {
 	return &RedisClient{client: client}, nil
 }
 
-// Get retrieves a value from Redis
+// Get retrieves a value from Redis. A missing key returns an empty string and no error.
 func (r *RedisClient) Get(ctx context.Context, key string) (string, error) {
-	return r.client.Get(ctx, key).Result()
+	value, err := r.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return value, err
 }
 
 // Set stores a value in Redis with TTL
@@ -43,11 +51,22 @@ func (r *RedisClient) Set(ctx context.Context, key string, value interface{}, tt
 	return r.client.Set(ctx, key, value, ttl).Err()
 }
 
+// SetNX stores a value with TTL only if the key does not exist and reports
+// whether it was stored
+func (r *RedisClient) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, key, value, ttl).Result()
+}
+
 // Delete removes a key from Redis
 func (r *RedisClient) Delete(ctx context.Context, key string) error {
 	return r.client.Del(ctx, key).Err()
 }
 
+// Ping checks the Redis connection
+func (r *RedisClient) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
 // Close closes the Redis connection
 func (r *RedisClient) Close() error {
 	return r.client.Close()
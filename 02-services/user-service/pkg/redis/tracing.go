@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/devsecops/user-service/pkg/tracing"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingHook creates a child span for every Redis command and pipeline
+type tracingHook struct{}
+
+var _ redis.Hook = tracingHook{}
+
+// BeforeProcess starts a span for a single command
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = tracing.Tracer().Start(ctx, "redis."+cmd.Name(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperation(cmd.Name()),
+		),
+	)
+	return ctx, nil
+}
+
+// AfterProcess ends the span started by BeforeProcess
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(trace.SpanFromContext(ctx), cmd.Err())
+	return nil
+}
+
+// BeforeProcessPipeline starts a span covering all commands of a pipeline
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+
+	ctx, _ = tracing.Tracer().Start(ctx, "redis.pipeline",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperation(strings.Join(names, " ")),
+			attribute.Int("db.redis.num_cmd", len(cmds)),
+		),
+	)
+	return ctx, nil
+}
+
+// AfterProcessPipeline ends the span started by BeforeProcessPipeline
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && err == nil {
+			err = cmdErr
+		}
+	}
+	endSpan(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+func endSpan(span trace.Span, err error) {
+	// A cache miss is not an error
+	if err != nil && !errors.Is(err, redis.Nil) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,80 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header carrying the request ID
+const Header = "X-Request-ID"
+
+// maxLength bounds client-supplied request IDs so they can't bloat logs
+const maxLength = 128
+
+type contextKey struct{}
+
+// New generates a new request ID
+func New() string {
+	return uuid.New().String()
+}
+
+// Valid reports whether a client-supplied request ID is safe to reuse
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Transport propagates the request ID of the outgoing request's context to
+// downstream services
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// NewTransport wraps base (or http.DefaultTransport when nil) with request ID propagation
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := FromContext(req.Context())
+	if id == "" || req.Header.Get(Header) != "" {
+		return t.Base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	clone := req.Clone(req.Context())
+	clone.Header.Set(Header, id)
+	return t.Base.RoundTrip(clone)
+}
@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// renewAfter is the fraction of a lease's TTL that elapses before renewal
+	renewAfter = 2.0 / 3.0
+
+	// minRenewedFraction of the original lease duration must be granted by a
+	// renewal; less means the max TTL is near and new credentials are issued
+	minRenewedFraction = 0.5
+
+	// retryInterval spaces out attempts after Vault errors
+	retryInterval = 10 * time.Second
+)
+
+// DatabaseCredentials keeps short-lived PostgreSQL credentials from the Vault
+// database secrets engine valid. The lease is renewed until Vault stops
+// extending it, then new credentials are issued and handed to the rotate
+// callback while the old ones are still valid.
+type DatabaseCredentials struct {
+	client *VaultClient
+	mount  string
+	role   string
+	log    *logrus.Logger
+
+	mu    sync.RWMutex
+	lease Lease
+	ttl   time.Duration
+}
+
+// NewDatabaseCredentials issues the initial credentials for role
+func NewDatabaseCredentials(ctx context.Context, client *VaultClient, mount, role string, log *logrus.Logger) (*DatabaseCredentials, error) {
+	lease, err := issueLease(ctx, client, mount, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DatabaseCredentials{
+		client: client,
+		mount:  mount,
+		role:   role,
+		log:    log,
+		lease:  lease,
+		ttl:    lease.Duration,
+	}, nil
+}
+
+// Current returns the active credentials
+func (d *DatabaseCredentials) Current() Lease {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.lease
+}
+
+// ConnMaxLifetime is the longest a pooled connection may live so that
+// connections opened with replaced credentials close before their lease
+// expires. Credentials are replaced with at least a sixth of the lease left,
+// which leaves a margin for slow queries.
+func (d *DatabaseCredentials) ConnMaxLifetime() time.Duration {
+	return d.Current().Duration / 8
+}
+
+// Run renews or replaces the credentials until ctx is cancelled, calling
+// rotate with every new set of credentials
+func (d *DatabaseCredentials) Run(ctx context.Context, rotate func(Lease)) {
+	for {
+		d.mu.RLock()
+		lease, ttl := d.lease, d.ttl
+		d.mu.RUnlock()
+
+		timer := time.NewTimer(time.Duration(float64(ttl) * renewAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if lease.Renewable {
+			granted, err := d.client.RenewLease(ctx, lease.ID, lease.Duration)
+			if err == nil && float64(granted) >= float64(lease.Duration)*minRenewedFraction {
+				d.mu.Lock()
+				d.ttl = granted
+				d.mu.Unlock()
+				d.log.WithField("ttl", granted).Debug("Database credentials lease renewed")
+				continue
+			}
+			if err != nil {
+				d.log.Warnf("Failed to renew database credentials lease, issuing new credentials: %v", err)
+			}
+		}
+
+		d.replace(ctx, rotate)
+	}
+}
+
+// replace issues new credentials, retrying until it succeeds or ctx is done
+func (d *DatabaseCredentials) replace(ctx context.Context, rotate func(Lease)) {
+	for {
+		lease, err := issueLease(ctx, d.client, d.mount, d.role)
+		if err == nil {
+			d.mu.Lock()
+			d.lease = lease
+			d.ttl = lease.Duration
+			d.mu.Unlock()
+
+			rotate(lease)
+			d.log.WithField("username", lease.Username).Info("Database credentials rotated")
+			return
+		}
+
+		d.log.Errorf("Failed to issue database credentials: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// issueLease issues credentials for role, rejecting leases without a
+// duration: they would be renewed in a tight loop and give pooled
+// connections an unlimited lifetime
+func issueLease(ctx context.Context, client *VaultClient, mount, role string) (Lease, error) {
+	lease, err := client.DatabaseCredentials(ctx, mount, role)
+	if err != nil {
+		return Lease{}, err
+	}
+	if lease.Duration <= 0 {
+		return Lease{}, fmt.Errorf("vault issued credentials for %s/%s with lease duration %s, want a positive TTL", mount, role, lease.Duration)
+	}
+	return lease, nil
+}
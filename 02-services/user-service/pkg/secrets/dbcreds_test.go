@@ -0,0 +1,144 @@
+package secrets
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDatabaseCredentialsRenewThenRotate(t *testing.T) {
+	server, client := newTestVault(t)
+	server.AddDatabaseRole("database", "user-service", time.Second, time.Hour)
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	creds, err := NewDatabaseCredentials(ctx, client, "database", "user-service", log)
+	if err != nil {
+		t.Fatalf("NewDatabaseCredentials: %v", err)
+	}
+	first := creds.Current()
+	issued := server.Leases()[0]
+	if first.ID != issued.ID || first.Username != issued.Username || first.Duration != time.Second {
+		t.Fatalf("got lease %+v, want %+v with a 1s duration", first, issued)
+	}
+
+	rotated := make(chan Lease, 1)
+	done := make(chan struct{})
+	go func() {
+		creds.Run(ctx, func(lease Lease) { rotated <- lease })
+		close(done)
+	}()
+
+	// The lease is renewed while Vault keeps extending it
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.Leases()[0].Expires.After(issued.Expires) {
+		if time.Now().After(deadline) {
+			t.Fatal("lease was not renewed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case lease := <-rotated:
+		t.Fatalf("credentials rotated to %s while the lease was renewable", lease.Username)
+	default:
+	}
+
+	// Once renewal fails, new credentials are issued and handed over
+	server.RevokeLease(first.ID)
+	select {
+	case lease := <-rotated:
+		leases := server.Leases()
+		latest := leases[len(leases)-1]
+		if lease.ID != latest.ID || lease.Username != latest.Username || lease.Password != latest.Password {
+			t.Errorf("rotated to %+v, want the newly issued lease %+v", lease, latest)
+		}
+		if lease.Username == first.Username {
+			t.Errorf("rotated to the revoked credentials %s", lease.Username)
+		}
+		if current := creds.Current(); current != lease {
+			t.Errorf("Current() = %+v after rotation, want %+v", current, lease)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("credentials were not rotated after renewal failed")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after ctx was cancelled")
+	}
+}
+
+func TestDatabaseCredentialsConnMaxLifetime(t *testing.T) {
+	server, client := newTestVault(t)
+	server.AddDatabaseRole("database", "user-service", time.Hour, 24*time.Hour)
+
+	creds, err := NewDatabaseCredentials(context.Background(), client, "database", "user-service", logrus.New())
+	if err != nil {
+		t.Fatalf("NewDatabaseCredentials: %v", err)
+	}
+
+	// Connections must close before the remaining sixth of a lease runs out
+	if got, want := creds.ConnMaxLifetime(), time.Hour/8; got != want {
+		t.Errorf("ConnMaxLifetime() = %v, want %v", got, want)
+	}
+}
+
+func TestDatabaseCredentialsRejectZeroLease(t *testing.T) {
+	server, client := newTestVault(t)
+	server.AddDatabaseRole("database", "user-service", 0, time.Hour)
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	if _, err := NewDatabaseCredentials(context.Background(), client, "database", "user-service", log); err == nil {
+		t.Fatal("NewDatabaseCredentials accepted a lease without a duration")
+	}
+
+	// A replacement lease without a duration is retried after retryInterval
+	// rather than used, which would renew it in a tight loop
+	server.AddDatabaseRole("database", "user-service", time.Second, time.Hour)
+	creds, err := NewDatabaseCredentials(context.Background(), client, "database", "user-service", log)
+	if err != nil {
+		t.Fatalf("NewDatabaseCredentials: %v", err)
+	}
+	first := creds.Current()
+	server.AddDatabaseRole("database", "user-service", 0, time.Hour)
+	server.RevokeLease(first.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rotated := make(chan Lease, 1)
+	done := make(chan struct{})
+	go func() {
+		creds.Run(ctx, func(lease Lease) { rotated <- lease })
+		close(done)
+	}()
+
+	time.Sleep(time.Second)
+	select {
+	case lease := <-rotated:
+		t.Errorf("rotated to %+v, which has no lease duration", lease)
+	default:
+	}
+	if current := creds.Current(); current != first {
+		t.Errorf("Current() = %+v, want %+v until a valid lease is issued", current, first)
+	}
+	if leases := server.Leases(); len(leases) > 2 {
+		t.Errorf("%d leases issued, want a single attempt within retryInterval", len(leases))
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after ctx was cancelled")
+	}
+}
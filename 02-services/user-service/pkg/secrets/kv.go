@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// KVProvider reads secrets from Vault KV v2 entries
+type KVProvider struct {
+	client *VaultClient
+	mount  string
+	refs   map[string]kvRef
+
+	// Entries read so far, so several fields of one entry cost one request
+	cache map[string]map[string]interface{}
+}
+
+type kvRef struct {
+	path  string
+	field string
+}
+
+// NewKVProvider creates a provider for mappings of the form
+// KEY=path#field, e.g. JWT_SECRET=jwt/user-service#secret
+func NewKVProvider(client *VaultClient, mount string, mappings []string) (*KVProvider, error) {
+	p := &KVProvider{
+		client: client,
+		mount:  mount,
+		refs:   map[string]kvRef{},
+		cache:  map[string]map[string]interface{}{},
+	}
+
+	for _, mapping := range mappings {
+		key, ref, ok := strings.Cut(mapping, "=")
+		path, field, hasField := strings.Cut(ref, "#")
+		if !ok || !hasField || key == "" || path == "" || field == "" {
+			return nil, fmt.Errorf("invalid VAULT_KV_SECRETS entry %q, expected KEY=path#field", mapping)
+		}
+		p.refs[key] = kvRef{path: path, field: field}
+	}
+
+	return p, nil
+}
+
+// Secret implements Provider
+func (p *KVProvider) Secret(ctx context.Context, key string) (string, bool, error) {
+	ref, ok := p.refs[key]
+	if !ok {
+		return "", false, nil
+	}
+
+	data, ok := p.cache[ref.path]
+	if !ok {
+		var err error
+		if data, err = p.client.ReadKV(ctx, p.mount, ref.path); err != nil {
+			return "", false, fmt.Errorf("%s: %w", key, err)
+		}
+		p.cache[ref.path] = data
+	}
+
+	value, ok := data[ref.field]
+	if !ok {
+		return "", false, fmt.Errorf("%s: vault secret %s/%s has no field %q", key, p.mount, ref.path, ref.field)
+	}
+
+	return fmt.Sprint(value), true, nil
+}
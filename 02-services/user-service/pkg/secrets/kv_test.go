@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/config"
+	"github.com/devsecops/user-service/pkg/secrets/vaulttest"
+)
+
+const rootToken = "root"
+
+func newTestVault(t *testing.T) (*vaulttest.Server, *VaultClient) {
+	t.Helper()
+	server := vaulttest.NewServer(rootToken)
+	t.Cleanup(server.Close)
+
+	client := NewVaultClient(&config.Config{
+		VaultAddr:       server.URL,
+		VaultAuthMethod: "token",
+		VaultToken:      rootToken,
+	}, http.DefaultClient)
+	return server, client
+}
+
+func TestReadKV(t *testing.T) {
+	server, client := newTestVault(t)
+	ctx := context.Background()
+
+	server.PutKV("secret", "user-service/db", map[string]interface{}{"password": "first"})
+	if version := server.PutKV("secret", "user-service/db", map[string]interface{}{"password": "second"}); version != 2 {
+		t.Fatalf("got version %d, want 2", version)
+	}
+
+	data, err := client.ReadKV(ctx, "secret", "user-service/db")
+	if err != nil {
+		t.Fatalf("ReadKV: %v", err)
+	}
+	if data["password"] != "second" {
+		t.Errorf("got password %v, want the latest version %q", data["password"], "second")
+	}
+
+	_, err = client.ReadKV(ctx, "secret", "user-service/missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing path: got error %v, want ErrNotFound", err)
+	}
+
+	server.RevokeToken(rootToken)
+	_, err = client.ReadKV(ctx, "secret", "user-service/db")
+	if !errors.Is(err, errPermissionDenied) {
+		t.Errorf("revoked token: got error %v, want permission denied", err)
+	}
+}
+
+func TestReadKVKubernetesLogin(t *testing.T) {
+	server := vaulttest.NewServer(rootToken)
+	t.Cleanup(server.Close)
+	server.PutKV("secret", "jwt", map[string]interface{}{"secret": "signing-key"})
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("service-account-jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewVaultClient(&config.Config{
+		VaultAddr:            server.URL,
+		VaultAuthMethod:      "kubernetes",
+		VaultRole:            "user-service",
+		VaultKubernetesMount: "kubernetes",
+	}, http.DefaultClient)
+	client.tokenPath = tokenPath
+	ctx := context.Background()
+
+	if _, err := client.ReadKV(ctx, "secret", "jwt"); err != nil {
+		t.Fatalf("ReadKV after login: %v", err)
+	}
+
+	// An expired token is replaced by logging in again
+	server.RevokeToken(client.token)
+	if _, err := client.ReadKV(ctx, "secret", "jwt"); err != nil {
+		t.Fatalf("ReadKV after token expiry: %v", err)
+	}
+}
+
+func TestKVProvider(t *testing.T) {
+	server, client := newTestVault(t)
+	server.PutKV("secret", "user-service", map[string]interface{}{
+		"jwt":      "signing-key",
+		"redis":    "redis-password",
+		"internal": 42,
+	})
+
+	provider, err := NewKVProvider(client, "secret", []string{
+		"JWT_SECRET=user-service#jwt",
+		"REDIS_PASSWORD=user-service#redis",
+		"INTERNAL_API_TOKEN=user-service#internal",
+		"DB_PASSWORD=user-service#db",
+		"VAULT_TOKEN=missing#token",
+	})
+	if err != nil {
+		t.Fatalf("NewKVProvider: %v", err)
+	}
+
+	tests := []struct {
+		key     string
+		want    string
+		wantOK  bool
+		wantErr string
+	}{
+		{key: "JWT_SECRET", want: "signing-key", wantOK: true},
+		{key: "REDIS_PASSWORD", want: "redis-password", wantOK: true},
+		{key: "INTERNAL_API_TOKEN", want: "42", wantOK: true},
+		{key: "DB_PASSWORD", wantErr: `has no field "db"`},
+		{key: "VAULT_TOKEN", wantErr: ErrNotFound.Error()},
+		{key: "UNMAPPED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			value, ok, err := provider.Secret(context.Background(), tt.key)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Secret: %v", err)
+			}
+			if value != tt.want || ok != tt.wantOK {
+				t.Errorf("got (%q, %v), want (%q, %v)", value, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	for _, mapping := range []string{"JWT_SECRET", "JWT_SECRET=path", "=path#field", "JWT_SECRET=#field", "JWT_SECRET=path#"} {
+		if _, err := NewKVProvider(client, "secret", []string{mapping}); err == nil {
+			t.Errorf("mapping %q: expected an error", mapping)
+		}
+	}
+}
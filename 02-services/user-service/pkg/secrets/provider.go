@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/devsecops/user-service/internal/config"
+)
+
+// Provider looks up secrets by the environment variable of the setting they
+// fill, e.g. DB_PASSWORD. ok is false when the provider has no value for key.
+type Provider interface {
+	Secret(ctx context.Context, key string) (value string, ok bool, err error)
+}
+
+// FileProvider reads secrets from files named by <KEY>_FILE environment
+// variables, e.g. DB_PASSWORD_FILE=/run/secrets/db-password
+type FileProvider struct{}
+
+// Secret implements Provider
+func (FileProvider) Secret(ctx context.Context, key string) (string, bool, error) {
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return "", false, nil
+	}
+	if os.Getenv(key) != "" {
+		return "", false, fmt.Errorf("%s and %s_FILE are mutually exclusive", key, key)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s_FILE: %w", key, err)
+	}
+
+	// Mounted secrets commonly end with a newline that is not part of the value
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// Apply fills the secret settings of cfg from providers. Earlier providers
+// take precedence; settings no provider knows keep their current value.
+func Apply(ctx context.Context, cfg *config.Config, providers ...Provider) error {
+	for _, key := range config.SecretKeys() {
+		for _, provider := range providers {
+			value, ok, err := provider.Secret(ctx, key)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := cfg.SetSecret(key, value); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads the configuration, resolves its secrets from mounted
+// files and Vault KV, and validates the result. The returned Vault client is
+// nil when Vault is not configured.
+func LoadConfig(ctx context.Context, path string) (*config.Config, *VaultClient, error) {
+	cfg, err := config.Read(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Files first, so the Vault token itself may come from VAULT_TOKEN_FILE
+	if err := Apply(ctx, cfg, FileProvider{}); err != nil {
+		return nil, nil, err
+	}
+
+	var vault *VaultClient
+	if cfg.VaultAddr != "" {
+		vault = NewVaultClient(cfg, &http.Client{Timeout: 10 * time.Second})
+
+		if len(cfg.VaultKVSecrets) > 0 {
+			kv, err := NewKVProvider(vault, cfg.VaultKVMount, cfg.VaultKVSecrets)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := Apply(ctx, cfg, FileProvider{}, kv); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, vault, nil
+}
@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devsecops/user-service/internal/config"
+)
+
+func writeSecretFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantOK  bool
+		wantErr string
+	}{
+		{
+			name: "unset",
+			env:  map[string]string{},
+		},
+		{
+			name:   "trailing newline is trimmed",
+			env:    map[string]string{"DB_PASSWORD_FILE": writeSecretFile(t, "s3cret\n")},
+			want:   "s3cret",
+			wantOK: true,
+		},
+		{
+			name:   "CRLF is trimmed, inner whitespace kept",
+			env:    map[string]string{"DB_PASSWORD_FILE": writeSecretFile(t, " pass word \r\n")},
+			want:   " pass word ",
+			wantOK: true,
+		},
+		{
+			name: "both value and file",
+			env: map[string]string{
+				"DB_PASSWORD":      "plain",
+				"DB_PASSWORD_FILE": writeSecretFile(t, "s3cret"),
+			},
+			wantErr: "mutually exclusive",
+		},
+		{
+			name:    "missing file",
+			env:     map[string]string{"DB_PASSWORD_FILE": filepath.Join(t.TempDir(), "missing")},
+			wantErr: "failed to read DB_PASSWORD_FILE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_PASSWORD", "")
+			t.Setenv("DB_PASSWORD_FILE", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			value, ok, err := FileProvider{}.Secret(context.Background(), "DB_PASSWORD")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Secret: %v", err)
+			}
+			if value != tt.want || ok != tt.wantOK {
+				t.Errorf("got (%q, %v), want (%q, %v)", value, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestApplyPrecedence(t *testing.T) {
+	server, client := newTestVault(t)
+	server.PutKV("secret", "user-service", map[string]interface{}{
+		"db":  "from-vault",
+		"jwt": "jwt-from-vault",
+	})
+	kv, err := NewKVProvider(client, "secret", []string{
+		"DB_PASSWORD=user-service#db",
+		"JWT_SECRET=user-service#jwt",
+	})
+	if err != nil {
+		t.Fatalf("NewKVProvider: %v", err)
+	}
+
+	for _, key := range config.SecretKeys() {
+		t.Setenv(key, "")
+		t.Setenv(key+"_FILE", "")
+	}
+	t.Setenv("DB_PASSWORD_FILE", writeSecretFile(t, "from-file\n"))
+
+	cfg := &config.Config{RedisPassword: "unchanged"}
+	if err := Apply(context.Background(), cfg, FileProvider{}, kv); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	// Files win over Vault; settings no provider knows keep their value
+	if cfg.DBPassword != "from-file" {
+		t.Errorf("got DBPassword %q, want %q", cfg.DBPassword, "from-file")
+	}
+	if cfg.JWTSecret != "jwt-from-vault" {
+		t.Errorf("got JWTSecret %q, want %q", cfg.JWTSecret, "jwt-from-vault")
+	}
+	if cfg.RedisPassword != "unchanged" {
+		t.Errorf("got RedisPassword %q, want %q", cfg.RedisPassword, "unchanged")
+	}
+}
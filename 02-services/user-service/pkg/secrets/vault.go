@@ -0,0 +1,230 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devsecops/user-service/internal/config"
+)
+
+// kubernetesTokenPath is where the pod's service account token is mounted
+const kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// ErrNotFound is returned when a Vault path holds no secret
+var ErrNotFound = errors.New("vault secret not found")
+
+var errPermissionDenied = errors.New("vault permission denied")
+
+// VaultClient is a minimal client for the parts of the Vault HTTP API used
+// by the service: KV v2 reads, the database secrets engine and leases
+type VaultClient struct {
+	addr            string
+	http            *http.Client
+	authMethod      string
+	role            string
+	kubernetesMount string
+	tokenPath       string
+
+	mu    sync.Mutex
+	token string
+}
+
+// Lease is a set of short-lived database credentials
+type Lease struct {
+	ID        string
+	Duration  time.Duration
+	Renewable bool
+	Username  string
+	Password  string
+}
+
+// vaultResponse is the envelope of every Vault API response
+type vaultResponse struct {
+	LeaseID       string          `json:"lease_id"`
+	LeaseDuration int             `json:"lease_duration"`
+	Renewable     bool            `json:"renewable"`
+	Data          json.RawMessage `json:"data"`
+	Auth          *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// NewVaultClient creates a client for the Vault server configured in cfg.
+// With the kubernetes auth method it logs in lazily using the pod's service
+// account token and logs in again when its token is rejected.
+func NewVaultClient(cfg *config.Config, httpClient *http.Client) *VaultClient {
+	return &VaultClient{
+		addr:            strings.TrimRight(cfg.VaultAddr, "/"),
+		http:            httpClient,
+		authMethod:      cfg.VaultAuthMethod,
+		role:            cfg.VaultRole,
+		kubernetesMount: cfg.VaultKubernetesMount,
+		tokenPath:       kubernetesTokenPath,
+		token:           cfg.VaultToken,
+	}
+}
+
+// ReadKV returns the latest version of a KV v2 secret
+func (c *VaultClient) ReadKV(ctx context.Context, mount, path string) (map[string]interface{}, error) {
+	resp, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/v1/%s/data/%s", mount, path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s/%s: %w", mount, path, err)
+	}
+
+	var data struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, fmt.Errorf("failed to decode vault secret %s/%s: %w", mount, path, err)
+	}
+
+	return data.Data, nil
+}
+
+// DatabaseCredentials issues new credentials from the database secrets engine
+func (c *VaultClient) DatabaseCredentials(ctx context.Context, mount, role string) (Lease, error) {
+	resp, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/v1/%s/creds/%s", mount, role), nil)
+	if err != nil {
+		return Lease{}, fmt.Errorf("failed to issue database credentials for role %s: %w", role, err)
+	}
+
+	var data struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return Lease{}, fmt.Errorf("failed to decode database credentials: %w", err)
+	}
+
+	return Lease{
+		ID:        resp.LeaseID,
+		Duration:  time.Duration(resp.LeaseDuration) * time.Second,
+		Renewable: resp.Renewable,
+		Username:  data.Username,
+		Password:  data.Password,
+	}, nil
+}
+
+// RenewLease extends a lease by increment and returns the TTL Vault granted,
+// which is shorter than requested as the lease approaches its max TTL
+func (c *VaultClient) RenewLease(ctx context.Context, leaseID string, increment time.Duration) (time.Duration, error) {
+	body := map[string]interface{}{
+		"lease_id":  leaseID,
+		"increment": int(increment.Seconds()),
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, "/v1/sys/leases/renew", body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to renew lease: %w", err)
+	}
+
+	return time.Duration(resp.LeaseDuration) * time.Second, nil
+}
+
+// do sends an authenticated request, logging in again once if the token was
+// rejected and can be replaced
+func (c *VaultClient) do(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	token, err := c.authToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.request(ctx, method, path, body, token)
+	if errors.Is(err, errPermissionDenied) && c.authMethod == "kubernetes" {
+		c.mu.Lock()
+		if c.token == token {
+			c.token = ""
+		}
+		c.mu.Unlock()
+
+		if token, err = c.authToken(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = c.request(ctx, method, path, body, token)
+	}
+
+	return resp, err
+}
+
+// authToken returns the current token, logging in first when needed
+func (c *VaultClient) authToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" || c.authMethod != "kubernetes" {
+		return c.token, nil
+	}
+
+	jwt, err := os.ReadFile(c.tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account token: %w", err)
+	}
+
+	body := map[string]string{"role": c.role, "jwt": strings.TrimSpace(string(jwt))}
+	resp, err := c.request(ctx, http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", c.kubernetesMount), body, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to log in to vault as role %s: %w", c.role, err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault login as role %s returned no token", c.role)
+	}
+
+	c.token = resp.Auth.ClientToken
+	return c.token, nil
+}
+
+func (c *VaultClient) request(ctx context.Context, method, path string, body interface{}, token string) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var resp vaultResponse
+	if res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(&resp); err != nil && res.StatusCode < 300 {
+			return nil, fmt.Errorf("failed to decode vault response: %w", err)
+		}
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode == http.StatusForbidden:
+		return nil, errPermissionDenied
+	case res.StatusCode >= 300:
+		return nil, fmt.Errorf("vault returned %d: %s", res.StatusCode, strings.Join(resp.Errors, "; "))
+	}
+
+	return &resp, nil
+}
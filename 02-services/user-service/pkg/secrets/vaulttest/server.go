@@ -0,0 +1,240 @@
+// Package vaulttest provides an in-memory fake of the Vault HTTP API for
+// exercising pkg/secrets without a real Vault server
+package vaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server fakes the Vault endpoints used by pkg/secrets: KV v2 reads,
+// Kubernetes login, the database secrets engine and lease renewal
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	tokens   map[string]bool
+	kv       map[string][]map[string]interface{}
+	roles    map[string]dbRole
+	leases   map[string]*Lease
+	sequence int
+}
+
+// Lease records credentials issued by the fake database secrets engine
+type Lease struct {
+	ID       string
+	Username string
+	Password string
+	Issued   time.Time
+	Expires  time.Time
+	MaxTTL   time.Duration
+}
+
+type dbRole struct {
+	ttl    time.Duration
+	maxTTL time.Duration
+}
+
+// NewServer starts a fake Vault accepting rootToken
+func NewServer(rootToken string) *Server {
+	s := &Server{
+		tokens: map[string]bool{rootToken: true},
+		kv:     map[string][]map[string]interface{}{},
+		roles:  map[string]dbRole{},
+		leases: map[string]*Lease{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// PutKV stores a new version of the KV v2 secret at mount/path and returns
+// its version number, starting at 1
+func (s *Server) PutKV(mount, path string, data map[string]interface{}) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kv[mount+"/"+path] = append(s.kv[mount+"/"+path], data)
+	return len(s.kv[mount+"/"+path])
+}
+
+// AddDatabaseRole lets mount/creds/role issue credentials with the given
+// lease TTL that can be renewed up to maxTTL
+func (s *Server) AddDatabaseRole(mount, role string, ttl, maxTTL time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[mount+"/"+role] = dbRole{ttl: ttl, maxTTL: maxTTL}
+}
+
+// RevokeToken invalidates a token, as if it had expired
+func (s *Server) RevokeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+}
+
+// RevokeLease revokes a lease, so renewing it fails
+func (s *Server) RevokeLease(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases, id)
+}
+
+// Leases returns the credentials issued so far, oldest first
+func (s *Server) Leases() []Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	leases := make([]Lease, 0, len(s.leases))
+	for i := 1; i <= s.sequence; i++ {
+		if lease, ok := s.leases[fmt.Sprintf("lease-%d", i)]; ok {
+			leases = append(leases, *lease)
+		}
+	}
+	return leases
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Login is the only unauthenticated endpoint
+	if strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login") {
+		s.login(w, r)
+		return
+	}
+
+	if !s.tokens[r.Header.Get("X-Vault-Token")] {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case path == "sys/leases/renew":
+		s.renew(w, r)
+	case strings.Contains(path, "/data/") && r.Method == http.MethodGet:
+		mount, secretPath, _ := strings.Cut(path, "/data/")
+		versions := s.kv[mount+"/"+secretPath]
+		version := len(versions)
+		if v := r.URL.Query().Get("version"); v != "" && v != "0" {
+			version, _ = strconv.Atoi(v)
+		}
+		if version < 1 || version > len(versions) {
+			writeError(w, http.StatusNotFound, "")
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     versions[version-1],
+				"metadata": map[string]interface{}{"version": version},
+			},
+		})
+	case strings.Contains(path, "/creds/") && r.Method == http.MethodGet:
+		s.issue(w, path)
+	default:
+		writeError(w, http.StatusNotFound, "")
+	}
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Role string `json:"role"`
+		JWT  string `json:"jwt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Role == "" || body.JWT == "" {
+		writeError(w, http.StatusBadRequest, "missing role or jwt")
+		return
+	}
+
+	s.sequence++
+	token := fmt.Sprintf("token-%d", s.sequence)
+	s.tokens[token] = true
+
+	writeJSON(w, map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": token},
+	})
+}
+
+func (s *Server) issue(w http.ResponseWriter, path string) {
+	mount, role, _ := strings.Cut(path, "/creds/")
+	cfg, ok := s.roles[mount+"/"+role]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "unknown role "+role)
+		return
+	}
+
+	s.sequence++
+	now := time.Now()
+	lease := &Lease{
+		ID:       fmt.Sprintf("lease-%d", s.sequence),
+		Username: fmt.Sprintf("v-%s-%d", role, s.sequence),
+		Password: fmt.Sprintf("password-%d", s.sequence),
+		Issued:   now,
+		Expires:  now.Add(cfg.ttl),
+		MaxTTL:   cfg.maxTTL,
+	}
+	s.leases[lease.ID] = lease
+
+	writeJSON(w, map[string]interface{}{
+		"lease_id":       lease.ID,
+		"lease_duration": int(cfg.ttl.Seconds()),
+		"renewable":      true,
+		"data": map[string]interface{}{
+			"username": lease.Username,
+			"password": lease.Password,
+		},
+	})
+}
+
+func (s *Server) renew(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		LeaseID   string `json:"lease_id"`
+		Increment int    `json:"increment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lease, ok := s.leases[body.LeaseID]
+	if !ok || time.Now().After(lease.Expires) {
+		writeError(w, http.StatusBadRequest, "lease not found or expired")
+		return
+	}
+
+	// Renewals never extend a lease past its max TTL
+	now := time.Now()
+	expires := now.Add(time.Duration(body.Increment) * time.Second)
+	if limit := lease.Issued.Add(lease.MaxTTL); expires.After(limit) {
+		expires = limit
+	}
+	lease.Expires = expires
+
+	writeJSON(w, map[string]interface{}{
+		"lease_id":       lease.ID,
+		"lease_duration": int(expires.Sub(now).Seconds()),
+		"renewable":      true,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	errs := []string{}
+	if message != "" {
+		errs = append(errs, message)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}
@@ -0,0 +1,29 @@
+# User Service Policy for Vault
+# Grants user-service its static secrets and short-lived database credentials
+
+path "secret/data/jwt/user-service" {
+  capabilities = ["read"]
+}
+
+path "secret/data/redis/*" {
+  capabilities = ["read"]
+}
+
+# Dynamic PostgreSQL credentials (VAULT_DATABASE_ROLE)
+path "database/creds/user-service" {
+  capabilities = ["read"]
+}
+
+# Allow renewing the database credentials lease
+path "sys/leases/renew" {
+  capabilities = ["update"]
+}
+
+# Allow the service to manage its own token
+path "auth/token/renew-self" {
+  capabilities = ["update"]
+}
+
+path "auth/token/lookup-self" {
+  capabilities = ["read"]
+}